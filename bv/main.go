@@ -3,17 +3,18 @@ package main
 
 import (
 	"code.google.com/p/ncabatoff/imglib"
+	"code.google.com/p/ncabatoff/imglib/imgdraw"
 	"code.google.com/p/ncabatoff/imgseq"
 	"code.google.com/p/ncabatoff/vlib"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/golang/glog"
-	"image"
 	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
-	"syscall"
+	"time"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
@@ -38,6 +39,33 @@ var (
 
 	flagMillis int
 	flagStart int
+
+	// How many images to load ahead of the current one when reading a
+	// directory, and the memory to use for them.
+	flagPrefetch int
+	flagCacheMB  int
+
+	// The naming scheme of the files in a directory being viewed.
+	flagNames string
+
+	// If set, the time of the first image to show, overriding -start.
+	flagAt string
+
+	// Which files of a directory to show.
+	flagRecursive bool
+	flagGlob      string
+
+	// If nonzero, each frame is shown beside the frame this many after it and
+	// their difference.
+	flagDiff     int
+	flagDiffGain int
+
+	// If set, show images as they're written to the input by a capture in
+	// progress.
+	flagFollow bool
+
+	// Whether to outline the motion rects recorded in image metadata.
+	flagRects bool
 )
 
 func init() {
@@ -61,6 +89,27 @@ func init() {
 		"Millisecond delay between frames in play mode")
 	flag.IntVar(&flagStart, "start", 0,
 		"starting frame")
+	flag.IntVar(&flagPrefetch, "prefetch", imgseq.DefaultPrefetchConfig.Ahead,
+		"Number of images to load in advance when reading a directory; 0 disables.")
+	flag.StringVar(&flagNames, "names", "",
+		"If set, how files in a directory are named, as for capture's -names; only matching files are shown.")
+	flag.StringVar(&flagAt, "at", "",
+		"Start at the first image created at or after this local time, e.g. '2014-05-13 16:30:00'.")
+	flag.BoolVar(&flagRecursive, "recursive", false,
+		"Include images in subdirectories of the input directory.")
+	flag.StringVar(&flagGlob, "glob", "",
+		"If set, only show files in the input directory whose names match this pattern, e.g. '*.png'.")
+	flag.IntVar(&flagCacheMB, "cachemb", int(imgseq.DefaultPrefetchConfig.MaxBytes>>20),
+		"Megabytes of memory to use for prefetched images.")
+
+	flag.IntVar(&flagDiff, "diff", 0,
+		"If nonzero, show each frame beside the one this many frames later and their diff.")
+	flag.IntVar(&flagDiffGain, "diffgain", 4,
+		"Multiplier applied to differences in the -diff image.")
+	flag.BoolVar(&flagRects, "rects", true,
+		"Outline the motion rects recorded in the metadata of images, e.g. by bvm -savemeta.")
+	flag.BoolVar(&flagFollow, "follow", false,
+		"Show new images as they're written to the input directory or raw file, like tail -f.")
 
 	// flag.IntVar(&flagStartFrame, "start", 0,
 	//		"If set, bv will start at this frame")
@@ -112,77 +161,196 @@ func main() {
 		usage()
 	}
 
-	fi, err := os.Stat(flag.Arg(0))
-	if err != nil {
-		glog.Fatalf("unable to stat input '%s': %v", flag.Arg(0), err)
+	if flagFollow {
+		follow(flag.Arg(0))
+		return
+	}
+
+	seq := openSequence(flag.Arg(0))
+	defer func() {
+		lp("close err=%v", seq.Close())
+	}()
+	if seq.Len() == 0 {
+		glog.Fatalf("no images in %s", flag.Arg(0))
+	}
+	if flagAt != "" {
+		if t, err := time.ParseInLocation(atLayout, flagAt, time.Local); err != nil {
+			glog.Fatalf("bad -at time '%s', expected the form '%s'", flagAt, atLayout)
+		} else if flagStart = seq.IndexAt(t); flagStart == seq.Len() {
+			glog.Fatalf("no images at or after %v", t)
+		}
+	}
+	glog.Infof("starting viewer for %d images", seq.Len())
+	// Rects are outlined after diffing, so that they don't show up as
+	// differences; they land on the first frame of each pair.
+	vlib.ViewImages(withRects(withDiff(seq, vlib.SequenceFetcher(seq))), flagMillis, flagStart)
+}
+
+// prefetch wraps seq in a Prefetcher configured by -prefetch and -cachemb.
+func prefetch(seq imgseq.Sequence) imgseq.Sequence {
+	if flagPrefetch <= 0 {
+		return seq
 	}
-	if fi.IsDir() {
-		viewDir(flag.Arg(0))
-	} else if fi.Mode().IsRegular() {
-		viewFileMmap(flag.Arg(0))
+	cfg := imgseq.DefaultPrefetchConfig
+	cfg.Ahead, cfg.Behind = flagPrefetch, (flagPrefetch+3)/4
+	cfg.MaxBytes = int64(flagCacheMB) << 20
+	return imgseq.NewPrefetcher(seq, cfg)
+}
+
+// scanOptions returns the options choosing the files of a directory given by
+// -names, -recursive and -glob, in creation time order.
+func scanOptions() imgseq.ScanOptions {
+	opts := imgseq.ScanOptions{Recursive: flagRecursive, Glob: flagGlob, ByTime: true}
+	if flagNames != "" {
+		if ns, err := imgseq.ParseNameScheme(flagNames); err != nil {
+			glog.Fatalf("%v", err)
+		} else {
+			opts.Names, opts.NamedOnly = ns, true
+			opts.Recursive = opts.Recursive || ns.DateDirs
+		}
 	}
+	return opts
 }
 
-func viewDir(path string) {
-	if dl, err := imgseq.GetDirList(path); err != nil {
+// getDirList returns the files in the directory at path chosen by
+// scanOptions.
+func getDirList(path string) imgseq.DirList {
+	dl, err := imgseq.ScanDir(path, scanOptions())
+	if err != nil {
 		glog.Fatalf("error reading directory '%s': %v", path, err)
-	} else {
-		iinfos := dl.ImgInfos()
-
-		glog.Infof("starting viewer for %d images", len(iinfos))
-		vlib.ViewImages(func(i int) (int, []imgseq.Img) {
-			lg("got %d", i)
-			if i >= len(iinfos) {
-				i = 0
-			}
-			if i < 0 {
-				i = len(iinfos) - 1
-			}
-			return i, []imgseq.Img{imgseq.LoadRawImgOrDie(dl.ImgInfos()[i])}
-		}, flagMillis, flagStart)
 	}
+	return dl
 }
 
-func viewFileMmap(path string) {
-	rect := image.Rect(0, 0, flagWidth, flagHeight)
-	imgsize := 2*(rect.Size().X * rect.Size().Y)
-	var buffer []byte
-	var fileSize int64
-	if fl, err := os.Open(path); err != nil {
-		glog.Fatalf("unable to open %s: %v", path, err)
+// atLayout is the form of the -at flag.
+const atLayout = "2006-01-02 15:04:05"
+
+// openSequence returns the images at path, which may be a directory, a
+// frame store as written by capture -store, a frame container, a YUV4MPEG2 file, or a file of bare concatenated YUYV
+// frames whose geometry is given by the -width and -height flags.
+func openSequence(path string) imgseq.Sequence {
+	fi, err := os.Stat(path)
+	if err != nil {
+		glog.Fatalf("unable to stat input '%s': %v", path, err)
+	}
+	var seq imgseq.Sequence
+	if fi.IsDir() && imgseq.IsStore(path) {
+		var st *imgseq.Store
+		if st, err = imgseq.OpenStore(path, imgseq.DefaultStoreConfig); err == nil {
+			seq, err = st.Range(time.Time{}, time.Time{})
+		}
+	} else if fi.IsDir() {
+		seq = prefetch(imgseq.NewDirSequence(getDirList(path)))
+	} else if ext := sniffExt(path); ext == imglib.Y4MExt || ext == imglib.FramesExt {
+		seq, err = imgseq.OpenSequence(path)
 	} else {
-		if fi, err := fl.Stat(); err != nil {
-			glog.Fatalf("unable to stat %s: %v", path, err)
+		rf := imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: flagWidth, Height: flagHeight}
+		seq, err = imgseq.OpenRawSequence(path, rf)
+	}
+	if err != nil {
+		glog.Fatalf("unable to open %s: %v", path, err)
+	}
+	return seq
+}
+
+// follow shows the images written to path from now on until the window is
+// closed: new files in a directory, as chosen by scanOptions, or frames
+// appended to a file of bare concatenated YUYV frames whose geometry is given
+// by the -width and -height flags.
+func follow(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		glog.Fatalf("unable to stat input '%s': %v", path, err)
+	}
+	if fi.IsDir() && imgseq.IsStore(path) {
+		glog.Fatalf("-follow doesn't support stores")
+	}
+	imagechan := make(chan imgseq.Img)
+	go func() {
+		var err error
+		if fi.IsDir() {
+			err = imgseq.WatchDir(context.Background(), path, scanOptions(), imgseq.DefaultWatchConfig, imagechan)
 		} else {
-			fileSize = fi.Size()
+			rf := imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: flagWidth, Height: flagHeight}
+			err = imgseq.FollowRawFile(context.Background(), path, rf, imgseq.DefaultWatchConfig, imagechan)
 		}
+		glog.Fatalf("error following %s: %v", path, err)
+	}()
 
-		if buffer, err = syscall.Mmap(int(fl.Fd()), 0, int(fileSize),
-				syscall.PROT_READ, syscall.MAP_SHARED); err != nil {
-			glog.Fatalf("unable to mmap %s: %v", path, err)
+	imgdisp := make(chan []imgseq.Img)
+	go func() {
+		for img := range imagechan {
+			imgdisp <- []imgseq.Img{img}
 		}
+	}()
+	vlib.StreamImages(imgdisp)
+}
+
+// sniffExt returns the extension matching the content of the file at path,
+// falling back to the one it actually has.
+func sniffExt(path string) string {
+	if ext, err := imglib.SniffExt(path); err != nil {
+		glog.Fatalf("unable to read %s: %v", path, err)
+	} else if ext != "" {
+		return ext
 	}
-	numimgs := int(fileSize) / imgsize
+	return filepath.Ext(path)
+}
 
-	defer func(buf []byte) {
-		err := syscall.Munmap(buf)
-		lp("unmap err=%v", err)
-	}(buffer)
+// withRects wraps fetch so that if -rects was given, the motion rects recorded
+// in the metadata of each image are outlined on a copy of it.
+func withRects(fetch vlib.ImageFetcher) vlib.ImageFetcher {
+	if !flagRects {
+		return fetch
+	}
+	return func(i int) (int, []imgseq.Img) {
+		i, imgs := fetch(i)
+		out := make([]imgseq.Img, len(imgs))
+		for j, img := range imgs {
+			out[j] = imgseq.OutlineMotion(img)
+		}
+		return i, out
+	}
+}
 
-	vlib.ViewImages(func(i int) (int, []imgseq.Img) {
-		lg("got %d", i)
-		if i >= numimgs {
-			i = 0
+// withDiff wraps fetch so that if -diff was given, each frame is returned as a
+// single image showing it, the frame -diff after it, and their difference.
+// The PSNR and SSIM of the pair are logged.  The other frame is read directly
+// from seq rather than through fetch, so as not to disturb its notion of which
+// way the viewer is stepping; if it's outside seq or can't be loaded, the frame
+// is shown alone.
+func withDiff(seq imgseq.Sequence, fetch vlib.ImageFetcher) vlib.ImageFetcher {
+	if flagDiff == 0 {
+		return fetch
+	}
+	return func(i int) (int, []imgseq.Img) {
+		i, imgs := fetch(i)
+		if len(imgs) == 0 {
+			return i, imgs
 		}
-		if i < 0 {
-			i = numimgs - 1
+		a := imgs[0].GetImage()
+		j := i + flagDiff
+		if j < 0 || j >= seq.Len() {
+			glog.Infof("no frame %d to diff frame %d with", j, i)
+			return i, imgs
+		}
+		other, err := seq.At(j)
+		if err != nil {
+			glog.Errorf("can't load frame %d to diff with: %v", j, err)
+			return i, imgs
 		}
-		pix := buffer[imgsize*i:imgsize*i+imgsize]
-		yuyv := &imglib.YUYV{Pix: pix, Stride: rect.Dx()*2, Rect: rect}
-		iinfo := imgseq.ImgInfo{SeqNum: i}
-		img := &imgseq.RawImg{iinfo, imglib.GetPixelSequence(yuyv)}
-		return i, []imgseq.Img{img}
-	}, flagMillis, flagStart)
+		b := other.GetImage()
+		diff, err := imglib.Diff(a, b, flagDiffGain)
+		if err != nil {
+			glog.Errorf("can't diff frames %d and %d: %v", i, j, err)
+			return i, imgs
+		}
+		psnr, _ := imglib.PSNR(a, b)
+		ssim, _ := imglib.SSIM(a, b)
+		glog.Infof("frames %d,%d: psnr=%.2fdB ssim=%.4f", i, j, psnr, ssim)
+		out := imgdraw.SideBySide(4, a, b, diff)
+		return i, []imgseq.Img{&imgseq.RawImg{ImgInfo: imgs[0].GetImgInfo(), PixelSequence: imglib.GetPixelSequence(out)}}
+	}
 }
 
 func getFileAndSize(path string) (*os.File, int64) {
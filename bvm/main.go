@@ -1,17 +1,19 @@
-// bvm demonstrates the motion package using a directory of images as input.
+// bvm demonstrates the motion package using a directory of images, a frame
+// container or a YUV4MPEG2 file as input.
 package main
 
 import (
 	"code.google.com/p/ncabatoff/imglib"
+	"code.google.com/p/ncabatoff/imglib/imgdraw"
+	"code.google.com/p/ncabatoff/imglib/imgfilter"
 	"code.google.com/p/ncabatoff/imgseq"
 	"code.google.com/p/ncabatoff/motion"
 	"code.google.com/p/ncabatoff/vlib"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/golang/glog"
 	"image"
-	"image/color"
-	"image/draw"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -43,8 +45,29 @@ var (
 	flagMinArea       int
 	flagMaxArea       int
 	flagMinSquareness int
+	flagBlur          float64
+	flagOpen          int
 	flagMillis int
 	flagStart int
+
+	// How many images to load ahead of the current one when reading a
+	// directory, and the memory to use for them.
+	flagPrefetch int
+	flagCacheMB  int
+
+	// Whether to include the subdirectories of a directory being read.
+	flagRecursive bool
+
+	// If set, track motion in images as they're written to the input by a
+	// capture in progress.
+	flagFollow bool
+
+	// If set, record the motion rects found in each image in its metadata.
+	flagSaveMeta bool
+
+	// How the tracker models the background, and over how many frames.
+	flagBgModel string
+	flagWindow  int
 )
 
 func init() {
@@ -68,12 +91,31 @@ func init() {
 		"Millisecond delay between frames in play mode")
 	flag.IntVar(&flagStart, "start", 0,
 		"starting frame")
+	flag.IntVar(&flagPrefetch, "prefetch", imgseq.DefaultPrefetchConfig.Ahead,
+		"Number of images to load in advance when reading a directory; 0 disables.")
+	flag.BoolVar(&flagRecursive, "recursive", false,
+		"Include images in subdirectories of the input directory.")
+	flag.IntVar(&flagCacheMB, "cachemb", int(imgseq.DefaultPrefetchConfig.MaxBytes>>20),
+		"Megabytes of memory to use for prefetched images.")
+	flag.BoolVar(&flagSaveMeta, "savemeta", false,
+		"Record the motion rects found in each image in the metadata file of its directory, for bv to show.")
+	flag.BoolVar(&flagFollow, "follow", false,
+		"Track motion in new images as they're written to the input directory, like tail -f.")
 
 	flag.IntVar(&flagDeltaThresh, "deltaThresh", 32*69,
 		"The delta filter threshold.")
 	flag.IntVar(&flagMinArea, "minarea", 20, "minimum rect area")
 	flag.IntVar(&flagMaxArea, "maxarea", 200, "maximum rect area")
 	flag.IntVar(&flagMinSquareness, "minsquareness", 2, "max h:w or w:h ratio")
+	flag.Float64Var(&flagBlur, "blur", 0,
+		"If >0, sigma of the Gaussian blur applied to frames before tracking.")
+	flag.IntVar(&flagOpen, "open", 0,
+		"If >0, radius of the opening applied to the motion mask to remove specks.")
+	flag.StringVar(&flagBgModel, "bgmodel", motion.DefaultTrackerConfig.Model.String(),
+		"Background model: mean of the last -window frames; ema, an exponential moving average needing no stored frames; "+
+			"or variance, which also learns how much each pixel varies, taking -deltaThresh in standard deviations (see motion.VarianceUnit).")
+	flag.IntVar(&flagWindow, "window", motion.DefaultTrackerConfig.Window,
+		"Number of frames the background is averaged over; motion is only found after this many.")
 	flag.Usage = usage
 	flag.Parse()
 
@@ -123,7 +165,19 @@ func main() {
 		usage()
 	}
 
-	viewDir(flag.Arg(0))
+	if flagFollow {
+		follow(flag.Arg(0))
+		return
+	}
+
+	seq := openSequence(flag.Arg(0))
+	defer func() {
+		lp("close err=%v", seq.Close())
+	}()
+	if window := trackerConfig().Window; seq.Len() <= window {
+		glog.Fatalf("need more than %d images to track motion, %s has %d", window, flag.Arg(0), seq.Len())
+	}
+	viewSequence(seq)
 }
 
 func filtRects(rects []image.Rectangle) []image.Rectangle {
@@ -143,86 +197,207 @@ func filtRects(rects []image.Rectangle) []image.Rectangle {
 	return out
 }
 
+// scanOptions chooses the .yuv files in a directory, and in its
+// subdirectories if -recursive was given, in creation time order.
+func scanOptions() imgseq.ScanOptions {
+	return imgseq.ScanOptions{Recursive: flagRecursive, Exts: []string{".yuv"}, ByTime: true}
+}
+
+// getDirList returns the files in the directory at path chosen by
+// scanOptions.
 func getDirList(path string) imgseq.DirList {
-	if dl, err := imgseq.GetDirList(path); err != nil {
+	if dl, err := imgseq.ScanDir(path, scanOptions()); err != nil {
 		glog.Fatalf("error reading directory '%s': %v", path, err)
 		return imgseq.DirList{}
 	} else {
-		filtdl := dl
-		filtdl.Files = make([]string, 0, len(dl.Files))
-		for _, fn := range dl.Files {
-			if filepath.Ext(fn) == ".yuv" {
-				filtdl.Files = append(filtdl.Files, fn)
-			}
-		}
-		return filtdl
+		return dl
+	}
+}
+
+// prefetch wraps seq in a Prefetcher configured by -prefetch and -cachemb.
+func prefetch(seq imgseq.Sequence) imgseq.Sequence {
+	if flagPrefetch <= 0 {
+		return seq
+	}
+	cfg := imgseq.DefaultPrefetchConfig
+	cfg.Ahead, cfg.Behind = flagPrefetch, (flagPrefetch+3)/4
+	cfg.MaxBytes = int64(flagCacheMB) << 20
+	return imgseq.NewPrefetcher(seq, cfg)
+}
+
+// openSequence returns the images at path: the .yuv files in it if it's a
+// directory, and otherwise the frames of the container file.
+func openSequence(path string) imgseq.Sequence {
+	if fi, err := os.Stat(path); err != nil {
+		glog.Fatalf("unable to stat input '%s': %v", path, err)
+	} else if fi.IsDir() {
+		return prefetch(imgseq.NewDirSequence(getDirList(path)))
+	}
+	seq, err := imgseq.OpenSequence(path)
+	if err != nil {
+		glog.Fatalf("unable to open %s: %v", path, err)
 	}
+	return seq
 }
 
-func viewDir(path string) {
-	dl := getDirList(path)
-	iinfos := dl.ImgInfos()
+// load returns the ith image of seq, or nil if it can't be loaded, in which
+// case the error is logged.
+func load(seq imgseq.Sequence, i int) imgseq.Img {
+	img, err := seq.At(i)
+	if err != nil {
+		glog.Errorf("skipping image %d: %v", i, err)
+	}
+	return img
+}
 
-	trk := motion.NewTracker()
+// trackerConfig returns the tracker configuration given by -bgmodel and
+// -window.
+func trackerConfig() motion.TrackerConfig {
+	model, err := motion.ParseBackgroundModel(flagBgModel)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	return motion.TrackerConfig{Model: model, Window: flagWindow}
+}
+
+func viewSequence(seq imgseq.Sequence) {
+	cfg := trackerConfig()
+	trk := motion.NewTrackerWithConfig(cfg)
 
 	lasti := 0
-	for i := 0; i <= motion.LAVGN; i++ {
-		trk.GetRects(imgseq.LoadRawImgOrDie(dl.ImgInfos()[i]), flagDeltaThresh)
+	for i := 0; i <= cfg.Window; i++ {
+		if img := load(seq, i); img != nil {
+			trk.GetRects(trackerInput(img), flagDeltaThresh)
+		}
 		lasti++
 	}
 
 	vlib.ViewImages(func(i int) (int, []imgseq.Img) {
 		lg("got %d", i)
-		if i >= len(iinfos) {
+		if i >= seq.Len() {
 			i = 0
 		}
-		if i < motion.LAVGN {
-			i = motion.LAVGN - 1
+		if i < cfg.Window {
+			i = cfg.Window - 1
 		}
 		if i != lasti+1 {
-			trk = motion.NewTracker()
-			for j := i; j <= i+motion.LAVGN; j++ {
-				trk.GetRects(imgseq.LoadRawImgOrDie(dl.ImgInfos()[j]), flagDeltaThresh)
+			trk = motion.NewTrackerWithConfig(cfg)
+			for j := i; j <= i+cfg.Window && j < seq.Len(); j++ {
+				if img := load(seq, j); img != nil {
+					trk.GetRects(trackerInput(img), flagDeltaThresh)
+				}
 			}
 		}
 		defer func() {
 			lasti = i
 		}()
 
-		for {
-			img := imgseq.LoadRawImgOrDie(dl.ImgInfos()[i])
+		// Give up once every frame has failed to load, as SequenceFetcher does.
+		for fails := 0; fails < seq.Len(); {
+			img := load(seq, i)
+			if img == nil {
+				fails++
+				if i++; i >= seq.Len() {
+					i = cfg.Window - 1
+				}
+				continue
+			}
 			if imgout := filterInactive(trk, img); len(imgout) > 0 {
 				return i, imgout
 			}
 		}
+		glog.Errorf("no frames could be loaded")
+		return i, nil
 	}, flagMillis, flagStart)
 }
 
+// follow tracks motion in the images written to the directory at path from
+// now on, showing those with activity until the window is closed.
+func follow(path string) {
+	if fi, err := os.Stat(path); err != nil {
+		glog.Fatalf("unable to stat input '%s': %v", path, err)
+	} else if !fi.IsDir() {
+		glog.Fatalf("-follow needs a directory, %s isn't one", path)
+	}
+	imagechan := make(chan imgseq.Img)
+	go func() {
+		err := imgseq.WatchDir(context.Background(), path, scanOptions(), imgseq.DefaultWatchConfig, imagechan)
+		glog.Fatalf("error following %s: %v", path, err)
+	}()
+
+	imgdisp := make(chan []imgseq.Img)
+	go func() {
+		cfg := trackerConfig()
+		trk := motion.NewTrackerWithConfig(cfg)
+		n := 0
+		for img := range imagechan {
+			// The tracker needs a window of images before it can say what's
+			// moving.
+			if n++; n <= cfg.Window {
+				trk.GetRects(trackerInput(img), flagDeltaThresh)
+			} else if imgout := filterInactive(trk, img); len(imgout) > 0 {
+				imgdisp <- imgout
+			}
+		}
+	}()
+	vlib.StreamImages(imgdisp)
+}
+
+// filter holds the scratch buffers for -blur and -open.
+var filter imgfilter.Filter
+
+// trackerInput returns the image to give the tracker for simg: simg itself, or
+// a blurred copy of it if -blur was given.
+func trackerInput(simg imgseq.Img) imgseq.Img {
+	if flagBlur <= 0 {
+		return simg
+	}
+	ps := simg.GetPixelSequence()
+	pix := append([]byte(nil), ps.GetBytes()...)
+	img, err := imglib.GetPixelFormat(ps.ImageBytes).NewImage(pix, ps.GetStride(), ps.Bounds())
+	if err != nil {
+		glog.Fatalf("can't blur %v: %v", simg.GetImgInfo(), err)
+	}
+	filter.GaussianBlur(img, flagBlur)
+	return &imgseq.RawImg{simg.GetImgInfo(), imglib.GetPixelSequence(img)}
+}
+
 func filterInactive(trk *motion.Tracker, simg imgseq.Img) []imgseq.Img {
 	oimg := simg.GetImage()
 	iinfo := simg.GetImgInfo()
 	glog.V(1).Infof("filtering %s img %v", oimg.Bounds(), iinfo)
-	rs := trk.GetRects(simg, flagDeltaThresh)
-	if len(rs) == 0 {
-		return []imgseq.Img{}
+	var rs []image.Rectangle
+	if flagOpen > 0 {
+		mask := trk.GetMask(trackerInput(simg), flagDeltaThresh)
+		filter.Open(mask, flagOpen)
+		rs = motion.MaskRects(mask)
+	} else {
+		rs = trk.GetRects(trackerInput(simg), flagDeltaThresh)
+	}
+	if len(rs) > 0 {
+		rs = filtRects(rs)
+	}
+	if flagSaveMeta {
+		saveRects(iinfo, rs)
 	}
-	rs = filtRects(rs)
 	if len(rs) == 0 {
 		return []imgseq.Img{}
 	}
 	sort.Sort(motion.RectAreaSlice(rs))
 	ops := imglib.GetPixelSequence(imglib.StdImage{oimg}.GetRGBA())
-	rps := imglib.GetPixelSequence(getRectImage(simg, rs))
+	rps := imglib.GetPixelSequence(imgdraw.IsolateRects(oimg, rs))
 	return []imgseq.Img{&imgseq.RawImg{iinfo, ops}, &imgseq.RawImg{iinfo, rps}}
 }
 
-func getRectImage(simg imgseq.Img, rects []image.Rectangle) image.Image {
-	img := simg.GetImage()
-	irect := img.Bounds()
-	out := image.NewRGBA(irect)
-	for _, r := range rects {
-		draw.Draw(out, r.Inset(-1), &image.Uniform{color.White}, image.ZP, draw.Src)
-		draw.Draw(out, r, img, r.Min, draw.Src)
+// saveRects records rs as the motion rects of the image described by iinfo,
+// keeping whatever else is known about it.
+func saveRects(iinfo imgseq.ImgInfo, rs []image.Rectangle) {
+	var md imgseq.Metadata
+	if iinfo.Meta != nil {
+		md = *iinfo.Meta
+	}
+	md.Rects = rs
+	if err := imgseq.AppendMetadata(iinfo, &md); err != nil {
+		glog.Errorf("unable to save metadata of %s: %v", iinfo.Path, err)
 	}
-	return out
 }
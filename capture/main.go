@@ -2,13 +2,18 @@
 package main
 
 import (
+	"code.google.com/p/ncabatoff/imglib"
+	"code.google.com/p/ncabatoff/imglib/imgdraw"
 	"code.google.com/p/ncabatoff/imgseq"
 	"code.google.com/p/ncabatoff/v4l"
 	"code.google.com/p/ncabatoff/vlib"
 	"flag"
 	"fmt"
 	"github.com/golang/glog"
+	"image"
+	"image/color"
 	"os"
+	"path/filepath"
 	"runtime"
 	"time"
 )
@@ -16,7 +21,8 @@ import (
 // import "github.com/davecheney/profile"
 
 var flagInput = flag.String("in", "/dev/video0", "input capture device")
-var flagOutfile = flag.String("outfile", "", "write frames consecutively to output file, overwriting if exists")
+var flagOutfile = flag.String("outfile", "", "write frames consecutively to output file, overwriting if exists; use a .y4m extension to write YUV4MPEG2")
+var flagRawOut = flag.Bool("rawout", false, "with -outfile, write bare concatenated frames without a container header")
 var flagWidth = flag.Int("width", 640, "width in pixels")
 var flagHeight = flag.Int("height", 480, "height in pixels")
 var flagFormat = flag.String("format", "yuv", "format yuv or rgb")
@@ -24,14 +30,29 @@ var flagFrames = flag.Int("frames", 0, "frames to capture")
 var flagDiscard = flag.Bool("discard", false, "discard frames")
 var flagFps = flag.Int("fps", 0, "frames per second")
 var flagDisplay = flag.Bool("display", false, "display images")
+var flagSaveAs = flag.String("saveas", "raw", "format of the per-frame files written when -outfile isn't given: raw, png, jpeg, ppm or pgm")
+var flagStamp = flag.Bool("stamp", false, "burn the capture time into the top-left corner of each frame")
+var flagStats = flag.Bool("stats", false, "log luma/chroma statistics of each frame")
+var flagStore = flag.String("store", "", "write frames to a time-partitioned store in this directory instead of per-frame files")
+var flagMaxAge = flag.Duration("maxage", 0, "with -store, delete frames older than this, e.g. 72h")
+var flagMaxMB = flag.Int64("maxmb", 0, "with -store, delete the oldest frames to keep the store under this many megabytes")
+var flagNames = flag.String("names", imgseq.DefaultNameScheme.String(), "naming of per-frame files: comma-separated prefix=P, camera=C, seq=N (zero-padded digits) and datedirs (YYYY/MM/DD/HH subdirectories)")
+var flagMeta = flag.Bool("meta", false, "record the camera, device and format of per-frame files in a metadata file in their directory")
+var flagOrient = flag.String("orient", "none", "transform applied to frames: none, rot90, rot180, rot270, fliph, flipv, transpose or transverse")
 
 func main() {
 	flag.Usage = func() {
 	    fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	    flag.PrintDefaults()
 	    fmt.Fprintf(os.Stderr, `\ncapture reads from a video device like a webcam.  
-By default images are written to the current dir in .yuv files.
-Use -outfile to write all frames to a single file instead.
+By default images are written to the current dir in .yuv (or .rgb) files,
+described by a rawinfo file so that other tools know their dimensions;
+use -saveas to write png, jpeg, ppm or pgm files instead, and -names to
+choose how they're named, e.g. -names camera=front,seq=8,datedirs.
+Use -store to keep frames in hourly segments under a directory for continuous
+monitoring, with -maxage and -maxmb limiting how much is kept.
+Use -outfile to write all frames to a single .frames container instead,
+or to a YUV4MPEG2 stream if the filename ends in .y4m.
 Use -discard to not write any data to disk at all; normally used with -display.
 `)
 	}
@@ -45,7 +66,23 @@ Use -discard to not write any data to disk at all; normally used with -display.
 		glog.Flush()
 	}()
 
-	cs := v4l.NewStream(*flagInput, *flagFps, *flagFormat, *flagWidth, *flagHeight, nil)
+	switch *flagSaveAs {
+	case "raw", "png", "jpeg", "ppm", "pgm":
+	default:
+		glog.Fatalf("unsupported -saveas format '%s'", *flagSaveAs)
+	}
+
+	if ns, err := imgseq.ParseNameScheme(*flagNames); err != nil {
+		glog.Fatalf("%v", err)
+	} else {
+		names = ns
+	}
+
+	orient, err := imglib.ParseOrientation(*flagOrient)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	cs := v4l.NewOrientedStream(*flagInput, *flagFps, *flagFormat, *flagWidth, *flagHeight, orient, nil)
 	defer func() {
 		cs.Shutdown()
 	}()
@@ -56,12 +93,43 @@ Use -discard to not write any data to disk at all; normally used with -display.
 	}
 
 	var outfile *os.File
+	var framesout *imglib.FramesWriter
+	var y4mout *imglib.Y4MWriter
 	if *flagOutfile != "" {
-		if f, err := os.OpenFile(*flagOutfile, os.O_WRONLY|os.O_CREATE, 0777); err != nil {
+		if f, err := os.Create(*flagOutfile); err != nil {
 			glog.Fatalf("unable to open output '%s': %v", *flagOutfile, err)
 		} else {
 			outfile = f
 		}
+		defer func() {
+			if y4mout != nil {
+				if err := y4mout.Flush(); err != nil {
+					glog.Errorf("error finishing '%s': %v", *flagOutfile, err)
+				}
+			}
+			if framesout != nil {
+				if err := framesout.Close(); err != nil {
+					glog.Errorf("error finishing '%s': %v", *flagOutfile, err)
+				}
+			}
+			outfile.Close()
+		}()
+	}
+
+	var store *imgseq.Store
+	if *flagStore != "" {
+		cfg := imgseq.DefaultStoreConfig
+		cfg.MaxAge, cfg.MaxBytes = *flagMaxAge, *flagMaxMB<<20
+		if st, err := imgseq.OpenStore(*flagStore, cfg); err != nil {
+			glog.Fatalf("unable to open store '%s': %v", *flagStore, err)
+		} else {
+			store = st
+		}
+		defer func() {
+			if err := store.Close(); err != nil {
+				glog.Errorf("error closing store '%s': %v", *flagStore, err)
+			}
+		}()
 	}
 
 	i := 1
@@ -70,8 +138,23 @@ Use -discard to not write any data to disk at all; normally used with -display.
 			break
 		}
 		i++
-		if *flagOutfile != "" {
+		if *flagStats {
+			simg = imgseq.WithStats(simg)
+			glog.Infof("%d %v", simg.GetImgInfo().SeqNum, simg.GetImgInfo().Stats)
+		}
+		if *flagStamp {
+			stamp(simg)
+		}
+		if store != nil {
+			if err := store.Write(simg); err != nil {
+				glog.Fatalf("error writing frame %d to store: %v", simg.GetImgInfo().SeqNum, err)
+			}
+		} else if *flagOutfile != "" && *flagRawOut {
 			writeImage(outfile, simg)
+		} else if filepath.Ext(*flagOutfile) == imglib.Y4MExt {
+			y4mout = writeY4MFrame(y4mout, outfile, cs, simg)
+		} else if *flagOutfile != "" {
+			framesout = writeFrame(framesout, outfile, simg)
 		} else if ! *flagDiscard {
 			writeImageToNewFile(simg)
 		}
@@ -81,17 +164,70 @@ Use -discard to not write any data to disk at all; normally used with -display.
 	}
 }
 
+// names is the naming scheme given by -names.
+var names imgseq.NameScheme
+
+// rawInfoWritten records the directories in which the RawInfoFile describing
+// the frames written by writeImageToNewFile has been written.
+var rawInfoWritten = make(map[string]bool)
+
+// stamp draws the creation time of simg onto it, in place.
+func stamp(simg imgseq.Img) {
+	ts := simg.GetImgInfo().CreationTs.Format("2006-01-02 15:04:05.000")
+	min := simg.GetImage().Bounds().Min.Add(image.Point{4, 4})
+	imgdraw.Label(simg.GetImage(), min, ts, 2, 2, color.White, color.Black)
+}
+
 func writeImageToNewFile(simg imgseq.Img) {
 	i := simg.GetImgInfo().SeqNum
 	cts := simg.GetImgInfo().CreationTs
-	fname := imgseq.TimeToFname("test", cts) + ".yuv"
+	dir := names.Dir(cts)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			glog.Fatalf("error creating directory %s: %v", dir, err)
+		}
+	}
+	if *flagSaveAs != "raw" {
+		fname := names.Path(i, cts, "." + *flagSaveAs)
+		start := time.Now()
+		err := imglib.SaveImage(fname, simg.GetImage())
+		logsince(start, "%d F wrote image %s, err=%v", i, fname, err)
+		if err == nil && *flagMeta {
+			writeMeta(fname, simg)
+		}
+		return
+	}
+	ps := simg.GetPixelSequence()
+	pf := imglib.GetPixelFormat(ps.ImageBytes)
+	if !rawInfoWritten[dir] {
+		rf := imglib.RawFormat{Format: pf, Width: ps.Dx, Height: ps.Dy}
+		if err := imglib.WriteRawInfo(filepath.Join(".", dir), rf); err != nil {
+			glog.Fatalf("error writing %s: %v", imglib.RawInfoFile, err)
+		}
+		rawInfoWritten[dir] = true
+	}
+	fname := names.Path(i, cts, pf.Ext())
 	logsince(cts, "%d D starting write of image %s", i, fname)
 	start := time.Now()
 	file, err := os.Create(fname)
 	if err == nil {
 		writeImage(file, simg)
+		file.Close()
 	}
 	logsince(start, "%d F wrote image %s, err=%v", i, fname, err)
+	if err == nil && *flagMeta {
+		writeMeta(fname, simg)
+	}
+}
+
+// writeMeta records where simg, just written to fname, came from.
+func writeMeta(fname string, simg imgseq.Img) {
+	ps := simg.GetPixelSequence()
+	md := imgseq.Metadata{Camera: names.Camera, Device: *flagInput,
+		Format: imglib.GetPixelFormat(ps.ImageBytes).String(), Width: ps.Dx, Height: ps.Dy}
+	if err := imgseq.AppendMetadata(imgseq.ImgInfo{Path: fname}, &md); err != nil {
+		glog.Errorf("error writing metadata of %s: %v", fname, err)
+	}
 }
 
 func writeImage(outfile *os.File, simg imgseq.Img) {
@@ -101,6 +237,46 @@ func writeImage(outfile *os.File, simg imgseq.Img) {
 	}
 }
 
+// writeFrame appends simg to the container being written to outfile, creating
+// fw based on the geometry of simg if it's nil.
+func writeFrame(fw *imglib.FramesWriter, outfile *os.File, simg imgseq.Img) *imglib.FramesWriter {
+	ps := simg.GetPixelSequence()
+	if fw == nil {
+		hdr := imglib.FramesHeader{Format: imglib.GetPixelFormat(ps.ImageBytes), Width: ps.Dx, Height: ps.Dy}
+		if w, err := imglib.NewFramesWriter(outfile, hdr); err != nil {
+			glog.Fatalf("error writing header to '%s': %v", *flagOutfile, err)
+		} else {
+			fw = w
+		}
+	}
+	iinfo := simg.GetImgInfo()
+	if err := fw.WriteFrame(iinfo.CreationTs, iinfo.SeqNum, ps.GetBytes()); err != nil {
+		glog.Fatalf("error writing frame %d: %v", iinfo.SeqNum, err)
+	}
+	return fw
+}
+
+// writeY4MFrame appends simg to the YUV4MPEG2 stream being written to outfile,
+// creating yw based on the geometry of simg and the device frame rate if it's nil.
+func writeY4MFrame(yw *imglib.Y4MWriter, outfile *os.File, cs *v4l.CaptureStream, simg imgseq.Img) *imglib.Y4MWriter {
+	img := simg.GetImage()
+	if yw == nil {
+		num, den := cs.GetFps()
+		sz := img.Bounds().Size()
+		hdr := imglib.Y4MHeader{Width: sz.X, Height: sz.Y, FrameRateNum: num, FrameRateDen: den,
+			Interlace: "p", AspectNum: 1, AspectDen: 1, Chroma: "422"}
+		if w, err := imglib.NewY4MWriter(outfile, hdr); err != nil {
+			glog.Fatalf("error writing header to '%s': %v", *flagOutfile, err)
+		} else {
+			yw = w
+		}
+	}
+	if err := yw.WriteImage(img); err != nil {
+		glog.Fatalf("error writing frame %d: %v", simg.GetImgInfo().SeqNum, err)
+	}
+	return yw
+}
+
 func display(imgdisp chan []imgseq.Img, img imgseq.Img) {
 	sendstart := time.Now()
 	select {
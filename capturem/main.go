@@ -3,6 +3,7 @@ package main
 
 import (
 	"code.google.com/p/ncabatoff/imglib"
+	"code.google.com/p/ncabatoff/imglib/imgdraw"
 	"code.google.com/p/ncabatoff/imgseq"
 	"code.google.com/p/ncabatoff/motion"
 	"code.google.com/p/ncabatoff/v4l"
@@ -10,9 +11,6 @@ import (
 	"flag"
 	"fmt"
 	"github.com/golang/glog"
-	"image"
-	"image/color"
-	"image/draw"
 	"runtime"
 	"sort"
 	"time"
@@ -27,6 +25,9 @@ var flagFormat = flag.String("format", "yuv", "format yuv or rgb or jpg")
 var flagFrames = flag.Int("frames", 0, "frames to capture")
 var flagFps = flag.Int("fps", 0, "frames per second")
 var flagDeltaThresh = flag.Int("deltaThresh", 32*69, "delta filter threshold")
+var flagBgModel = flag.String("bgmodel", motion.DefaultTrackerConfig.Model.String(), "background model: mean of the last -window frames; ema, an exponential moving average needing no stored frames; or variance, which also learns how much each pixel varies, taking -deltaThresh in standard deviations (see motion.VarianceUnit)")
+var flagWindow = flag.Int("window", motion.DefaultTrackerConfig.Window, "number of frames the background is averaged over")
+var flagOrient = flag.String("orient", "none", "transform applied to frames: none, rot90, rot180, rot270, fliph, flipv, transpose or transverse")
 
 func main() {
 	// defer profile.Start(profile.MemProfile).Stop()
@@ -38,7 +39,15 @@ func main() {
 		glog.Flush()
 	}()
 
-	cs := v4l.NewStream(*flagInput, *flagFps, *flagFormat, *flagWidth, *flagHeight, nil)
+	orient, err := imglib.ParseOrientation(*flagOrient)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	model, err := motion.ParseBackgroundModel(*flagBgModel)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	cs := v4l.NewOrientedStream(*flagInput, *flagFps, *flagFormat, *flagWidth, *flagHeight, orient, nil)
 	defer func() {
 		cs.Shutdown()
 	}()
@@ -46,7 +55,7 @@ func main() {
 	imgdisp := make(chan []imgseq.Img, 1)
 	go vlib.StreamImages(imgdisp)
 	i := 1
-	trk := motion.NewTracker()
+	trk := motion.NewTrackerWithConfig(motion.TrackerConfig{Model: model, Window: *flagWindow})
 	for simg := range cs.GetOutput() {
 		if i == *flagFrames {
 			break
@@ -64,7 +73,7 @@ func main() {
 func trackRects(deltaThresh int, trk *motion.Tracker, img imgseq.Img) imgseq.Img {
 	if rs := trk.GetRects(img, deltaThresh); len(rs) > 0 {
 		sort.Sort(motion.RectAreaSlice(rs))
-		rimg := getRectImage(img.GetImage(), rs)
+		rimg := imgdraw.IsolateRects(img.GetImage(), rs)
 		return &imgseq.RawImg{img.GetImgInfo(), imglib.GetPixelSequence(rimg)}
 	} else {
 		return nil
@@ -72,16 +81,6 @@ func trackRects(deltaThresh int, trk *motion.Tracker, img imgseq.Img) imgseq.Img
 
 }
 
-func getRectImage(img image.Image, rects []image.Rectangle) image.Image {
-    irect := img.Bounds()
-    out := image.NewRGBA(irect)
-    for _, r := range rects {
-            draw.Draw(out, r.Inset(-1), &image.Uniform{color.White}, image.ZP, draw.Src)
-            draw.Draw(out, r, img, r.Min, draw.Src)
-    }
-    return out
-}
-
 func logtime(f func(), fs string, opt ...interface{}) {
 	start := time.Now()
 	f()
@@ -0,0 +1,131 @@
+// export writes a sequence of images to a video file that ordinary players
+// can show, optionally outlining the motion found by bvm.
+package main
+
+import (
+	"code.google.com/p/ncabatoff/imglib"
+	"code.google.com/p/ncabatoff/imgseq"
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var flagFrom = flag.String("from", "", "export images created at or after this local time, e.g. '2014-05-13 16:30:00'")
+var flagTo = flag.String("to", "", "export images created before this local time")
+var flagOverlay = flag.Bool("overlay", false, "outline the motion rects recorded in the metadata of images, e.g. by bvm -savemeta")
+var flagFps = flag.Float64("fps", 10, "frame rate if the images have no creation times to go by")
+var flagMaxGap = flag.Duration("maxgap", time.Second, "longest time an image is shown for, shortening quiet periods")
+var flagQuality = flag.Int("quality", 0, "JPEG quality of .avi frames, 1-100; 0 means the default")
+var flagEncoder = flag.String("encoder", "", "instead of writing .avi or .gif natively, pipe raw frames to this ffmpeg-compatible command and arguments, e.g. 'ffmpeg -y -c:v libx264'; the output file is appended")
+
+// timeLayout is the form of the -from and -to flags.
+const timeLayout = "2006-01-02 15:04:05"
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] input output\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, `
+export reads a directory of images, a frame store as written by capture -store,
+a .frames container or a YUV4MPEG2 file, and writes the images to output, a
+Motion JPEG .avi or an animated .gif.  Images are shown at the times their
+creation times say, so a capture of motion plays back at its real speed.
+`)
+	}
+	flag.Parse()
+	defer glog.Flush()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	in, out := flag.Arg(0), flag.Arg(1)
+
+	seq := openSequence(in)
+	defer seq.Close()
+	lo, hi := 0, seq.Len()
+	if *flagFrom != "" {
+		lo = seq.IndexAt(parseTime("from", *flagFrom))
+	}
+	if *flagTo != "" {
+		if hi = seq.IndexAt(parseTime("to", *flagTo)); hi < lo {
+			hi = lo
+		}
+	}
+	if lo == hi {
+		glog.Fatalf("no images to export from %s", in)
+	}
+	seq = imgseq.Slice(seq, lo, hi)
+
+	opts := imgseq.ExportOptions{Overlay: *flagOverlay, FPS: *flagFps, MaxGap: *flagMaxGap, Quality: *flagQuality}
+	if *flagEncoder != "" {
+		args := strings.Fields(*flagEncoder)
+		if err := imgseq.ExportPipe(seq, opts, args[0], append(args[1:], out)...); err != nil {
+			glog.Fatalf("error exporting to %s: %v", out, err)
+		}
+	} else if err := exportFile(seq, opts, out); err != nil {
+		os.Remove(out)
+		glog.Fatalf("error exporting to %s: %v", out, err)
+	}
+	glog.Infof("exported %d images to %s", seq.Len(), out)
+}
+
+// parseTime returns the local time given by the value of the named flag.
+func parseTime(name, value string) time.Time {
+	t, err := time.ParseInLocation(timeLayout, value, time.Local)
+	if err != nil {
+		glog.Fatalf("bad -%s time '%s', expected the form '%s'", name, value, timeLayout)
+	}
+	return t
+}
+
+// openSequence returns the images at path in creation time order.
+func openSequence(path string) imgseq.Sequence {
+	fi, err := os.Stat(path)
+	if err != nil {
+		glog.Fatalf("unable to stat input '%s': %v", path, err)
+	}
+	var seq imgseq.Sequence
+	if fi.IsDir() && imgseq.IsStore(path) {
+		var st *imgseq.Store
+		if st, err = imgseq.OpenStore(path, imgseq.DefaultStoreConfig); err == nil {
+			seq, err = st.Range(time.Time{}, time.Time{})
+		}
+	} else if fi.IsDir() {
+		var dl imgseq.DirList
+		if dl, err = imgseq.ScanDir(path, imgseq.ScanOptions{ByTime: true}); err == nil {
+			seq = imgseq.NewDirSequence(dl)
+		}
+	} else {
+		seq, err = imgseq.OpenSequence(path)
+	}
+	if err != nil {
+		glog.Fatalf("unable to open %s: %v", path, err)
+	}
+	return seq
+}
+
+// exportFile writes seq to the file at path in the format given by its
+// extension.
+func exportFile(seq imgseq.Sequence, opts imgseq.ExportOptions, path string) error {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != imglib.AVIExt && ext != ".gif" {
+		return fmt.Errorf("unknown output format '%s', expected %s or .gif, or use -encoder", ext, imglib.AVIExt)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if ext == imglib.AVIExt {
+		err = imgseq.ExportAVI(file, seq, opts)
+	} else {
+		err = imgseq.ExportGIF(file, seq, opts)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+package imglib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"time"
+)
+
+// AVIExt is the extension of MJPEG AVI files written by AVIWriter.
+const AVIExt = ".avi"
+
+const (
+	aviHeaderSize   = 224 // RIFF, hdrl list and movi list headers
+	aviHasIndex     = 0x10
+	aviKeyFrame     = 0x10
+	aviChunkID      = "00dc"
+	aviIndexRecSize = 16
+)
+
+// AVIWriter writes a Motion JPEG AVI file: a single video stream of JPEG
+// images at a constant frame rate, which almost any player understands.  The
+// sizes in the headers are only known at the end, so the output must be
+// seekable, and Close must be called to finish it.
+type AVIWriter struct {
+	w             io.WriteSeeker
+	start         int64
+	width, height int
+	period        time.Duration
+	quality       int
+	index         []byte
+	moviSize      int64
+	frames        int
+	maxFrame      int
+	buf           bytes.Buffer
+	last          []byte
+}
+
+// NewAVIWriter writes the headers of an AVI file holding width x height
+// frames shown for period each, and returns an AVIWriter ready to accept
+// them.  quality is the JPEG quality of frames written with WriteImage; zero
+// means jpeg.DefaultQuality.
+func NewAVIWriter(w io.WriteSeeker, width, height int, period time.Duration, quality int) (*AVIWriter, error) {
+	if width <= 0 || height <= 0 || period < time.Microsecond {
+		return nil, fmt.Errorf("invalid AVI geometry %dx%d period %v", width, height, period)
+	}
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	}
+	aw := &AVIWriter{w: w, start: start, width: width, height: height, period: period, quality: quality}
+	if _, err := w.Write(aw.header()); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+// header returns the headers describing the frames written so far.
+func (aw *AVIWriter) header() []byte {
+	var b bytes.Buffer
+	le := func(vs ...interface{}) {
+		for _, v := range vs {
+			binary.Write(&b, binary.LittleEndian, v)
+		}
+	}
+	usecs := uint32(aw.period / time.Microsecond)
+	w, h := uint32(aw.width), uint32(aw.height)
+	riffSize := uint32(aviHeaderSize - 8 + aw.moviSize + 8 + int64(len(aw.index)))
+
+	b.WriteString("RIFF")
+	le(riffSize)
+	b.WriteString("AVI LIST")
+	le(uint32(192))
+	b.WriteString("hdrlavih")
+	le(uint32(56), usecs, uint32(0), uint32(0), uint32(aviHasIndex), uint32(aw.frames), uint32(0),
+		uint32(1), uint32(aw.maxFrame), w, h, [4]uint32{})
+	b.WriteString("LIST")
+	le(uint32(116))
+	b.WriteString("strlstrh")
+	le(uint32(56))
+	b.WriteString("vidsMJPG")
+	le(uint32(0), uint16(0), uint16(0), uint32(0), usecs, uint32(1000000), uint32(0), uint32(aw.frames),
+		uint32(aw.maxFrame), int32(-1), uint32(0), [4]int16{0, 0, int16(w), int16(h)})
+	b.WriteString("strf")
+	le(uint32(40), uint32(40), w, h, uint16(1), uint16(24))
+	b.WriteString("MJPG")
+	le(w*h*3, uint32(0), uint32(0), uint32(0), uint32(0))
+	b.WriteString("LIST")
+	le(uint32(4 + aw.moviSize))
+	b.WriteString("movi")
+	return b.Bytes()
+}
+
+// Header returns the dimensions and frame period of the stream.
+func (aw *AVIWriter) Header() (width, height int, period time.Duration) {
+	return aw.width, aw.height, aw.period
+}
+
+// WriteJPEG appends a frame which is already JPEG encoded.
+func (aw *AVIWriter) WriteJPEG(data []byte) error {
+	chunk := make([]byte, 8, 8+len(data)+1)
+	copy(chunk, aviChunkID)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 != 0 {
+		chunk = append(chunk, 0)
+	}
+	if _, err := aw.w.Write(chunk); err != nil {
+		return err
+	}
+	var rec [aviIndexRecSize]byte
+	copy(rec[:], aviChunkID)
+	binary.LittleEndian.PutUint32(rec[4:], aviKeyFrame)
+	// Offsets are relative to the "movi" fourcc.
+	binary.LittleEndian.PutUint32(rec[8:], uint32(4+aw.moviSize))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(data)))
+	aw.index = append(aw.index, rec[:]...)
+	aw.last = append(aw.last[:0], data...)
+	aw.moviSize += int64(len(chunk))
+	aw.frames++
+	if len(data) > aw.maxFrame {
+		aw.maxFrame = len(data)
+	}
+	return nil
+}
+
+// WriteImage encodes img as JPEG and appends it.  img must have the
+// dimensions given to NewAVIWriter.
+func (aw *AVIWriter) WriteImage(img image.Image) error {
+	if sz := img.Bounds().Size(); sz.X != aw.width || sz.Y != aw.height {
+		return fmt.Errorf("image is %dx%d, AVI stream is %dx%d", sz.X, sz.Y, aw.width, aw.height)
+	}
+	if yuyv, ok := img.(*YUYV); ok {
+		// The encoder has a fast path for YCbCr, and 4:2:2 is lossless.
+		img = NewYCbCr(yuyv, image.YCbCrSubsampleRatio422)
+	}
+	aw.buf.Reset()
+	if err := jpeg.Encode(&aw.buf, img, &jpeg.Options{Quality: aw.quality}); err != nil {
+		return err
+	}
+	return aw.WriteJPEG(aw.buf.Bytes())
+}
+
+// Repeat appends the previous frame again, which is how a frame is shown for
+// longer than the period.
+func (aw *AVIWriter) Repeat() error {
+	if aw.frames == 0 {
+		return fmt.Errorf("no frame to repeat")
+	}
+	return aw.WriteJPEG(aw.last)
+}
+
+// Close writes the index and updates the headers.  It doesn't close the
+// underlying stream.
+func (aw *AVIWriter) Close() error {
+	idx := make([]byte, 8, 8+len(aw.index))
+	copy(idx, "idx1")
+	binary.LittleEndian.PutUint32(idx[4:], uint32(len(aw.index)))
+	if _, err := aw.w.Write(append(idx, aw.index...)); err != nil {
+		return err
+	}
+	if _, err := aw.w.Seek(aw.start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(aw.header()); err != nil {
+		return err
+	}
+	_, err := aw.w.Seek(0, io.SeekEnd)
+	return err
+}
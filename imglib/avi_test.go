@@ -0,0 +1,59 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "bytes"
+import "encoding/binary"
+import "image"
+import "image/jpeg"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "time"
+
+func (s *MySuite) TestAVIWriter(c *C) {
+	path := filepath.Join(c.MkDir(), "test"+AVIExt)
+	file, err := os.Create(path)
+	c.Assert(err, IsNil)
+	_, err = NewAVIWriter(file, 0, 4, time.Second, 0)
+	c.Check(err, NotNil)
+	aw, err := NewAVIWriter(file, 8, 4, 40*time.Millisecond, 0)
+	c.Assert(err, IsNil)
+	imgs := []image.Image{getTestYuyvImage(image.Point{8, 4}), image.NewGray(image.Rect(0, 0, 8, 4))}
+	c.Check(aw.Repeat(), NotNil)
+	for _, img := range imgs {
+		c.Assert(aw.WriteImage(img), IsNil)
+	}
+	c.Assert(aw.Repeat(), IsNil)
+	imgs = append(imgs, imgs[1])
+	c.Check(aw.WriteImage(image.NewGray(image.Rect(0, 0, 4, 4))), NotNil)
+	c.Assert(aw.Close(), IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	u32 := func(off int) int { return int(binary.LittleEndian.Uint32(data[off:])) }
+	c.Assert(string(data[0:4]), Equals, "RIFF")
+	c.Check(u32(4), Equals, len(data)-8)
+	c.Check(string(data[8:12]), Equals, "AVI ")
+	c.Check(string(data[24:28]), Equals, "avih")
+	c.Check(u32(32), Equals, 40000)
+	c.Check(u32(48), Equals, 3)
+	c.Check(u32(64), Equals, 8)
+	c.Check(u32(68), Equals, 4)
+
+	movi := bytes.Index(data, []byte("movi"))
+	c.Assert(movi, Equals, aviHeaderSize-4)
+	c.Check(u32(movi-4), Equals, 4+bytes.Index(data, []byte("idx1"))-aviHeaderSize)
+	idx1 := bytes.Index(data, []byte("idx1"))
+	c.Assert(u32(idx1+4), Equals, 3*aviIndexRecSize)
+	for i := range imgs {
+		rec := idx1 + 8 + i*aviIndexRecSize
+		c.Check(string(data[rec:rec+4]), Equals, aviChunkID)
+		off, size := movi+u32(rec+8), u32(rec+12)
+		c.Check(string(data[off:off+4]), Equals, aviChunkID)
+		c.Check(u32(off+4), Equals, size)
+		img, err := jpeg.Decode(bytes.NewReader(data[off+8 : off+8+size]))
+		c.Assert(err, IsNil)
+		c.Check(img.Bounds(), Equals, image.Rect(0, 0, 8, 4))
+	}
+}
@@ -1,34 +1,31 @@
 package imglib
 
 import (
-	"image"
 	"fmt"
+	"image"
+	"reflect"
+	"sync"
 )
 
 // ImageBytes is a wrapper interface for []byte that tells us something
-// about how to interpret them as pixels.
+// about how to interpret them as pixels.  AsImage builds an image with bounds
+// r whose rows are stride bytes apart, the first byte being pixel r.Min.
 type ImageBytes interface {
 	GetBytes() []byte
 	GetBytesPerPixel() int
-	AsImage(width int) image.Image
+	AsImage(stride int, r image.Rectangle) image.Image
 }
 
 type RgbBytes []byte
 
-func getRect(ib ImageBytes, width int) image.Rectangle {
-	pixcount := len(ib.GetBytes()) / ib.GetBytesPerPixel()
-	height := pixcount/width
-	return image.Rect(0, 0, width, height)
-}
-
 func (rgb RgbBytes) GetBytes() []byte {
 	return []byte(rgb)
 }
 func (rgb RgbBytes) GetBytesPerPixel() int {
 	return 3
 }
-func (rgb RgbBytes) AsImage(width int) image.Image {
-	return &RGB{rgb.GetBytes(), width * rgb.GetBytesPerPixel(), getRect(rgb, width)}
+func (rgb RgbBytes) AsImage(stride int, r image.Rectangle) image.Image {
+	return &RGB{rgb.GetBytes(), stride, r}
 }
 func (rgb RgbBytes) String() string {
 	return fmt.Sprintf("RgbBytes[%d]", len(rgb))
@@ -42,8 +39,8 @@ func (rgba RgbaBytes) GetBytes() []byte {
 func (rgba RgbaBytes) GetBytesPerPixel() int {
 	return 4
 }
-func (rgba RgbaBytes) AsImage(width int) image.Image {
-	return &image.RGBA{rgba.GetBytes(), width * rgba.GetBytesPerPixel(), getRect(rgba, width)}
+func (rgba RgbaBytes) AsImage(stride int, r image.Rectangle) image.Image {
+	return &image.RGBA{Pix: rgba.GetBytes(), Stride: stride, Rect: r}
 }
 func (rgba RgbaBytes) String() string {
 	return fmt.Sprintf("RgbaBytes[%d]", len(rgba))
@@ -57,55 +54,218 @@ func (yuyv YuyvBytes) GetBytes() []byte {
 func (yuyv YuyvBytes) GetBytesPerPixel() int {
 	return 2
 }
-func (yuyv YuyvBytes) AsImage(width int) image.Image {
-	return &YUYV{yuyv.GetBytes(), width * yuyv.GetBytesPerPixel(), getRect(yuyv, width)}
+func (yuyv YuyvBytes) AsImage(stride int, r image.Rectangle) image.Image {
+	return &YUYV{yuyv.GetBytes(), stride, r}
 }
 func (yuyv YuyvBytes) String() string {
 	return fmt.Sprintf("YuyvBytes[%d]", len(yuyv))
 }
 
+// ImageBytesFunc returns the pixels of img, which must be of the type it was
+// registered for, along with the number of bytes between the start of
+// consecutive rows.  The first byte returned must be the pixel at
+// img.Bounds().Min.
+type ImageBytesFunc func(img image.Image) (ib ImageBytes, stride int)
+
+var (
+	imageBytesMu    sync.RWMutex
+	imageBytesFuncs = make(map[reflect.Type]ImageBytesFunc)
+)
+
+// RegisterImageBytes makes images with the same concrete type as sample
+// usable as PixelSequences without conversion, using f to get at their pixels.
+// It's typically called from an init function.  Registering a type twice
+// replaces the earlier func.
+func RegisterImageBytes(sample image.Image, f ImageBytesFunc) {
+	imageBytesMu.Lock()
+	defer imageBytesMu.Unlock()
+	imageBytesFuncs[reflect.TypeOf(sample)] = f
+}
+
+// unregisterImageBytes undoes RegisterImageBytes for sample's type, so that
+// tests can leave the registry as they found it.
+func unregisterImageBytes(sample image.Image) {
+	imageBytesMu.Lock()
+	defer imageBytesMu.Unlock()
+	delete(imageBytesFuncs, reflect.TypeOf(sample))
+}
+
+func init() {
+	RegisterImageBytes(&YUYV{}, func(img image.Image) (ImageBytes, int) {
+		raw := img.(*YUYV)
+		return YuyvBytes(raw.Pix), raw.Stride
+	})
+	RegisterImageBytes(&RGB{}, func(img image.Image) (ImageBytes, int) {
+		raw := img.(*RGB)
+		return RgbBytes(raw.Pix), raw.Stride
+	})
+	RegisterImageBytes(&image.RGBA{}, func(img image.Image) (ImageBytes, int) {
+		raw := img.(*image.RGBA)
+		return RgbaBytes(raw.Pix), raw.Stride
+	})
+}
+
+func lookupImageBytesFunc(img image.Image) ImageBytesFunc {
+	imageBytesMu.RLock()
+	defer imageBytesMu.RUnlock()
+	return imageBytesFuncs[reflect.TypeOf(img)]
+}
+
+// IsSupportedImage returns true if img's type has been registered with
+// RegisterImageBytes, i.e. if it can be used as a PixelSequence without
+// conversion.
+func IsSupportedImage(img image.Image) bool {
+	return lookupImageBytesFunc(img) != nil
+}
+
+// LookupImageBytes returns the pixels of img and its stride without copying,
+// or an error if img's type hasn't been registered.
+func LookupImageBytes(img image.Image) (ImageBytes, int, error) {
+	if img == nil {
+		return nil, 0, fmt.Errorf("nil image")
+	}
+	if f := lookupImageBytesFunc(img); f != nil {
+		ib, stride := f(img)
+		return ib, stride, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported image type %T", img)
+}
+
+// GetImageBytes returns the pixels of img without copying.  It panics if img's
+// type hasn't been registered; use NewPixelSequence for arbitrary images.
 func GetImageBytes(img image.Image) ImageBytes {
-	switch raw := img.(type) {
-	case *YUYV:
-		return YuyvBytes(raw.Pix)
-	case *RGB:
-		return RgbBytes(raw.Pix)
-	case *image.RGBA:
-		return RgbaBytes(raw.Pix)
-		// should we use a narrower argument type than image.Image?
-	default: panic("Unknown image type")
+	ib, _, err := LookupImageBytes(img)
+	if err != nil {
+		panic(err.Error())
+	}
+	return ib
+}
+
+// ToSupportedImage returns img itself if its type is registered, and
+// otherwise a converted copy which is.  A YCbCr (e.g. a decoded JPEG) of even
+// width becomes a YUYV since that loses little, anything else an image.RGBA.
+// The bounds of a converted copy start at (0,0).
+func ToSupportedImage(img image.Image) image.Image {
+	if IsSupportedImage(img) {
+		return img
+	}
+	if ycbcr, ok := img.(*image.YCbCr); ok && ycbcr.Rect.Dx()%2 == 0 {
+		return NewYUYVFromYCbCr(ycbcr)
 	}
+	return StdImage{img}.GetRGBA()
 }
 
 // PixelSequence is like image.Image, only non-SubImage-able in the interest of speed.
+// Rows are Stride bytes apart and the first starts at the first byte of
+// ImageBytes, so a PixelSequence built from a SubImage still describes only
+// the pixels within it.  A zero Stride means the rows are packed.  Each row
+// holds the pixels from Origin.X to Origin.X+Dx, except that YUYV rows are
+// widened to whole pixel pairs, which start on even columns; see RowBounds.
 type PixelSequence struct {
 	ImageBytes
 	Dx, Dy int
+	Stride int
+	Origin image.Point
 }
 
+// GetStride returns the number of bytes between the start of consecutive rows.
 func (ps PixelSequence) GetStride() int {
-	return ps.Dx * ps.ImageBytes.GetBytesPerPixel()
+	if ps.Stride != 0 {
+		return ps.Stride
+	}
+	return ps.RowBytes()
+}
+
+// RowBytes returns the number of bytes of pixel data in each row, which is
+// less than GetStride() if the rows aren't packed.
+func (ps PixelSequence) RowBytes() int {
+	return ps.RowBounds().Dx() * ps.ImageBytes.GetBytesPerPixel()
+}
+
+// RowBounds returns the bounds of the pixels held by each Row.  They're the
+// same as Bounds except for YUYV sequences starting or ending halfway through
+// a pixel pair, whose rows include the whole pair.
+func (ps PixelSequence) RowBounds() image.Rectangle {
+	r := ps.Bounds()
+	if _, ok := ps.ImageBytes.(YuyvBytes); ok {
+		r.Min.X, r.Max.X = pairFloor(r.Min.X), pairCeil(r.Max.X)
+	}
+	return r
+}
+
+// IsPacked returns true if there are no gaps between rows.
+func (ps PixelSequence) IsPacked() bool {
+	return ps.GetStride() == ps.RowBytes()
+}
+
+// RowOffset returns the index of the first byte of row y, where row 0 is the
+// one containing Origin.
+func (ps PixelSequence) RowOffset(y int) int {
+	return y * ps.GetStride()
 }
 
+// PixOffset returns the index of the first byte of the pixel at (x,y), where
+// (0,0) is the pixel at Origin.
 func (ps PixelSequence) PixOffset(x, y int) int {
-	return y*ps.GetStride() + x*ps.ImageBytes.GetBytesPerPixel()
+	return ps.RowOffset(y) + (x+ps.Origin.X-ps.RowBounds().Min.X)*ps.ImageBytes.GetBytesPerPixel()
 }
 
+// Row returns the bytes of row y, where row 0 is the one containing Origin.
+func (ps PixelSequence) Row(y int) []byte {
+	start := ps.RowOffset(y)
+	return ps.ImageBytes.GetBytes()[start : start+ps.RowBytes()]
+}
+
+// Bounds returns the bounds of the image the sequence was built from.
+func (ps PixelSequence) Bounds() image.Rectangle {
+	return image.Rect(ps.Origin.X, ps.Origin.Y, ps.Origin.X+ps.Dx, ps.Origin.Y+ps.Dy)
+}
+
+// GetPixelSequence returns a PixelSequence sharing pixels with img and
+// preserving its geometry.  Like GetImageBytes it panics if img's type hasn't
+// been registered.
 func GetPixelSequence(img image.Image) PixelSequence {
-	return PixelSequence{Dx: img.Bounds().Dx(), Dy: img.Bounds().Dy(), ImageBytes: GetImageBytes(img)}
+	ps, err := pixelSequenceOf(img)
+	if err != nil {
+		panic(err.Error())
+	}
+	return ps
+}
+
+// NewPixelSequence is like GetPixelSequence but works on any image: those of
+// unregistered types are first converted by ToSupportedImage, in which case
+// the result doesn't share pixels with img and its Origin is (0,0).  An error
+// is returned only for a nil image.
+func NewPixelSequence(img image.Image) (PixelSequence, error) {
+	if img == nil {
+		return PixelSequence{}, fmt.Errorf("nil image")
+	}
+	return pixelSequenceOf(ToSupportedImage(img))
+}
+
+func pixelSequenceOf(img image.Image) (PixelSequence, error) {
+	ib, stride, err := LookupImageBytes(img)
+	if err != nil {
+		return PixelSequence{}, err
+	}
+	r := img.Bounds()
+	return PixelSequence{Dx: r.Dx(), Dy: r.Dy(), Origin: r.Min, Stride: stride, ImageBytes: ib}, nil
 }
 
-// PixelRow represents a single row from a PixelSequence.
+// PixelRow represents a single row from a PixelSequence, starting at Offset
+// as given by RowOffset.
 type PixelRow struct {
 	PixelSequence
 	Offset int
 }
 
+// GetBytes returns the pixel data in the row, excluding any padding.
 func (ps PixelRow) GetBytes() []byte {
-	start, end := ps.Offset, ps.Offset+ps.GetStride()
+	start, end := ps.Offset, ps.Offset+ps.RowBytes()
 	return ps.PixelSequence.ImageBytes.GetBytes()[start:end]
 }
 
+// GetImage returns an image sharing pixels with ps.
 func (ps PixelSequence) GetImage() image.Image {
-	return ps.AsImage(ps.Dx)
+	return ps.AsImage(ps.GetStride(), ps.Bounds())
 }
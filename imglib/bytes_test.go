@@ -0,0 +1,110 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "image"
+
+func (s *MySuite) TestPixelSequenceSubImage(c *C) {
+	rgb := getTestRgbImage(image.Point{6, 5})
+	r := image.Rect(1, 2, 4, 5)
+	sub := rgb.SubImage(r).(*RGB)
+	ps := GetPixelSequence(sub)
+	c.Check(ps.Bounds(), DeepEquals, r)
+	c.Check(ps.GetStride(), Equals, rgb.Stride)
+	c.Check(ps.RowBytes(), Equals, 9)
+	c.Check(ps.IsPacked(), Equals, false)
+	c.Check(ps.Row(1), DeepEquals, rgb.Pix[rgb.PixOffset(1, 3):rgb.PixOffset(4, 3)])
+	c.Check(ps.GetImage(), DeepEquals, sub)
+	c.Check(PixelRow{ps, ps.PixOffset(0, 2)}.GetBytes(), DeepEquals, ps.Row(2))
+
+	yuyv := getTestYuyvImage(image.Point{8, 4})
+	ysub := yuyv.SubImage(image.Rect(2, 1, 6, 3)).(*YUYV)
+	c.Check(GetPixelSequence(ysub).GetImage(), DeepEquals, ysub)
+
+	// A YUYV sub-image starting or ending halfway through a pair keeps its
+	// bounds; only its rows are widened to whole pairs.
+	for _, r := range []image.Rectangle{image.Rect(3, 0, 7, 2), image.Rect(1, 1, 4, 4), image.Rect(2, 0, 5, 1)} {
+		ysub = yuyv.SubImage(r).(*YUYV)
+		yps := GetPixelSequence(ysub)
+		c.Check(yps.Bounds(), Equals, r)
+		c.Check(yps.GetImage().Bounds(), Equals, r)
+		c.Check(yps.GetImage(), DeepEquals, ysub)
+		rb := image.Rect(r.Min.X&^1, r.Min.Y, (r.Max.X+1)&^1, r.Max.Y)
+		c.Check(yps.RowBounds(), Equals, rb)
+		c.Check(yps.Row(1), DeepEquals, yuyv.Pix[yuyv.PixOffset(rb.Min.X, r.Min.Y+1):yuyv.PixOffset(rb.Max.X, r.Min.Y+1)])
+		c.Check(yps.PixOffset(0, 1), Equals, yps.Stride+(r.Min.X-rb.Min.X)*2)
+
+		d, err := Diff(ysub, ysub, 1)
+		c.Assert(err, IsNil)
+		c.Check(d.Rect, Equals, r)
+		c.Check(d.Pix, DeepEquals, make([]byte, r.Dx()*r.Dy()))
+		other := getTestYuyvImage(image.Point{8, 4}).SubImage(r.Add(image.Pt(1, 0))).(*YUYV)
+		d, err = Diff(ysub, other, 1)
+		c.Assert(err, IsNil)
+		c.Check(d.Rect, Equals, r)
+	}
+
+	packed := GetPixelSequence(rgb)
+	c.Check(packed.IsPacked(), Equals, true)
+	// A zero Stride is taken to mean packed rows.
+	c.Check(PixelSequence{ImageBytes: RgbBytes(rgb.Pix), Dx: 6, Dy: 5}.GetImage(), DeepEquals, rgb)
+}
+
+// grayImage is a minimal image type used to exercise RegisterImageBytes.
+type grayImage struct {
+	*image.Gray
+}
+
+func (s *MySuite) TestImageBytesRegistry(c *C) {
+	gray := image.NewGray(image.Rect(0, 0, 4, 2))
+	_, _, err := LookupImageBytes(grayImage{gray})
+	c.Check(err, ErrorMatches, "unsupported image type .*grayImage")
+	c.Check(func() { GetImageBytes(grayImage{gray}) }, PanicMatches, "unsupported image type .*")
+
+	RegisterImageBytes(grayImage{}, func(img image.Image) (ImageBytes, int) {
+		g := img.(grayImage)
+		// Not really RGB, but it'll do to check the hook is used.
+		return RgbBytes(g.Pix), g.Stride
+	})
+	defer unregisterImageBytes(grayImage{})
+	ib, stride, err := LookupImageBytes(grayImage{gray})
+	c.Assert(err, IsNil)
+	c.Check(stride, Equals, 4)
+	c.Check(ib.GetBytes(), DeepEquals, gray.Pix)
+	c.Check(IsSupportedImage(grayImage{gray}), Equals, true)
+	c.Check(ToSupportedImage(grayImage{gray}), DeepEquals, grayImage{gray})
+}
+
+func (s *MySuite) TestNewPixelSequence(c *C) {
+	rgb := getTestRgbImage(image.Point{4, 2})
+	ps, err := NewPixelSequence(rgb)
+	c.Assert(err, IsNil)
+	c.Check(ps, DeepEquals, GetPixelSequence(rgb))
+
+	// Decoded JPEGs are YCbCr, which become YUYV.
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420)
+	ps, err = NewPixelSequence(ycbcr)
+	c.Assert(err, IsNil)
+	c.Check(ps.ImageBytes, FitsTypeOf, YuyvBytes{})
+	c.Check(ps.GetImage(), DeepEquals, NewYUYVFromYCbCr(ycbcr))
+
+	// Odd widths can't be YUYV.
+	ycbcr = image.NewYCbCr(image.Rect(0, 0, 5, 3), image.YCbCrSubsampleRatio420)
+	for i := range ycbcr.Cb {
+		ycbcr.Y[i], ycbcr.Cb[i], ycbcr.Cr[i] = uint8(40*i), uint8(90+20*i), uint8(200-30*i)
+	}
+	ps, err = NewPixelSequence(ycbcr)
+	c.Assert(err, IsNil)
+	c.Check(ps.ImageBytes, FitsTypeOf, RgbaBytes{})
+	want := image.NewRGBA(ycbcr.Rect)
+	convertImageWithAt(want, ycbcr)
+	c.Check(ps.GetImage(), DeepEquals, want)
+
+	nrgba := image.NewNRGBA(image.Rect(1, 1, 3, 3))
+	ps, err = NewPixelSequence(nrgba)
+	c.Assert(err, IsNil)
+	c.Check(ps.Bounds(), DeepEquals, image.Rect(0, 0, 2, 2))
+	c.Check(ps.GetImage(), DeepEquals, StdImage{nrgba}.GetRGBA())
+
+	_, err = NewPixelSequence(nil)
+	c.Check(err, NotNil)
+}
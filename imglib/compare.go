@@ -0,0 +1,168 @@
+package imglib
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"reflect"
+)
+
+// comparableSeqs returns pixel sequences for a and b whose rows can be compared
+// byte for byte.  If a and b are of the same registered type their stored
+// samples are used as is, so two YUYV images are compared on Y, Cb and Cr;
+// otherwise both are converted to RGBA.  alpha is true when every fourth byte
+// is an alpha sample, which is ignored.
+func comparableSeqs(a, b image.Image) (pa, pb PixelSequence, alpha bool, err error) {
+	if a == nil || b == nil {
+		return pa, pb, false, fmt.Errorf("nil image")
+	}
+	if sa, sb := a.Bounds().Size(), b.Bounds().Size(); sa != sb {
+		return pa, pb, false, fmt.Errorf("image sizes differ: %v vs %v", sa, sb)
+	}
+	// YUYV images are paired from even columns, so two starting on columns of
+	// different parity don't share chroma in the same places.
+	oddA, oddB := a.Bounds().Min.X%2 != 0, b.Bounds().Min.X%2 != 0
+	if _, ok := a.(*YUYV); ok && oddA != oddB || reflect.TypeOf(a) != reflect.TypeOf(b) || !IsSupportedImage(a) {
+		a, b = StdImage{a}.GetRGBA(), StdImage{b}.GetRGBA()
+	}
+	pa, pb = GetPixelSequence(a), GetPixelSequence(b)
+	_, alpha = pa.ImageBytes.(RgbaBytes)
+	return pa, pb, alpha, nil
+}
+
+// boundsRow returns the bytes of row y of ps holding the pixels within its
+// bounds, leaving out the other half of any YUYV pairs split by them.
+func boundsRow(ps PixelSequence, y int) []byte {
+	start := ps.PixOffset(0, y)
+	return ps.ImageBytes.GetBytes()[start : start+ps.Dx*ps.ImageBytes.GetBytesPerPixel()]
+}
+
+// MSE returns the mean squared difference between the samples of a and b,
+// which must have the same size.  See comparableSeqs for which samples are used.
+func MSE(a, b image.Image) (float64, error) {
+	pa, pb, alpha, err := comparableSeqs(a, b)
+	if err != nil {
+		return 0, err
+	}
+	var sum, n int64
+	for y := 0; y < pa.Dy; y++ {
+		ra, rb := boundsRow(pa, y), boundsRow(pb, y)
+		for i := range ra {
+			if alpha && i%4 == 3 {
+				continue
+			}
+			d := int64(ra[i]) - int64(rb[i])
+			sum += d * d
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return float64(sum) / float64(n), nil
+}
+
+// PSNR returns the peak signal to noise ratio of b relative to a, in dB.  It's
+// +Inf if the images are identical.  Above 40dB differences are hard to see.
+func PSNR(a, b image.Image) (float64, error) {
+	mse, err := MSE(a, b)
+	if err != nil {
+		return 0, err
+	}
+	if mse == 0 {
+		return math.Inf(1), nil
+	}
+	return 10 * math.Log10(255*255/mse), nil
+}
+
+// ssimWindow and ssimStep give the size of the square windows over which SSIM
+// is computed and the distance between them.
+const (
+	ssimWindow = 8
+	ssimStep   = 4
+)
+
+// SSIM returns the structural similarity index of the luma of a and b, which
+// must have the same size.  It's 1 for identical images and decreases as they
+// differ, taking into account local structure rather than just pixel values,
+// which makes it closer to human judgement than PSNR.
+func SSIM(a, b image.Image) (float64, error) {
+	if _, _, _, err := comparableSeqs(a, b); err != nil {
+		return 0, err
+	}
+	ga, gb := GetGray(a), GetGray(b)
+	w, h := ga.Rect.Dx(), ga.Rect.Dy()
+	if w == 0 || h == 0 {
+		return 1, nil
+	}
+	ww, wh := ssimWindow, ssimWindow
+	if w < ww {
+		ww = w
+	}
+	if h < wh {
+		wh = h
+	}
+	const c1, c2 = (0.01 * 255) * (0.01 * 255), (0.03 * 255) * (0.03 * 255)
+	var total float64
+	n := 0
+	for y := 0; y+wh <= h; y += ssimStep {
+		for x := 0; x+ww <= w; x += ssimStep {
+			var sa, sb, saa, sbb, sab float64
+			for j := 0; j < wh; j++ {
+				ia := ga.PixOffset(ga.Rect.Min.X+x, ga.Rect.Min.Y+y+j)
+				ib := gb.PixOffset(gb.Rect.Min.X+x, gb.Rect.Min.Y+y+j)
+				for i := 0; i < ww; i++ {
+					va, vb := float64(ga.Pix[ia+i]), float64(gb.Pix[ib+i])
+					sa += va
+					sb += vb
+					saa += va * va
+					sbb += vb * vb
+					sab += va * vb
+				}
+			}
+			np := float64(ww * wh)
+			ma, mb := sa/np, sb/np
+			va, vb, cov := saa/np-ma*ma, sbb/np-mb*mb, sab/np-ma*mb
+			total += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			n++
+		}
+	}
+	return total / float64(n), nil
+}
+
+// Diff returns an image the size of a, with the same bounds, in which each
+// pixel is the largest absolute difference between the samples of a and b at
+// that position, multiplied by gain and clipped to 255.  Small differences are
+// invisible unless gain is well above 1.
+func Diff(a, b image.Image, gain int) (*image.Gray, error) {
+	pa, pb, alpha, err := comparableSeqs(a, b)
+	if err != nil {
+		return nil, err
+	}
+	ret := image.NewGray(a.Bounds())
+	bpp := pa.ImageBytes.GetBytesPerPixel()
+	for y := 0; y < pa.Dy; y++ {
+		ra, rb := boundsRow(pa, y), boundsRow(pb, y)
+		dst := ret.Pix[y*ret.Stride : y*ret.Stride+pa.Dx]
+		for x := range dst {
+			max := 0
+			for i := x * bpp; i < (x+1)*bpp; i++ {
+				if alpha && i%4 == 3 {
+					continue
+				}
+				d := int(ra[i]) - int(rb[i])
+				if d < 0 {
+					d = -d
+				}
+				if d > max {
+					max = d
+				}
+			}
+			if max *= gain; max > 255 {
+				max = 255
+			}
+			dst[x] = uint8(max)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,68 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "image"
+import "image/color"
+import "math"
+
+func (s *MySuite) TestMSEAndPSNR(c *C) {
+	a := NewRGB(image.Rect(0, 0, 2, 2))
+	b := NewRGB(image.Rect(0, 0, 2, 2))
+	psnr, err := PSNR(a, b)
+	c.Assert(err, IsNil)
+	c.Check(math.IsInf(psnr, 1), Equals, true)
+
+	b.SetRGBA(1, 1, color.RGBA{12, 0, 0, 255})
+	mse, err := MSE(a, b)
+	c.Assert(err, IsNil)
+	c.Check(mse, Equals, 144.0/12)
+	psnr, _ = PSNR(a, b)
+	c.Check(math.Abs(psnr-10*math.Log10(255*255/12.0)) < 1e-9, Equals, true)
+
+	// Alpha is ignored, and different types are compared as RGB.
+	rgba := StdImage{b}.GetRGBA()
+	rgba.Pix[3] = 0
+	mse, err = MSE(a, rgba)
+	c.Assert(err, IsNil)
+	c.Check(mse, Equals, 144.0/12)
+
+	_, err = MSE(a, NewRGB(image.Rect(0, 0, 2, 3)))
+	c.Check(err, ErrorMatches, "image sizes differ.*")
+	_, err = MSE(a, nil)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestSSIM(c *C) {
+	a := image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range a.Pix {
+		a.Pix[i] = uint8(i * 7)
+	}
+	ssim, err := SSIM(a, a)
+	c.Assert(err, IsNil)
+	c.Check(math.Abs(ssim-1) < 1e-9, Equals, true)
+
+	// A little noise matters less than a lot.
+	slight, heavy := image.NewGray(a.Rect), image.NewGray(a.Rect)
+	for i := range a.Pix {
+		d := uint8(i % 3)
+		slight.Pix[i], heavy.Pix[i] = a.Pix[i]+d, a.Pix[i]+d*40
+	}
+	s1, _ := SSIM(a, slight)
+	s2, _ := SSIM(a, heavy)
+	c.Check(s1 < 1 && s2 < s1, Equals, true, Commentf("%f %f", s1, s2))
+
+	// Images smaller than the window are a single window.
+	ssim, err = SSIM(NewYUYV(image.Rect(0, 0, 4, 2)), NewYUYV(image.Rect(0, 0, 4, 2)))
+	c.Assert(err, IsNil)
+	c.Check(ssim, Equals, 1.0)
+}
+
+func (s *MySuite) TestDiff(c *C) {
+	a := NewYUYV(image.Rect(0, 0, 4, 1))
+	b := NewYUYV(image.Rect(0, 0, 4, 1))
+	copy(b.Pix, []byte{3, 0, 0, 0, 0, 0, 0, 200})
+	d, err := Diff(a, b, 10)
+	c.Assert(err, IsNil)
+	c.Check(d.Rect, Equals, a.Rect)
+	c.Check(d.Pix, DeepEquals, []byte{30, 0, 0, 255})
+}
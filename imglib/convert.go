@@ -69,28 +69,23 @@ func convertImageWithAt(dest *image.RGBA, src image.Image) {
 	}
 }
 
+// convertYCbCr converts src into dest, which must be the same size.  It
+// handles any subsampling ratio, looking up each pixel's chroma with COffset,
+// so it works both for 4:2:2 and for the 4:2:0 of most decoded JPEGs.
 func convertYCbCr(dest *image.RGBA, src *image.YCbCr) {
 	di := 0
-	yi := src.YOffset(src.Rect.Min.X, src.Rect.Min.Y)
-	ci := src.COffset(src.Rect.Min.X, src.Rect.Min.Y)
-	yskip := src.YStride - src.Rect.Dx()
-	cskip := src.CStride - src.Rect.Dx()/2
 	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
+		yi := src.YOffset(src.Rect.Min.X, y)
 		for x := src.Rect.Min.X; x < src.Rect.Max.X; x++ {
-			yv := src.Y[yi]
-			bv := src.Cb[ci]
-			rv := src.Cr[ci]
-			r, g, b := color.YCbCrToRGB(yv, bv, rv)
+			ci := src.COffset(x, y)
+			r, g, b := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
 			dest.Pix[di+0] = r
 			dest.Pix[di+1] = g
 			dest.Pix[di+2] = b
 			dest.Pix[di+3] = 0xff
 			di += rgbaBpp
 			yi++
-			ci += (x % 2)
 		}
-		yi += yskip
-		ci += cskip
 	}
 }
 
@@ -123,64 +118,58 @@ func convertYUYV(dest *image.RGBA, src *YUYV) {
 }
 */
 
+// convertYUYV uses the same fixed-point arithmetic as color.YCbCrToRGB, but
+// shares the chroma terms between the two pixels of each pair.
 func convertYUYV(dest *image.RGBA, src *YUYV) {
 	di := 0
-	si := src.PixOffset(src.Rect.Min.X, src.Rect.Min.Y)
-	skip := src.Stride - yuvBpp*(src.Rect.Dx())
+	w := src.Rect.Dx()
 	for y := src.Rect.Min.Y; y < src.Rect.Max.Y; y++ {
-		endi := si + src.Stride - skip
-		for si < endi {
-			yy1, yy2 := int(src.Pix[si+0])<<16+1<<15, int(src.Pix[si+2])<<16+1<<15
+		si := src.PixOffset(src.Rect.Min.X, y)
+		x := 0
+		if src.Rect.Min.X%2 != 0 && w > 0 {
+			// The row starts with the second pixel of a pair.
+			r, g, b := color.YCbCrToRGB(src.Pix[si], src.Pix[si-1], src.Pix[si+1])
+			dest.Pix[di+0], dest.Pix[di+1], dest.Pix[di+2], dest.Pix[di+3] = r, g, b, 0xFF
+			di += rgbaBpp
+			si += yuvBpp
+			x++
+		}
+		for ; x+1 < w; x += 2 {
+			yy1, yy2 := int(src.Pix[si+0])*0x10101, int(src.Pix[si+2])*0x10101
 			cb1, cr1 := int(src.Pix[si+1])-128, int(src.Pix[si+3])-128
 			t1, t2, t3, t4 := 91881*cr1, 46802*cr1, 22554*cb1, 116130*cb1
 
-			r1, g1, b1 := (yy1+t1)>>16, (yy1-t3-t2)>>16, (yy1+t4)>>16
-			r2, g2, b2 := (yy2+t1)>>16, (yy2-t3-t2)>>16, (yy2+t4)>>16
-			if r1 < 0 {
-				r1 = 0
-			} else if r1 > 255 {
-				r1 = 255
-			}
-			if g1 < 0 {
-				g1 = 0
-			} else if g1 > 255 {
-				g1 = 255
-			}
-			if b1 < 0 {
-				b1 = 0
-			} else if b1 > 255 {
-				b1 = 255
-			}
-			if r2 < 0 {
-				r2 = 0
-			} else if r2 > 255 {
-				r2 = 255
-			}
-			if g2 < 0 {
-				g2 = 0
-			} else if g2 > 255 {
-				g2 = 255
-			}
-			if b2 < 0 {
-				b2 = 0
-			} else if b2 > 255 {
-				b2 = 255
-			}
-
-			dest.Pix[di+0] = uint8(r1)
-			dest.Pix[di+1] = uint8(g1)
-			dest.Pix[di+2] = uint8(b1)
+			dest.Pix[di+0] = clampFix(yy1 + t1)
+			dest.Pix[di+1] = clampFix(yy1 - t3 - t2)
+			dest.Pix[di+2] = clampFix(yy1 + t4)
 			dest.Pix[di+3] = 0xFF
-			dest.Pix[di+4] = uint8(r2)
-			dest.Pix[di+5] = uint8(g2)
-			dest.Pix[di+6] = uint8(b2)
+			dest.Pix[di+4] = clampFix(yy2 + t1)
+			dest.Pix[di+5] = clampFix(yy2 - t3 - t2)
+			dest.Pix[di+6] = clampFix(yy2 + t4)
 			dest.Pix[di+7] = 0xFF
 
-			di += rgbaBpp*2
-			si += yuvBpp*2
+			di += rgbaBpp * 2
+			si += yuvBpp * 2
 		}
-		si += skip
+		if x < w {
+			// The row ends with the first pixel of a pair.
+			r, g, b := color.YCbCrToRGB(src.Pix[si], src.Pix[si+1], src.Pix[si+3])
+			dest.Pix[di+0], dest.Pix[di+1], dest.Pix[di+2], dest.Pix[di+3] = r, g, b, 0xFF
+			di += rgbaBpp
+		}
+	}
+}
+
+// clampFix converts a 16.16 fixed-point color value to a byte, clamping it to
+// [0,255].
+func clampFix(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 0xFFFFFF {
+		return 0xFF
 	}
+	return uint8(v >> 16)
 }
 
 func convertRGBA64(dest *image.RGBA, src *image.RGBA64) {
@@ -258,3 +247,57 @@ func convertRGB(dest *image.RGBA, src *RGB) {
 		si += skip
 	}
 }
+
+// chromaAccumulator is used to average chroma samples when converting to an
+// image.YCbCr with coarser subsampling than the input.
+type chromaAccumulator struct {
+	cb, cr, n []int
+}
+
+func newChromaAccumulator(dest *image.YCbCr) chromaAccumulator {
+	l := len(dest.Cb)
+	return chromaAccumulator{cb: make([]int, l), cr: make([]int, l), n: make([]int, l)}
+}
+
+func (ca chromaAccumulator) add(ci int, cb, cr uint8) {
+	ca.cb[ci] += int(cb)
+	ca.cr[ci] += int(cr)
+	ca.n[ci]++
+}
+
+func (ca chromaAccumulator) store(dest *image.YCbCr) {
+	for i, n := range ca.n {
+		if n > 0 {
+			dest.Cb[i] = uint8((ca.cb[i] + n/2) / n)
+			dest.Cr[i] = uint8((ca.cr[i] + n/2) / n)
+		}
+	}
+}
+
+// NewYCbCr returns a new image.YCbCr with the given subsampling ratio by
+// converting from img.  The output bounds start at (0,0).  If img is already
+// an *image.YCbCr with the right ratio and bounds, it's returned as is.
+func NewYCbCr(img image.Image, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	switch concrete := img.(type) {
+	case *image.YCbCr:
+		if concrete.SubsampleRatio == ratio && concrete.Rect.Min == image.ZP {
+			return concrete
+		}
+	case *YUYV:
+		return concrete.ToYCbCr(ratio)
+	}
+	rgba := StdImage{img}.GetRGBA()
+	ret := image.NewYCbCr(image.Rect(0, 0, rgba.Rect.Dx(), rgba.Rect.Dy()), ratio)
+	acc := newChromaAccumulator(ret)
+	for y := 0; y < rgba.Rect.Dy(); y++ {
+		si := rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y+y)
+		for x := 0; x < rgba.Rect.Dx(); x++ {
+			yy, cb, cr := color.RGBToYCbCr(rgba.Pix[si+0], rgba.Pix[si+1], rgba.Pix[si+2])
+			ret.Y[ret.YOffset(x, y)] = yy
+			acc.add(ret.COffset(x, y), cb, cr)
+			si += rgbaBpp
+		}
+	}
+	acc.store(ret)
+	return ret
+}
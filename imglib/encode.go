@@ -0,0 +1,118 @@
+package imglib
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// EncodePNG writes img to w in PNG format.  The png package only has fast
+// paths for the standard library's types, so YUYV and RGB images are
+// converted to image.RGBA with our own converters first rather than letting
+// png call At() for every pixel.
+func EncodePNG(w io.Writer, img image.Image) error {
+	switch img.(type) {
+	case *YUYV, *RGB:
+		img = StdImage{img}.GetRGBA()
+	}
+	return png.Encode(w, img)
+}
+
+// EncodeJPEG writes img to w in JPEG format.  YUYV images are already in the
+// colorspace JPEG uses, so they're repacked into a 4:2:2 image.YCbCr (which
+// the jpeg package handles efficiently) without a trip through RGB.  RGB
+// images are converted to image.RGBA.  o may be nil to get the default quality.
+func EncodeJPEG(w io.Writer, img image.Image, o *jpeg.Options) error {
+	switch concrete := img.(type) {
+	case *YUYV:
+		img = concrete.ToYCbCr(image.YCbCrSubsampleRatio422)
+	case *RGB:
+		img = StdImage{img}.GetRGBA()
+	}
+	return jpeg.Encode(w, img, o)
+}
+
+// EncodeFormats lists the format names accepted by Encode.
+var EncodeFormats = []string{"png", "jpeg", "ppm", "pgm"}
+
+// Encode writes img to w in the named format, which is one of EncodeFormats
+// ("jpg" is accepted as a synonym for "jpeg").
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "png":
+		return EncodePNG(w, img)
+	case "jpeg", "jpg":
+		return EncodeJPEG(w, img, nil)
+	case "ppm":
+		return EncodePPM(w, img)
+	case "pgm":
+		return EncodePGM(w, img)
+	}
+	return fmt.Errorf("unsupported image format '%s'", format)
+}
+
+// SaveImage writes img to path, choosing the format from its extension.  The
+// extensions of EncodeFormats are supported as well as those given by
+// PixelFormat.Ext, the latter being written with StoreRaw (after conversion if
+// need be).
+func SaveImage(path string, img image.Image) error {
+	ext := filepath.Ext(path)
+	if pf := PixelFormatForExt(ext); pf != PixelFormatUnknown {
+		return saveRaw(path, img, pf)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Encode(file, img, strings.TrimPrefix(ext, ".")); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	return file.Close()
+}
+
+// saveRaw writes img to path as packed pixels in format pf, converting and
+// copying as need be.
+func saveRaw(path string, img image.Image, pf PixelFormat) error {
+	switch pf {
+	case PixelFormatYUYV:
+		yuyv, ok := img.(*YUYV)
+		if !ok {
+			yuyv = NewYUYVFromYCbCr(NewYCbCr(img, image.YCbCrSubsampleRatio422))
+		} else if yuyv.Rect.Min.X%2 != 0 {
+			// Re-pair the pixels so that rows start with a whole pair.
+			yuyv = CropYUYV(yuyv, yuyv.Rect)
+		}
+		ps := GetPixelSequence(yuyv)
+		pix := make([]byte, 0, ps.RowBytes()*ps.Dy)
+		for y := 0; y < ps.Dy; y++ {
+			pix = append(pix, ps.Row(y)...)
+		}
+		return ioutil.WriteFile(path, pix, 0644)
+	case PixelFormatRGB:
+		if rgb, ok := img.(*RGB); ok {
+			return CropRGB(rgb, rgb.Rect).StoreRaw(path)
+		}
+		return StdImage{img}.GetRGB().StoreRaw(path)
+	case PixelFormatRGBA:
+		rgba := StdImage{img}.GetRGBA()
+		return ioutil.WriteFile(path, CropRGBA(rgba, rgba.Rect).Pix, 0644)
+	case PixelFormatGray:
+		gray := GetGray(img)
+		w := gray.Rect.Dx()
+		pix := make([]byte, 0, w*gray.Rect.Dy())
+		for y := gray.Rect.Min.Y; y < gray.Rect.Max.Y; y++ {
+			i := gray.PixOffset(gray.Rect.Min.X, y)
+			pix = append(pix, gray.Pix[i:i+w]...)
+		}
+		return ioutil.WriteFile(path, pix, 0644)
+	}
+	return fmt.Errorf("can't save images in format %v", pf)
+}
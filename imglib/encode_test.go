@@ -0,0 +1,140 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "bytes"
+import "image"
+import "image/color"
+import "image/jpeg"
+import "image/png"
+import "os"
+import "path/filepath"
+import "strings"
+
+func (s *MySuite) TestEncodePNG(c *C) {
+	rgb := getTestRgbImage(image.Point{7, 5})
+	var buf bytes.Buffer
+	c.Assert(EncodePNG(&buf, rgb), IsNil)
+	img, err := png.Decode(&buf)
+	c.Assert(err, IsNil)
+	c.Check(StdImage{img}.GetRGB(), DeepEquals, rgb)
+}
+
+func (s *MySuite) TestEncodeJPEG(c *C) {
+	yuyv := getTestYuyvImage(image.Point{16, 8})
+	var buf bytes.Buffer
+	c.Assert(EncodeJPEG(&buf, yuyv, &jpeg.Options{Quality: 100}), IsNil)
+	img, err := jpeg.Decode(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(img.Bounds(), DeepEquals, yuyv.Rect)
+	// JPEG is lossy, but at quality 100 luma should be close.
+	ycbcr := img.(*image.YCbCr)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			d := int(ycbcr.Y[ycbcr.YOffset(x, y)]) - int(yuyv.Pix[yuyv.PixOffset(x, y)])
+			c.Check(d*d <= 4, Equals, true, Commentf("(%d,%d) off by %d", x, y, d))
+		}
+	}
+}
+
+func (s *MySuite) TestPPM(c *C) {
+	rgb := getTestRgbImage(image.Point{5, 3})
+	var buf bytes.Buffer
+	c.Assert(EncodePPM(&buf, rgb), IsNil)
+	c.Check(strings.HasPrefix(buf.String(), "P6\n5 3\n255\n"), Equals, true)
+	img, err := DecodePNM(&buf)
+	c.Assert(err, IsNil)
+	c.Check(img, DeepEquals, rgb)
+
+	sub := rgb.SubImage(image.Rect(1, 1, 4, 3))
+	buf.Reset()
+	c.Assert(EncodePPM(&buf, sub), IsNil)
+	img, _, err = image.Decode(&buf)
+	c.Assert(err, IsNil)
+	c.Check(img, DeepEquals, CropRGB(rgb, sub.Bounds()))
+
+	yuyv := getTestYuyvImage(image.Point{6, 4})
+	buf.Reset()
+	c.Assert(EncodePPM(&buf, yuyv), IsNil)
+	img, err = DecodePNM(&buf)
+	c.Assert(err, IsNil)
+	c.Check(img, DeepEquals, NewRGBFromRGBADropAlpha(drawToRgba(yuyv)))
+}
+
+func (s *MySuite) TestPGM(c *C) {
+	yuyv := getTestYuyvImage(image.Point{6, 4})
+	var buf bytes.Buffer
+	c.Assert(EncodePGM(&buf, yuyv), IsNil)
+	img, err := DecodePNM(&buf)
+	c.Assert(err, IsNil)
+	gray := img.(*image.Gray)
+	c.Assert(gray.Rect, DeepEquals, yuyv.Rect)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 6; x++ {
+			c.Check(gray.Pix[gray.PixOffset(x, y)], Equals, yuyv.Pix[yuyv.PixOffset(x, y)])
+		}
+	}
+
+	// RGB is converted as color.GrayModel would.
+	rgb := getTestRgbImage(image.Point{5, 3})
+	buf.Reset()
+	c.Assert(EncodePGM(&buf, rgb), IsNil)
+	img, err = DecodePNM(&buf)
+	c.Assert(err, IsNil)
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			c.Check(img.At(x, y), Equals, color.GrayModel.Convert(rgb.At(x, y)))
+		}
+	}
+
+	// Comments and maxvals other than 255 are allowed.
+	img, err = DecodePNM(strings.NewReader("P5\n# made by hand\n2 1\n# really\n15\n\x00\x0f"))
+	c.Assert(err, IsNil)
+	c.Check(img.(*image.Gray).Pix, DeepEquals, []byte{0, 255})
+
+	_, err = DecodePNM(strings.NewReader("P5\n2 1\n65535\n\x00\x00\x00\x00"))
+	c.Check(err, ErrorMatches, "unsupported netpbm maxval.*")
+	_, err = DecodePNM(strings.NewReader("P6\n2 1\n255\n\x00"))
+	c.Check(err, NotNil)
+	_, err = DecodePNM(strings.NewReader("P3\n2 1\n255\n"))
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestSaveImage(c *C) {
+	dir := c.MkDir()
+	rgb := getTestRgbImage(image.Point{6, 4})
+	c.Assert(WriteRawInfo(dir, RawFormat{Format: PixelFormatRGB, Width: 6, Height: 4}), IsNil)
+	for _, ext := range []string{".png", ".ppm", ".rgb"} {
+		path := filepath.Join(dir, "test"+ext)
+		c.Assert(SaveImage(path, rgb), IsNil)
+		img, err := LoadImage(path)
+		c.Assert(err, IsNil, Commentf("%s", ext))
+		c.Check(StdImage{img}.GetRGB(), DeepEquals, rgb, Commentf("%s", ext))
+	}
+	sniffed, err := SniffExt(filepath.Join(dir, "test.ppm"))
+	c.Check(err, IsNil)
+	c.Check(sniffed, Equals, ".ppm")
+	c.Check(SaveImage(filepath.Join(dir, "test.bmp"), rgb), ErrorMatches, "unsupported image format 'bmp'")
+}
+
+func (s *MySuite) TestSaveImageOddYUYV(c *C) {
+	dir := c.MkDir()
+	c.Assert(WriteRawInfo(dir, RawFormat{Format: PixelFormatYUYV, Width: 5, Height: 2}), IsNil)
+	yuyv := getTestYuyvImage(image.Point{6, 2})
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 5, 2), image.Rect(1, 0, 6, 2)} {
+		sub := yuyv.SubImage(r)
+		path := filepath.Join(dir, "test.yuv")
+		c.Assert(SaveImage(path, sub), IsNil)
+		fi, err := os.Stat(path)
+		c.Assert(err, IsNil)
+		c.Check(fi.Size(), Equals, int64(2*6*yuvBpp), Commentf("%v", r))
+		img, err := LoadImage(path)
+		c.Assert(err, IsNil, Commentf("%v", r))
+		c.Assert(img.Bounds(), Equals, image.Rect(0, 0, 5, 2))
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 5; x++ {
+				got, want := img.At(x, y).(color.YCbCr), sub.At(r.Min.X+x, y).(color.YCbCr)
+				c.Check(got.Y, Equals, want.Y, Commentf("%v (%d,%d)", r, x, y))
+			}
+		}
+	}
+}
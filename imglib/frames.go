@@ -0,0 +1,327 @@
+package imglib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"time"
+)
+
+// FramesExt is the conventional file extension for frame containers.
+const FramesExt = ".frames"
+
+// framesMagic identifies a frame container; the trailing digit is the version.
+var framesMagic = [8]byte{'I', 'M', 'G', 'F', 'R', 'M', 'S', '1'}
+
+const (
+	framesHeaderSize = 32
+	frameRecordSize  = 20
+)
+
+// PixelFormat identifies the layout of raw pixel bytes.
+type PixelFormat uint16
+
+const (
+	PixelFormatUnknown PixelFormat = iota
+	PixelFormatYUYV
+	PixelFormatRGB
+	PixelFormatRGBA
+	PixelFormatGray
+)
+
+func (pf PixelFormat) String() string {
+	switch pf {
+	case PixelFormatYUYV:
+		return "yuyv"
+	case PixelFormatRGB:
+		return "rgb"
+	case PixelFormatRGBA:
+		return "rgba"
+	case PixelFormatGray:
+		return "gray"
+	}
+	return fmt.Sprintf("PixelFormat(%d)", int(pf))
+}
+
+// BytesPerPixel returns the number of bytes used by each pixel, or 0 if pf is
+// unknown.
+func (pf PixelFormat) BytesPerPixel() int {
+	switch pf {
+	case PixelFormatYUYV:
+		return yuvBpp
+	case PixelFormatRGB:
+		return rgbBpp
+	case PixelFormatRGBA:
+		return rgbaBpp
+	case PixelFormatGray:
+		return 1
+	}
+	return 0
+}
+
+// RowBytes returns the number of bytes in a packed row of width pixels in
+// format pf.  YUYV rows hold whole pixel pairs, so an odd width is padded.
+func (pf PixelFormat) RowBytes(width int) int {
+	if pf == PixelFormatYUYV {
+		width = pairCeil(width)
+	}
+	return width * pf.BytesPerPixel()
+}
+
+// NewImage returns an image of format pf whose pixels are pix, which is not copied.
+func (pf PixelFormat) NewImage(pix []byte, stride int, r image.Rectangle) (image.Image, error) {
+	switch pf {
+	case PixelFormatYUYV:
+		return &YUYV{Pix: pix, Stride: stride, Rect: r}, nil
+	case PixelFormatRGB:
+		return &RGB{Pix: pix, Stride: stride, Rect: r}, nil
+	case PixelFormatRGBA:
+		return &image.RGBA{Pix: pix, Stride: stride, Rect: r}, nil
+	case PixelFormatGray:
+		return &image.Gray{Pix: pix, Stride: stride, Rect: r}, nil
+	}
+	return nil, fmt.Errorf("can't build image of unknown format %v", pf)
+}
+
+// GetPixelFormat returns the PixelFormat describing ib.
+func GetPixelFormat(ib ImageBytes) PixelFormat {
+	switch ib.(type) {
+	case YuyvBytes:
+		return PixelFormatYUYV
+	case RgbBytes:
+		return PixelFormatRGB
+	case RgbaBytes:
+		return PixelFormatRGBA
+	case GrayBytes:
+		return PixelFormatGray
+	}
+	return PixelFormatUnknown
+}
+
+// FramesHeader is found at the start of every frame container and describes
+// all the frames within it.  Count is zero if the writer couldn't seek back to
+// fill it in when it finished, in which case readers must scan the file.
+type FramesHeader struct {
+	Format PixelFormat
+	Width  int
+	Height int
+	Stride int
+	Count  int
+}
+
+// FrameSize returns the number of pixel bytes in each frame.
+func (fh FramesHeader) FrameSize() int {
+	return fh.Stride * fh.Height
+}
+
+func (fh FramesHeader) marshal() []byte {
+	b := make([]byte, framesHeaderSize)
+	copy(b, framesMagic[:])
+	binary.LittleEndian.PutUint16(b[8:], uint16(fh.Format))
+	binary.LittleEndian.PutUint32(b[12:], uint32(fh.Width))
+	binary.LittleEndian.PutUint32(b[16:], uint32(fh.Height))
+	binary.LittleEndian.PutUint32(b[20:], uint32(fh.Stride))
+	binary.LittleEndian.PutUint32(b[24:], uint32(fh.Count))
+	return b
+}
+
+func unmarshalFramesHeader(b []byte) (FramesHeader, error) {
+	if len(b) < framesHeaderSize || !IsFrames(b) {
+		return FramesHeader{}, fmt.Errorf("not a frame container")
+	}
+	fh := FramesHeader{
+		Format: PixelFormat(binary.LittleEndian.Uint16(b[8:])),
+		Width:  int(binary.LittleEndian.Uint32(b[12:])),
+		Height: int(binary.LittleEndian.Uint32(b[16:])),
+		Stride: int(binary.LittleEndian.Uint32(b[20:])),
+		Count:  int(binary.LittleEndian.Uint32(b[24:])),
+	}
+	if fh.Format.BytesPerPixel() == 0 {
+		return FramesHeader{}, fmt.Errorf("frame container has unknown pixel format %d", int(fh.Format))
+	}
+	if fh.Stride < fh.Format.RowBytes(fh.Width) {
+		return FramesHeader{}, fmt.Errorf("frame container has invalid stride %d for width %d", fh.Stride, fh.Width)
+	}
+	return fh, nil
+}
+
+// IsFrames returns true if b starts with the frame container magic number.
+func IsFrames(b []byte) bool {
+	return bytes.HasPrefix(b, framesMagic[:])
+}
+
+// FrameRecord describes a single frame in a container.
+type FrameRecord struct {
+	CreationTs time.Time
+	SeqNum     int
+	Pix        []byte
+}
+
+// A FramesWriter writes frames to an underlying stream.  If the stream is an
+// io.WriteSeeker, Close will update the frame count in the header.
+type FramesWriter struct {
+	w   io.Writer
+	hdr FramesHeader
+	rec []byte
+}
+
+// NewFramesWriter writes hdr to w and returns a FramesWriter ready to accept
+// frames.  If hdr.Stride is zero, frames are assumed to be packed.
+func NewFramesWriter(w io.Writer, hdr FramesHeader) (*FramesWriter, error) {
+	if hdr.Format.BytesPerPixel() == 0 {
+		return nil, fmt.Errorf("can't write frames of unknown format %v", hdr.Format)
+	}
+	if hdr.Stride == 0 {
+		hdr.Stride = hdr.Format.RowBytes(hdr.Width)
+	}
+	hdr.Count = 0
+	if _, err := w.Write(hdr.marshal()); err != nil {
+		return nil, err
+	}
+	return &FramesWriter{w: w, hdr: hdr, rec: make([]byte, frameRecordSize)}, nil
+}
+
+// Header returns the header describing the frames written so far.
+func (fw *FramesWriter) Header() FramesHeader {
+	return fw.hdr
+}
+
+// WriteFrame appends a frame to the stream.  pix must be exactly
+// Header().FrameSize() bytes long.
+func (fw *FramesWriter) WriteFrame(ts time.Time, seqnum int, pix []byte) error {
+	if len(pix) != fw.hdr.FrameSize() {
+		return fmt.Errorf("frame %d is %d bytes, expected %d", seqnum, len(pix), fw.hdr.FrameSize())
+	}
+	binary.LittleEndian.PutUint64(fw.rec[0:], uint64(ts.UnixNano()))
+	binary.LittleEndian.PutUint64(fw.rec[8:], uint64(seqnum))
+	binary.LittleEndian.PutUint32(fw.rec[16:], uint32(len(pix)))
+	if _, err := fw.w.Write(fw.rec); err != nil {
+		return err
+	}
+	if _, err := fw.w.Write(pix); err != nil {
+		return err
+	}
+	fw.hdr.Count++
+	return nil
+}
+
+// Close updates the frame count in the header if possible.  It doesn't close
+// the underlying stream.
+func (fw *FramesWriter) Close() error {
+	ws, ok := fw.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	if _, err := ws.Seek(0, os.SEEK_SET); err != nil {
+		return err
+	}
+	if _, err := ws.Write(fw.hdr.marshal()); err != nil {
+		return err
+	}
+	_, err := ws.Seek(0, os.SEEK_END)
+	return err
+}
+
+// FramesFile provides random access to the frames in a container file, which
+// is mapped into memory.  The images it returns share their pixels with the
+// mapping, so they must not be used after Close.
+type FramesFile struct {
+	hdr     FramesHeader
+	data    []byte
+	offsets []int
+	unmap   func() error
+}
+
+// OpenFramesFile maps the container at path and builds an index of its frames.
+// A truncated final frame is ignored.
+func OpenFramesFile(path string) (*FramesFile, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ff, err := newFramesFile(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("error reading '%s': %v", path, err)
+	}
+	ff.unmap = unmap
+	return ff, nil
+}
+
+func newFramesFile(data []byte) (*FramesFile, error) {
+	hdr, err := unmarshalFramesHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	ff := &FramesFile{hdr: hdr, data: data}
+	for p := framesHeaderSize; p+frameRecordSize <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[p+16:]))
+		if n != hdr.FrameSize() {
+			return nil, fmt.Errorf("frame %d at offset %d has length %d, expected %d", len(ff.offsets), p, n, hdr.FrameSize())
+		}
+		if p+frameRecordSize+n > len(data) {
+			break
+		}
+		ff.offsets = append(ff.offsets, p)
+		p += frameRecordSize + n
+	}
+	ff.hdr.Count = len(ff.offsets)
+	return ff, nil
+}
+
+// Header returns the container header; Count reflects the frames actually found.
+func (ff *FramesFile) Header() FramesHeader {
+	return ff.hdr
+}
+
+// Len returns the number of frames.
+func (ff *FramesFile) Len() int {
+	return len(ff.offsets)
+}
+
+// Frame returns the ith frame.
+func (ff *FramesFile) Frame(i int) FrameRecord {
+	p := ff.offsets[i]
+	rec := ff.data[p : p+frameRecordSize]
+	start := p + frameRecordSize
+	return FrameRecord{
+		CreationTs: time.Unix(0, int64(binary.LittleEndian.Uint64(rec[0:]))),
+		SeqNum:     int(binary.LittleEndian.Uint64(rec[8:])),
+		Pix:        ff.data[start : start+ff.hdr.FrameSize() : start+ff.hdr.FrameSize()],
+	}
+}
+
+// Image returns the ith frame as an image sharing memory with ff.
+func (ff *FramesFile) Image(i int) image.Image {
+	img, _ := ff.hdr.Format.NewImage(ff.Frame(i).Pix, ff.hdr.Stride, image.Rect(0, 0, ff.hdr.Width, ff.hdr.Height))
+	return img
+}
+
+// Close releases the memory mapping.
+func (ff *FramesFile) Close() error {
+	ff.data, ff.offsets = nil, nil
+	if ff.unmap == nil {
+		return nil
+	}
+	err := ff.unmap()
+	ff.unmap = nil
+	return err
+}
+
+// LoadFramesImage returns a copy of the first frame of the container at path.
+func LoadFramesImage(path string) (image.Image, error) {
+	ff, err := OpenFramesFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer ff.Close()
+	if ff.Len() == 0 {
+		return nil, fmt.Errorf("no frames in '%s'", path)
+	}
+	pix := make([]byte, ff.hdr.FrameSize())
+	copy(pix, ff.Frame(0).Pix)
+	return ff.hdr.Format.NewImage(pix, ff.hdr.Stride, image.Rect(0, 0, ff.hdr.Width, ff.hdr.Height))
+}
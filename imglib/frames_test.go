@@ -0,0 +1,64 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "bytes"
+import "image"
+import "os"
+import "path/filepath"
+import "time"
+
+func (s *MySuite) TestFramesRoundTrip(c *C) {
+	path := filepath.Join(c.MkDir(), "test"+FramesExt)
+	file, err := os.Create(path)
+	c.Assert(err, IsNil)
+
+	imgs := []*YUYV{getTestYuyvImage(image.Point{8, 4}), NewYUYV(image.Rect(0, 0, 8, 4))}
+	t0 := time.Unix(1400000000, 123456789)
+	fw, err := NewFramesWriter(file, FramesHeader{Format: PixelFormatYUYV, Width: 8, Height: 4})
+	c.Assert(err, IsNil)
+	for i, img := range imgs {
+		c.Check(fw.WriteFrame(t0.Add(time.Duration(i)*time.Second), 10+i, img.Pix), IsNil)
+	}
+	c.Check(fw.WriteFrame(t0, 12, make([]byte, 3)), NotNil)
+	c.Check(fw.Close(), IsNil)
+	c.Check(file.Close(), IsNil)
+
+	ff, err := OpenFramesFile(path)
+	c.Assert(err, IsNil)
+	defer ff.Close()
+	c.Check(ff.Header(), DeepEquals, FramesHeader{Format: PixelFormatYUYV, Width: 8, Height: 4, Stride: 16, Count: 2})
+	c.Assert(ff.Len(), Equals, 2)
+	for i, img := range imgs {
+		fr := ff.Frame(i)
+		c.Check(fr.SeqNum, Equals, 10+i)
+		c.Check(fr.CreationTs.Equal(t0.Add(time.Duration(i)*time.Second)), Equals, true)
+		c.Check(ff.Image(i), DeepEquals, img)
+	}
+
+	loaded, err := LoadImage(path)
+	c.Check(err, IsNil)
+	c.Check(loaded, DeepEquals, imgs[0])
+}
+
+func (s *MySuite) TestFramesStreaming(c *C) {
+	// A non-seekable writer leaves Count at zero and a truncated final frame
+	// should be ignored by the reader.
+	var buf bytes.Buffer
+	rgb := getTestRgbImage(image.Point{3, 2})
+	fw, err := NewFramesWriter(&buf, FramesHeader{Format: PixelFormatRGB, Width: 3, Height: 2})
+	c.Assert(err, IsNil)
+	c.Check(fw.WriteFrame(time.Unix(0, 0), 0, rgb.Pix), IsNil)
+	c.Check(fw.WriteFrame(time.Unix(0, 1), 1, rgb.Pix), IsNil)
+	c.Check(fw.Close(), IsNil)
+	data := buf.Bytes()
+	c.Check(IsFrames(data), Equals, true)
+
+	ff, err := newFramesFile(data[:len(data)-1])
+	c.Assert(err, IsNil)
+	c.Check(ff.Len(), Equals, 1)
+	c.Check(ff.Header().Count, Equals, 1)
+	c.Check(ff.Image(0), DeepEquals, rgb)
+
+	_, err = newFramesFile(rgb.Pix)
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,385 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "fmt"
+import "image"
+import "image/color"
+import "math/rand"
+import "testing"
+
+// The tests in this file check conversions and sub-images of randomly sized
+// and placed images against slow reference implementations.  The same checks
+// are run from a randomized gocheck test and from Go fuzz targets, so that
+// "go test -fuzz FuzzYUYV" can search for more edge cases.
+
+// convCase describes a parent image and a rectangle within it to take a
+// sub-image of.  seed determines the pixel values.
+type convCase struct {
+	parent, sub image.Rectangle
+	seed        int64
+}
+
+func (cc convCase) String() string {
+	return fmt.Sprintf("parent=%v sub=%v seed=%d", cc.parent, cc.sub, cc.seed)
+}
+
+// newConvCase builds a convCase from arbitrary fuzzer inputs, limiting the
+// sizes to keep each case quick.
+func newConvCase(px, py int8, pw, ph uint8, sx, sy int8, sw, sh uint8, seed int64) convCase {
+	parent := image.Rect(int(px), int(py), int(px)+int(pw%33), int(py)+int(ph%17))
+	sub := image.Rect(int(sx), int(sy), int(sx)+int(sw%33), int(sy)+int(sh%17)).Add(parent.Min)
+	return convCase{parent: parent, sub: sub, seed: seed}
+}
+
+func randConvCase(rnd *rand.Rand) convCase {
+	r8 := func() int8 { return int8(rnd.Intn(11) - 5) }
+	u8 := func() uint8 { return uint8(rnd.Intn(256)) }
+	return newConvCase(r8(), r8(), u8(), u8(), int8(rnd.Intn(9)-2), int8(rnd.Intn(9)-2), u8(), u8(), rnd.Int63())
+}
+
+// yuyvModel is the reference for a YUYV image: the luma of each pixel and the
+// chroma of each pair, keyed by the position of the pair's first pixel, which
+// is always in an even column.
+type yuyvModel struct {
+	y, cb, cr map[image.Point]uint8
+}
+
+func (m yuyvModel) at(x, y int) color.YCbCr {
+	p := image.Point{x &^ 1, y}
+	return color.YCbCr{m.y[image.Point{x, y}], m.cb[p], m.cr[p]}
+}
+
+// newYUYVModel returns a random model for r and a YUYV holding it, packed by
+// hand following the layout documented on YUYV.PixOffset.
+func newYUYVModel(r image.Rectangle, seed int64) (yuyvModel, *YUYV) {
+	rnd := rand.New(rand.NewSource(seed))
+	m := yuyvModel{map[image.Point]uint8{}, map[image.Point]uint8{}, map[image.Point]uint8{}}
+	img := NewYUYV(r)
+	if r.Empty() {
+		return m, img
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[(y-r.Min.Y)*img.Stride:]
+		for x := r.Min.X &^ 1; x < r.Max.X; x += 2 {
+			i := 2 * (x - r.Min.X&^1)
+			p := image.Point{x, y}
+			m.cb[p], m.cr[p] = uint8(rnd.Intn(256)), uint8(rnd.Intn(256))
+			row[i+1], row[i+3] = m.cb[p], m.cr[p]
+			for dx := 0; dx < 2; dx++ {
+				if q := p.Add(image.Point{dx, 0}); q.In(r) {
+					m.y[q] = uint8(rnd.Intn(256))
+					row[i+2*dx] = m.y[q]
+				}
+			}
+		}
+	}
+	return m, img
+}
+
+// forEach calls f for each point in r, stopping at the first error.
+func forEach(r image.Rectangle, f func(x, y int) error) error {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if err := f(x, y); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func rgbaAt(img *image.RGBA, x, y int) [3]uint8 {
+	i := img.PixOffset(x, y)
+	return [3]uint8{img.Pix[i], img.Pix[i+1], img.Pix[i+2]}
+}
+
+func ycbcrToRGB(c color.YCbCr) [3]uint8 {
+	r, g, b := color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+	return [3]uint8{r, g, b}
+}
+
+// checkYUYV checks YUYV access and conversions against the model, for both
+// the parent image and the sub-image.
+func (cc convCase) checkYUYV() error {
+	m, parent := newYUYVModel(cc.parent, cc.seed)
+	subs := []image.Image{parent}
+	if !cc.sub.Intersect(cc.parent).Empty() {
+		subs = append(subs, parent.SubImage(cc.sub), parent.StrictSubImage(cc.sub))
+	}
+	for _, simg := range subs {
+		img := simg.(*YUYV)
+		r := img.Rect
+		errorf := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%v img=%v: %s", cc, r, fmt.Sprintf(format, args...))
+		}
+
+		row := make([]color.YCbCr, r.Dx())
+		rgba := StdImage{img}.GetRGBA()
+		rgbBuf := make([]byte, 3*r.Dx()*r.Dy())
+		img.ToRGBGeneric(rgbBuf, 0, 1, 2, 3)
+		ycc := img.ToYCbCr(image.YCbCrSubsampleRatio444)
+		gray := img.ToGray()
+		ps := GetPixelSequence(img)
+		fromPs := ps.ImageBytes.AsImage(ps.GetStride(), ps.Bounds())
+		err := forEach(r, func(x, y int) error {
+			want := m.at(x, y)
+			dx, dy := x-r.Min.X, y-r.Min.Y
+			if got := img.At(x, y); got != want {
+				return errorf("At(%d,%d)=%v, want %v", x, y, got, want)
+			}
+			if dx == 0 {
+				img.GetRow(y, row)
+			}
+			if row[dx] != want {
+				return errorf("GetRow(%d)[%d]=%v, want %v", y, dx, row[dx], want)
+			}
+			if got := fromPs.At(x, y); got != want {
+				return errorf("PixelSequence image At(%d,%d)=%v, want %v", x, y, got, want)
+			}
+			wantRGB := ycbcrToRGB(want)
+			if got := rgbaAt(rgba, dx, dy); got != wantRGB {
+				return errorf("GetRGBA at (%d,%d)=%v, want %v", x, y, got, wantRGB)
+			}
+			i := 3 * (dy*r.Dx() + dx)
+			if got := [3]uint8{rgbBuf[i], rgbBuf[i+1], rgbBuf[i+2]}; got != wantRGB {
+				return errorf("ToRGBGeneric at (%d,%d)=%v, want %v", x, y, got, wantRGB)
+			}
+			if got := ycc.YCbCrAt(dx, dy); got != want {
+				return errorf("ToYCbCr(444) at (%d,%d)=%v, want %v", x, y, got, want)
+			}
+			if got := gray.GrayAt(x, y).Y; got != want.Y {
+				return errorf("ToGray at (%d,%d)=%d, want %d", x, y, got, want.Y)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if !r.Empty() && ps.Bounds() != r {
+			return errorf("PixelSequence bounds %v, want %v", ps.Bounds(), r)
+		}
+		if rb := ps.RowBounds(); !r.Empty() && (rb.Min.X%2 != 0 || rb.Dx()%2 != 0 || rb.Intersect(r) != r) {
+			return errorf("PixelSequence rows %v don't cover whole pairs of %v", rb, r)
+		}
+
+		// Converting back from YCbCr loses nothing if the pairs are the same.
+		if r.Min.X%2 == 0 {
+			back := NewYUYVFromYCbCr(img.ToYCbCr(image.YCbCrSubsampleRatio422))
+			err := forEach(r, func(x, y int) error {
+				if got, want := back.At(x-r.Min.X, y-r.Min.Y), m.at(x, y); got != want {
+					return errorf("422 round trip at (%d,%d)=%v, want %v", x, y, got, want)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// The MinZp variants only work on images at (0,0).
+	zp := parent.Rect.Sub(parent.Rect.Min)
+	zimg := &YUYV{Pix: parent.Pix, Stride: parent.Stride, Rect: zp}
+	if parent.Rect.Min.X%2 != 0 {
+		return nil
+	}
+	ycc := zimg.ToYCbCrMinZp()
+	back := NewYUYVFromYCbCrMinZP(ycc)
+	rgbBuf := make([]byte, 4*zp.Dx()*zp.Dy())
+	zimg.ToRGBMinZp(rgbBuf, 0, 1, 2, 4)
+	return forEach(zp, func(x, y int) error {
+		want := m.at(x+parent.Rect.Min.X, y+parent.Rect.Min.Y)
+		if got := ycc.YCbCrAt(x, y); got != want {
+			return fmt.Errorf("%v: ToYCbCrMinZp at (%d,%d)=%v, want %v", cc, x, y, got, want)
+		}
+		if got := back.At(x, y); got != want {
+			return fmt.Errorf("%v: MinZp round trip at (%d,%d)=%v, want %v", cc, x, y, got, want)
+		}
+		i := 4 * (y*zp.Dx() + x)
+		if got := [3]uint8{rgbBuf[i], rgbBuf[i+1], rgbBuf[i+2]}; got != ycbcrToRGB(want) || rgbBuf[i+3] != 0xFF {
+			return fmt.Errorf("%v: ToRGBMinZp at (%d,%d)=%v, want %v", cc, x, y, got, ycbcrToRGB(want))
+		}
+		return nil
+	})
+}
+
+// checkRGB checks RGB access and conversions of sub-images against the
+// parent's Pix, indexed by hand.
+func (cc convCase) checkRGB() error {
+	rnd := rand.New(rand.NewSource(cc.seed))
+	parent := NewRGB(cc.parent)
+	for i := range parent.Pix {
+		parent.Pix[i] = uint8(rnd.Intn(256))
+	}
+	ref := func(x, y int) color.RGBA {
+		i := (y-cc.parent.Min.Y)*3*cc.parent.Dx() + (x-cc.parent.Min.X)*3
+		return color.RGBA{parent.Pix[i], parent.Pix[i+1], parent.Pix[i+2], 0xFF}
+	}
+	subs := []image.Image{parent}
+	if !cc.sub.Intersect(cc.parent).Empty() {
+		subs = append(subs, parent.SubImage(cc.sub), parent.StrictSubImage(cc.sub))
+	}
+	for _, simg := range subs {
+		img := simg.(*RGB)
+		r := img.Rect
+		row := make([]color.RGBA, r.Dx())
+		rgba := StdImage{img}.GetRGBA()
+		gray := img.ToGray()
+		err := forEach(r, func(x, y int) error {
+			want := ref(x, y)
+			if got := img.At(x, y); got != want {
+				return fmt.Errorf("%v img=%v: At(%d,%d)=%v, want %v", cc, r, x, y, got, want)
+			}
+			if x == r.Min.X {
+				img.GetRow(y, row)
+			}
+			if got := row[x-r.Min.X]; got != want {
+				return fmt.Errorf("%v img=%v: GetRow(%d)[%d]=%v, want %v", cc, r, y, x-r.Min.X, got, want)
+			}
+			if got := rgba.RGBAAt(x-r.Min.X, y-r.Min.Y); got != want {
+				return fmt.Errorf("%v img=%v: GetRGBA at (%d,%d)=%v, want %v", cc, r, x, y, got, want)
+			}
+			if got, want := gray.GrayAt(x, y), color.GrayModel.Convert(want); got != want {
+				return fmt.Errorf("%v img=%v: ToGray at (%d,%d)=%v, want %v", cc, r, x, y, got, want)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var subsampleRatios = []image.YCbCrSubsampleRatio{
+	image.YCbCrSubsampleRatio444,
+	image.YCbCrSubsampleRatio422,
+	image.YCbCrSubsampleRatio420,
+	image.YCbCrSubsampleRatio440,
+	image.YCbCrSubsampleRatio411,
+	image.YCbCrSubsampleRatio410,
+}
+
+// checkYCbCr checks conversions from sub-images of image.YCbCr of every
+// subsampling ratio against the standard library's YCbCrAt.
+func (cc convCase) checkYCbCr() error {
+	rnd := rand.New(rand.NewSource(cc.seed))
+	// image.NewYCbCr allocates too little chroma for bounds with negative
+	// coordinates, so keep to the positive quadrant.  Moving by a multiple of
+	// 4 keeps the chroma sampling the same for every ratio.
+	off := image.Point{}
+	if m := cc.parent.Min; m.X < 0 {
+		off.X = (-m.X + 3) &^ 3
+	}
+	if m := cc.parent.Min; m.Y < 0 {
+		off.Y = (-m.Y + 3) &^ 3
+	}
+	cc.parent, cc.sub = cc.parent.Add(off), cc.sub.Add(off)
+	for _, ratio := range subsampleRatios {
+		parent := image.NewYCbCr(cc.parent, ratio)
+		for _, b := range [][]byte{parent.Y, parent.Cb, parent.Cr} {
+			for i := range b {
+				b[i] = uint8(rnd.Intn(256))
+			}
+		}
+		subs := []*image.YCbCr{parent}
+		if !cc.sub.Intersect(cc.parent).Empty() {
+			subs = append(subs, parent.SubImage(cc.sub).(*image.YCbCr))
+		}
+		for _, img := range subs {
+			r := img.Rect
+			rgba := StdImage{img}.GetRGBA()
+			yuyv := NewYUYVFromYCbCr(img)
+			err := forEach(r, func(x, y int) error {
+				want := img.YCbCrAt(x, y)
+				dx, dy := x-r.Min.X, y-r.Min.Y
+				if got := rgbaAt(rgba, dx, dy); got != ycbcrToRGB(want) {
+					return fmt.Errorf("%v %v img=%v: GetRGBA at (%d,%d)=%v, want %v", cc, ratio, r, x, y, got, ycbcrToRGB(want))
+				}
+				if got := yuyv.At(dx, dy).(color.YCbCr).Y; got != want.Y {
+					return fmt.Errorf("%v %v img=%v: NewYUYVFromYCbCr luma at (%d,%d)=%d, want %d", cc, ratio, r, x, y, got, want.Y)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (cc convCase) checkAll() error {
+	for _, f := range []func() error{cc.checkYUYV, cc.checkRGB, cc.checkYCbCr} {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MySuite) TestRandomConversions(c *C) {
+	rnd := rand.New(rand.NewSource(1))
+	n := 500
+	if testing.Short() {
+		n = 50
+	}
+	for i := 0; i < n; i++ {
+		cc := randConvCase(rnd)
+		if err := cc.checkAll(); err != nil {
+			c.Fatal(err)
+		}
+	}
+}
+
+func (s *MySuite) TestYuyvOddEdges(c *C) {
+	// Odd widths are padded so the last pixel has chroma.
+	img := NewYUYV(image.Rect(-3, 0, 2, 1))
+	c.Check(img.Stride, Equals, 2*6)
+	c.Check(img.PixOffset(-3, 0), Equals, 2)
+	img.SetRow(0, []color.YCbCr{{1, 10, 20}, {2, 30, 40}, {3, 50, 60}, {4, 70, 80}, {5, 90, 100}})
+	c.Check(img.Pix, DeepEquals, []byte{0, 10, 1, 20, 2, 40, 3, 50, 4, 80, 5, 90})
+	c.Check(img.At(-3, 0), Equals, color.YCbCr{1, 10, 20})
+	c.Check(img.At(1, 0), Equals, color.YCbCr{5, 80, 90})
+
+	// Sub-images starting on odd columns keep their pair's chroma.
+	sub := img.SubImage(image.Rect(-1, 0, 1, 1)).(*YUYV)
+	c.Check(sub.At(-1, 0), Equals, color.YCbCr{3, 40, 50})
+	c.Check(len(img.StrictSubImage(img.Rect).(*YUYV).Pix), Equals, len(img.Pix))
+}
+
+func seedFuzz(f *testing.F) {
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 20; i++ {
+		f.Add(int8(rnd.Intn(11)-5), int8(rnd.Intn(11)-5), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)),
+			int8(rnd.Intn(9)-2), int8(rnd.Intn(9)-2), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), rnd.Int63())
+	}
+}
+
+func FuzzYUYV(f *testing.F) {
+	seedFuzz(f)
+	f.Fuzz(func(t *testing.T, px, py int8, pw, ph uint8, sx, sy int8, sw, sh uint8, seed int64) {
+		if err := newConvCase(px, py, pw, ph, sx, sy, sw, sh, seed).checkYUYV(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzRGB(f *testing.F) {
+	seedFuzz(f)
+	f.Fuzz(func(t *testing.T, px, py int8, pw, ph uint8, sx, sy int8, sw, sh uint8, seed int64) {
+		if err := newConvCase(px, py, pw, ph, sx, sy, sw, sh, seed).checkRGB(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzYCbCr(f *testing.F) {
+	seedFuzz(f)
+	f.Fuzz(func(t *testing.T, px, py int8, pw, ph uint8, sx, sy int8, sw, sh uint8, seed int64) {
+		if err := newConvCase(px, py, pw, ph, sx, sy, sw, sh, seed).checkYCbCr(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
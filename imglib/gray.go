@@ -0,0 +1,97 @@
+package imglib
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// GrayBytes holds 8-bit luma samples, as used by image.Gray.  Working on luma
+// alone halves the memory and CPU needed for analysis compared to YUYV, and
+// thirds it compared to RGB.
+type GrayBytes []byte
+
+func (gray GrayBytes) GetBytes() []byte {
+	return []byte(gray)
+}
+func (gray GrayBytes) GetBytesPerPixel() int {
+	return 1
+}
+func (gray GrayBytes) AsImage(stride int, r image.Rectangle) image.Image {
+	return &image.Gray{Pix: gray.GetBytes(), Stride: stride, Rect: r}
+}
+func (gray GrayBytes) String() string {
+	return fmt.Sprintf("GrayBytes[%d]", len(gray))
+}
+
+func init() {
+	RegisterImageBytes(&image.Gray{}, func(img image.Image) (ImageBytes, int) {
+		raw := img.(*image.Gray)
+		return GrayBytes(raw.Pix), raw.Stride
+	})
+}
+
+// ToGray returns a new image.Gray with the same bounds as img containing its
+// Y samples.  No arithmetic is involved, so this is very cheap.
+func (img *YUYV) ToGray() *image.Gray {
+	ret := image.NewGray(img.Rect)
+	w := img.Rect.Dx()
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		si, di := img.PixOffset(img.Rect.Min.X, y), ret.PixOffset(ret.Rect.Min.X, y)
+		dst := ret.Pix[di : di+w]
+		for x := range dst {
+			dst[x] = img.Pix[si+x*yuvBpp]
+		}
+	}
+	return ret
+}
+
+// ToGray returns a new image.Gray with the same bounds as img containing the
+// luma of each pixel, computed as color.GrayModel does.
+func (img *RGB) ToGray() *image.Gray {
+	ret := image.NewGray(img.Rect)
+	grayPacked(ret, img.Pix, img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y), img.Stride, rgbBpp)
+	return ret
+}
+
+// grayPacked fills dest with the luma of the packed RGB or RGBA pixels in pix,
+// the first of which is at start.
+func grayPacked(dest *image.Gray, pix []byte, start, stride, bpp int) {
+	w, h := dest.Rect.Dx(), dest.Rect.Dy()
+	for y := 0; y < h; y++ {
+		si := start + y*stride
+		dst := dest.Pix[y*dest.Stride : y*dest.Stride+w]
+		for x := range dst {
+			// The same arithmetic as color.GrayModel, on 16 bit values.
+			r, g, b := uint32(pix[si])*0x101, uint32(pix[si+1])*0x101, uint32(pix[si+2])*0x101
+			dst[x] = uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+			si += bpp
+		}
+	}
+}
+
+// GetGray returns the luma of img as an image.Gray with the same bounds.
+// YUYV, RGB and RGBA have fast paths; an image.Gray is returned as is, and
+// anything else goes through color.GrayModel one pixel at a time.
+func GetGray(img image.Image) *image.Gray {
+	switch concrete := img.(type) {
+	case *image.Gray:
+		return concrete
+	case *YUYV:
+		return concrete.ToGray()
+	case *RGB:
+		return concrete.ToGray()
+	case *image.RGBA:
+		ret := image.NewGray(concrete.Rect)
+		grayPacked(ret, concrete.Pix, concrete.PixOffset(concrete.Rect.Min.X, concrete.Rect.Min.Y), concrete.Stride, rgbaBpp)
+		return ret
+	}
+	r := img.Bounds()
+	ret := image.NewGray(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			ret.SetGray(x, y, color.GrayModel.Convert(img.At(x, y)).(color.Gray))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,64 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "image"
+import "image/color"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+
+func (s *MySuite) TestGrayFromYUYV(c *C) {
+	yuyv := NewYUYV(image.Rect(0, 0, 4, 2))
+	copy(yuyv.Pix, []byte{
+		10, 100, 20, 150, 30, 110, 40, 160,
+		50, 120, 60, 170, 70, 130, 80, 180,
+	})
+	gray := yuyv.ToGray()
+	c.Check(gray.Rect, Equals, yuyv.Rect)
+	c.Check(gray.Pix, DeepEquals, []byte{10, 20, 30, 40, 50, 60, 70, 80})
+
+	// Sub-images keep their bounds.
+	sub := yuyv.SubImage(image.Rect(1, 1, 3, 2)).(*YUYV)
+	gray = GetGray(sub)
+	c.Check(gray.Rect, Equals, image.Rect(1, 1, 3, 2))
+	c.Check(gray.GrayAt(1, 1).Y, Equals, uint8(60))
+	c.Check(gray.GrayAt(2, 1).Y, Equals, uint8(70))
+}
+
+func (s *MySuite) TestGrayFromRGB(c *C) {
+	rgb := NewRGB(image.Rect(0, 0, 3, 2))
+	cols := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {12, 34, 56, 255}, {255, 255, 255, 255}, {0, 0, 0, 255}}
+	for i, col := range cols {
+		rgb.SetRGBA(i%3, i/3, col)
+	}
+	gray := GetGray(rgb)
+	for i, col := range cols {
+		c.Check(gray.GrayAt(i%3, i/3), Equals, color.GrayModel.Convert(col), Commentf("%v", col))
+	}
+	c.Check(GetGray(StdImage{rgb}.GetRGBA()), DeepEquals, gray)
+	c.Check(GetGray(gray), Equals, gray)
+}
+
+func (s *MySuite) TestGrayBytes(c *C) {
+	gray := image.NewGray(image.Rect(0, 0, 4, 3))
+	gray.Pix[5] = 9
+	ib, stride, err := LookupImageBytes(gray)
+	c.Assert(err, IsNil)
+	c.Check(ib, DeepEquals, GrayBytes(gray.Pix))
+	c.Check(stride, Equals, 4)
+	c.Check(GetPixelFormat(ib), Equals, PixelFormatGray)
+	c.Check(ib.AsImage(stride, gray.Rect), DeepEquals, gray)
+
+	ps := GetPixelSequence(gray)
+	c.Check(ps.RowBytes(), Equals, 4)
+
+	dir, err := ioutil.TempDir("", "graytest")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "0"+PixelFormatGray.Ext())
+	c.Assert(SaveImage(path, gray), IsNil)
+	c.Assert(WriteRawInfo(dir, RawFormat{Format: PixelFormatGray, Width: 4, Height: 3}), IsNil)
+	img, err := LoadImage(path)
+	c.Assert(err, IsNil)
+	c.Check(img, DeepEquals, gray)
+}
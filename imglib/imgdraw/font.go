@@ -0,0 +1,130 @@
+package imgdraw
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"unicode"
+)
+
+// The font is a 5x7 bitmap in the style of old character LCDs, covering
+// digits, upper case letters and the punctuation found in timestamps and
+// labels.  Lower case letters are drawn as upper case and anything else
+// missing as '?'.  Each glyph is seven rows of five bits, MSB on the left.
+const (
+	glyphWidth  = 5
+	glyphHeight = 7
+	// Glyphs are separated by a one pixel gap on each axis.
+	glyphAdvance = glyphWidth + 1
+	lineAdvance  = glyphHeight + 1
+)
+
+var glyphs = map[rune][glyphHeight]uint8{
+	' ': {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+	'0': {0x0E, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0E},
+	'1': {0x04, 0x0C, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'2': {0x0E, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1F},
+	'3': {0x1F, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0E},
+	'4': {0x02, 0x06, 0x0A, 0x12, 0x1F, 0x02, 0x02},
+	'5': {0x1F, 0x10, 0x1E, 0x01, 0x01, 0x11, 0x0E},
+	'6': {0x06, 0x08, 0x10, 0x1E, 0x11, 0x11, 0x0E},
+	'7': {0x1F, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0E, 0x11, 0x11, 0x0E, 0x11, 0x11, 0x0E},
+	'9': {0x0E, 0x11, 0x11, 0x0F, 0x01, 0x02, 0x0C},
+	'A': {0x0E, 0x11, 0x11, 0x11, 0x1F, 0x11, 0x11},
+	'B': {0x1E, 0x11, 0x11, 0x1E, 0x11, 0x11, 0x1E},
+	'C': {0x0E, 0x11, 0x10, 0x10, 0x10, 0x11, 0x0E},
+	'D': {0x1C, 0x12, 0x11, 0x11, 0x11, 0x12, 0x1C},
+	'E': {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x1F},
+	'F': {0x1F, 0x10, 0x10, 0x1E, 0x10, 0x10, 0x10},
+	'G': {0x0E, 0x11, 0x10, 0x17, 0x11, 0x11, 0x0F},
+	'H': {0x11, 0x11, 0x11, 0x1F, 0x11, 0x11, 0x11},
+	'I': {0x0E, 0x04, 0x04, 0x04, 0x04, 0x04, 0x0E},
+	'J': {0x07, 0x02, 0x02, 0x02, 0x02, 0x12, 0x0C},
+	'K': {0x11, 0x12, 0x14, 0x18, 0x14, 0x12, 0x11},
+	'L': {0x10, 0x10, 0x10, 0x10, 0x10, 0x10, 0x1F},
+	'M': {0x11, 0x1B, 0x15, 0x15, 0x11, 0x11, 0x11},
+	'N': {0x11, 0x11, 0x19, 0x15, 0x13, 0x11, 0x11},
+	'O': {0x0E, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'P': {0x1E, 0x11, 0x11, 0x1E, 0x10, 0x10, 0x10},
+	'Q': {0x0E, 0x11, 0x11, 0x11, 0x15, 0x12, 0x0D},
+	'R': {0x1E, 0x11, 0x11, 0x1E, 0x14, 0x12, 0x11},
+	'S': {0x0F, 0x10, 0x10, 0x0E, 0x01, 0x01, 0x1E},
+	'T': {0x1F, 0x04, 0x04, 0x04, 0x04, 0x04, 0x04},
+	'U': {0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x0E},
+	'V': {0x11, 0x11, 0x11, 0x11, 0x11, 0x0A, 0x04},
+	'W': {0x11, 0x11, 0x11, 0x15, 0x15, 0x15, 0x0A},
+	'X': {0x11, 0x11, 0x0A, 0x04, 0x0A, 0x11, 0x11},
+	'Y': {0x11, 0x11, 0x11, 0x0A, 0x04, 0x04, 0x04},
+	'Z': {0x1F, 0x01, 0x02, 0x04, 0x08, 0x10, 0x1F},
+	':': {0x00, 0x0C, 0x0C, 0x00, 0x0C, 0x0C, 0x00},
+	'.': {0x00, 0x00, 0x00, 0x00, 0x00, 0x0C, 0x0C},
+	',': {0x00, 0x00, 0x00, 0x00, 0x0C, 0x04, 0x08},
+	'-': {0x00, 0x00, 0x00, 0x1F, 0x00, 0x00, 0x00},
+	'+': {0x00, 0x04, 0x04, 0x1F, 0x04, 0x04, 0x00},
+	'=': {0x00, 0x00, 0x1F, 0x00, 0x1F, 0x00, 0x00},
+	'_': {0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1F},
+	'/': {0x00, 0x01, 0x02, 0x04, 0x08, 0x10, 0x00},
+	'#': {0x0A, 0x0A, 0x1F, 0x0A, 0x1F, 0x0A, 0x0A},
+	'%': {0x18, 0x19, 0x02, 0x04, 0x08, 0x13, 0x03},
+	'(': {0x02, 0x04, 0x08, 0x08, 0x08, 0x04, 0x02},
+	')': {0x08, 0x04, 0x02, 0x02, 0x02, 0x04, 0x08},
+	'?': {0x0E, 0x11, 0x01, 0x02, 0x04, 0x00, 0x04},
+}
+
+func glyph(r rune) [glyphHeight]uint8 {
+	if g, ok := glyphs[r]; ok {
+		return g
+	}
+	if g, ok := glyphs[unicode.ToUpper(r)]; ok {
+		return g
+	}
+	return glyphs['?']
+}
+
+// TextBounds returns the rectangle Text would cover drawing s at p with the
+// given scale.  Lines are separated by '\n'.
+func TextBounds(p image.Point, s string, scale int) image.Rectangle {
+	lines := strings.Split(s, "\n")
+	cols := 0
+	for _, l := range lines {
+		if n := len([]rune(l)); n > cols {
+			cols = n
+		}
+	}
+	if cols == 0 {
+		return image.Rectangle{p, p}
+	}
+	sz := image.Point{cols*glyphAdvance - 1, len(lines)*lineAdvance - 1}
+	return image.Rectangle{p, p.Add(sz.Mul(scale))}
+}
+
+// Text draws s in c with its top-left corner at p.  Each font pixel becomes a
+// scale x scale square.  It returns the rectangle covered, as TextBounds.
+func Text(img image.Image, p image.Point, s string, scale int, c color.Color) image.Rectangle {
+	pt := newPainter(img, c)
+	for li, line := range strings.Split(s, "\n") {
+		for ci, r := range []rune(line) {
+			origin := p.Add(image.Point{ci * glyphAdvance, li * lineAdvance}.Mul(scale))
+			g := glyph(r)
+			for gy, bits := range g {
+				for gx := 0; gx < glyphWidth; gx++ {
+					if bits&(1<<uint(glyphWidth-1-gx)) != 0 {
+						min := origin.Add(image.Point{gx, gy}.Mul(scale))
+						fillClipped(img, pt, image.Rectangle{min, min.Add(image.Point{scale, scale})})
+					}
+				}
+			}
+		}
+	}
+	return TextBounds(p, s, scale)
+}
+
+// Label draws s like Text on a background of bg extending margin pixels
+// beyond the text, so that it's legible over any part of the frame.
+func Label(img image.Image, p image.Point, s string, scale, margin int, fg, bg color.Color) image.Rectangle {
+	r := TextBounds(p, s, scale).Inset(-margin)
+	Box(img, r, bg)
+	Text(img, p, s, scale, fg)
+	return r
+}
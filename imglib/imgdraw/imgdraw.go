@@ -0,0 +1,225 @@
+// imgdraw draws simple annotations - rectangles, lines, crosshairs and text -
+// directly onto images in place.  Unlike image/draw it works on our RGB and
+// YUYV types without converting them, which is what we want for burning motion
+// results and timestamps into frames on their way to disk or the screen.
+//
+// Colors are written, not blended.  In YUYV images two horizontally adjacent
+// pixels share their chroma, so colouring one pixel also changes the hue of
+// its neighbour; for lines one pixel wide that's usually invisible.
+package imgdraw
+
+import (
+	"code.google.com/p/ncabatoff/imglib"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// painter fills rectangles of an image with a single color.  r is always
+// within the image bounds.
+type painter interface {
+	fill(r image.Rectangle)
+}
+
+type rgbPainter struct {
+	img     *imglib.RGB
+	r, g, b uint8
+}
+
+func (p rgbPainter) fill(r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := p.img.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p.img.Pix[i+0], p.img.Pix[i+1], p.img.Pix[i+2] = p.r, p.g, p.b
+			i += 3
+		}
+	}
+}
+
+type rgbaPainter struct {
+	img *image.RGBA
+	c   color.RGBA
+}
+
+func (p rgbaPainter) fill(r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := p.img.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p.img.Pix[i+0], p.img.Pix[i+1], p.img.Pix[i+2], p.img.Pix[i+3] = p.c.R, p.c.G, p.c.B, p.c.A
+			i += 4
+		}
+	}
+}
+
+type yuyvPainter struct {
+	img       *imglib.YUYV
+	y, cb, cr uint8
+}
+
+// fill sets luma for each pixel and chroma for each pair containing one,
+// following the pairing convention of YUYV.At.
+func (p yuyvPainter) fill(r image.Rectangle) {
+	pix := p.img.Pix
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			i := p.img.PixOffset(x, y)
+			pix[i] = p.y
+			if x%2 != 0 {
+				i -= 2
+			}
+			if i >= 0 && i+3 < len(pix) {
+				pix[i+1], pix[i+3] = p.cb, p.cr
+			}
+		}
+	}
+}
+
+type genericPainter struct {
+	img draw.Image
+	c   color.Color
+}
+
+func (p genericPainter) fill(r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p.img.Set(x, y, p.c)
+		}
+	}
+}
+
+// newPainter returns a painter for img, which must be an *imglib.RGB,
+// *imglib.YUYV or a draw.Image.  It panics otherwise.
+func newPainter(img image.Image, c color.Color) painter {
+	switch concrete := img.(type) {
+	case *imglib.RGB:
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		return rgbPainter{concrete, rgba.R, rgba.G, rgba.B}
+	case *image.RGBA:
+		return rgbaPainter{concrete, color.RGBAModel.Convert(c).(color.RGBA)}
+	case *imglib.YUYV:
+		ycc := color.YCbCrModel.Convert(c).(color.YCbCr)
+		return yuyvPainter{concrete, ycc.Y, ycc.Cb, ycc.Cr}
+	case draw.Image:
+		return genericPainter{concrete, c}
+	}
+	panic("imgdraw: can't draw on image of this type")
+}
+
+// fillClipped fills the part of r that lies within img with p.
+func fillClipped(img image.Image, p painter, r image.Rectangle) {
+	if r = r.Intersect(img.Bounds()); !r.Empty() {
+		p.fill(r)
+	}
+}
+
+// Box fills r with c.
+func Box(img image.Image, r image.Rectangle, c color.Color) {
+	fillClipped(img, newPainter(img, c), r)
+}
+
+// Rect draws the outline of r in c, width pixels wide, entirely within r.
+func Rect(img image.Image, r image.Rectangle, width int, c color.Color) {
+	r = r.Canon()
+	p := newPainter(img, c)
+	if r.Dx() <= 2*width || r.Dy() <= 2*width {
+		fillClipped(img, p, r)
+		return
+	}
+	fillClipped(img, p, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width))
+	fillClipped(img, p, image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y))
+	fillClipped(img, p, image.Rect(r.Min.X, r.Min.Y+width, r.Min.X+width, r.Max.Y-width))
+	fillClipped(img, p, image.Rect(r.Max.X-width, r.Min.Y+width, r.Max.X, r.Max.Y-width))
+}
+
+// Rects is like Rect for each of rs.
+func Rects(img image.Image, rs []image.Rectangle, width int, c color.Color) {
+	for _, r := range rs {
+		Rect(img, r, width, c)
+	}
+}
+
+// Line draws a one pixel wide line from p0 to p1 inclusive in c, using
+// Bresenham's algorithm.
+func Line(img image.Image, p0, p1 image.Point, c color.Color) {
+	p := newPainter(img, c)
+	dx, dy := abs(p1.X-p0.X), -abs(p1.Y-p0.Y)
+	sx, sy := sign(p1.X-p0.X), sign(p1.Y-p0.Y)
+	err := dx + dy
+	for pt := p0; ; {
+		fillClipped(img, p, image.Rectangle{pt, pt.Add(image.Point{1, 1})})
+		if pt == p1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			pt.X += sx
+		}
+		if e2 <= dx {
+			err += dx
+			pt.Y += sy
+		}
+	}
+}
+
+// Crosshair draws horizontal and vertical lines through p, each extending
+// size pixels either side of it.
+func Crosshair(img image.Image, p image.Point, size int, c color.Color) {
+	pt := newPainter(img, c)
+	fillClipped(img, pt, image.Rect(p.X-size, p.Y, p.X+size+1, p.Y+1))
+	fillClipped(img, pt, image.Rect(p.X, p.Y-size, p.X+1, p.Y+size+1))
+}
+
+// IsolateRects returns a new RGBA with the bounds of img that is black except
+// for the parts of img within rs, each surrounded by a one pixel white border.
+// This is how the motion demos show what the tracker found.
+func IsolateRects(img image.Image, rs []image.Rectangle) *image.RGBA {
+	out := image.NewRGBA(img.Bounds())
+	for _, r := range rs {
+		Rect(out, r.Inset(-1), 1, color.White)
+		draw.Draw(out, r, img, r.Min, draw.Src)
+	}
+	return out
+}
+
+// SideBySide returns a new RGBA with imgs laid out left to right, top
+// aligned, with gap black pixels between them.  Its bounds start at (0,0).
+func SideBySide(gap int, imgs ...image.Image) *image.RGBA {
+	sz := image.Point{}
+	for i, img := range imgs {
+		if i > 0 {
+			sz.X += gap
+		}
+		isz := img.Bounds().Size()
+		sz.X += isz.X
+		if isz.Y > sz.Y {
+			sz.Y = isz.Y
+		}
+	}
+	out := image.NewRGBA(image.Rectangle{Max: sz})
+	Box(out, out.Rect, color.Black)
+	x := 0
+	for _, img := range imgs {
+		r := img.Bounds()
+		draw.Draw(out, r.Sub(r.Min).Add(image.Point{x, 0}), img, r.Min, draw.Src)
+		x += r.Dx() + gap
+	}
+	return out
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
+
+func sign(i int) int {
+	switch {
+	case i < 0:
+		return -1
+	case i > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package imgdraw
+
+import . "gopkg.in/check.v1"
+import "code.google.com/p/ncabatoff/imglib"
+import "image"
+import "image/color"
+import "testing"
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+var red = color.RGBA{0xFF, 0, 0, 0xFF}
+
+// setPixels returns the points in r at which img isn't black.
+func setPixels(img image.Image) map[image.Point]bool {
+	ret := make(map[image.Point]bool)
+	r := img.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if cr, cg, cb, _ := img.At(x, y).RGBA(); cr|cg|cb != 0 {
+				ret[image.Point{x, y}] = true
+			}
+		}
+	}
+	return ret
+}
+
+func pts(ps ...int) map[image.Point]bool {
+	ret := make(map[image.Point]bool)
+	for i := 0; i < len(ps); i += 2 {
+		ret[image.Point{ps[i], ps[i+1]}] = true
+	}
+	return ret
+}
+
+func (s *MySuite) TestRect(c *C) {
+	rgb := imglib.NewRGB(image.Rect(0, 0, 6, 5))
+	Rect(rgb, image.Rect(1, 1, 4, 4), 1, red)
+	c.Check(setPixels(rgb), DeepEquals, pts(1, 1, 2, 1, 3, 1, 1, 2, 3, 2, 1, 3, 2, 3, 3, 3))
+	c.Check(rgb.At(1, 1), DeepEquals, red)
+
+	// Rects too small to have an inside are filled, and everything is clipped.
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	Rect(rgba, image.Rect(-2, 2, 6, 4), 1, red)
+	c.Check(len(setPixels(rgba)), Equals, 8)
+}
+
+func (s *MySuite) TestBoxAllTypes(c *C) {
+	r := image.Rect(2, 1, 4, 3)
+	for _, img := range []image.Image{
+		imglib.NewRGB(image.Rect(0, 0, 6, 4)),
+		image.NewRGBA(image.Rect(0, 0, 6, 4)),
+		imglib.NewYUYV(image.Rect(0, 0, 6, 4)),
+		image.NewNRGBA(image.Rect(0, 0, 6, 4)),
+	} {
+		// A zeroed YUYV isn't black.
+		Box(img, img.Bounds(), color.Black)
+		Box(img, r, color.White)
+		c.Check(setPixels(img), DeepEquals, pts(2, 1, 3, 1, 2, 2, 3, 2), Commentf("%T", img))
+	}
+
+	yuyv := imglib.NewYUYV(image.Rect(0, 0, 4, 2))
+	Box(yuyv, yuyv.Rect, red)
+	want := color.YCbCrModel.Convert(red)
+	c.Check(yuyv.At(3, 1), DeepEquals, want)
+
+	c.Check(func() { Box(image.NewUniform(red), r, red) }, PanicMatches, "imgdraw: .*")
+}
+
+func (s *MySuite) TestLine(c *C) {
+	rgb := imglib.NewRGB(image.Rect(0, 0, 5, 5))
+	Line(rgb, image.Point{0, 0}, image.Point{4, 4}, red)
+	c.Check(setPixels(rgb), DeepEquals, pts(0, 0, 1, 1, 2, 2, 3, 3, 4, 4))
+
+	rgb = imglib.NewRGB(image.Rect(0, 0, 5, 5))
+	Line(rgb, image.Point{4, 1}, image.Point{0, 2}, red)
+	c.Check(len(setPixels(rgb)), Equals, 5)
+	c.Check(rgb.At(4, 1), DeepEquals, red)
+	c.Check(rgb.At(0, 2), DeepEquals, red)
+
+	// Lines running off the image are clipped.
+	rgb = imglib.NewRGB(image.Rect(0, 0, 5, 5))
+	Line(rgb, image.Point{-3, 2}, image.Point{8, 2}, red)
+	c.Check(len(setPixels(rgb)), Equals, 5)
+}
+
+func (s *MySuite) TestCrosshair(c *C) {
+	rgb := imglib.NewRGB(image.Rect(0, 0, 5, 5))
+	Crosshair(rgb, image.Point{1, 2}, 2, red)
+	c.Check(setPixels(rgb), DeepEquals, pts(0, 2, 1, 2, 2, 2, 3, 2, 1, 0, 1, 1, 1, 3, 1, 4))
+}
+
+func (s *MySuite) TestText(c *C) {
+	c.Check(TextBounds(image.Point{1, 2}, "AB\nC", 2), DeepEquals, image.Rect(1, 2, 1+2*11, 2+2*15))
+	c.Check(TextBounds(image.Point{1, 2}, "", 2).Empty(), Equals, true)
+
+	rgb := imglib.NewRGB(image.Rect(0, 0, 12, 8))
+	r := Text(rgb, image.Point{0, 0}, "1-", 1, red)
+	c.Check(r, DeepEquals, image.Rect(0, 0, 11, 7))
+	set := setPixels(rgb)
+	// '1' has 10 pixels set and '-' has 5.
+	c.Check(len(set), Equals, 15)
+	c.Check(set[image.Point{2, 0}], Equals, true)
+	c.Check(set[image.Point{6, 3}], Equals, true)
+
+	// Lower case is drawn as upper case.
+	lower, upper := imglib.NewRGB(image.Rect(0, 0, 6, 8)), imglib.NewRGB(image.Rect(0, 0, 6, 8))
+	Text(lower, image.ZP, "q", 1, red)
+	Text(upper, image.ZP, "Q", 1, red)
+	c.Check(lower, DeepEquals, upper)
+
+	yuyv := imglib.NewYUYV(image.Rect(0, 0, 20, 12))
+	r = Label(yuyv, image.Point{2, 2}, "7", 1, 1, color.White, color.Gray{0x40})
+	c.Check(r, DeepEquals, image.Rect(1, 1, 8, 10))
+	c.Check(yuyv.At(1, 1).(color.YCbCr).Y, Equals, uint8(0x40))
+	c.Check(yuyv.At(2, 2).(color.YCbCr).Y, Equals, uint8(0xFF))
+}
+
+func (s *MySuite) TestIsolateRects(c *C) {
+	rgb := imglib.NewRGB(image.Rect(0, 0, 8, 8))
+	Box(rgb, rgb.Rect, red)
+	out := IsolateRects(rgb, []image.Rectangle{image.Rect(2, 2, 4, 4)})
+	c.Check(out.At(2, 2), DeepEquals, red)
+	c.Check(out.At(1, 1), DeepEquals, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF})
+	c.Check(out.At(0, 0), DeepEquals, color.RGBA{})
+	c.Check(len(setPixels(out)), Equals, 16)
+}
+
+func (s *MySuite) TestSideBySide(c *C) {
+	a := imglib.NewRGB(image.Rect(2, 2, 5, 4))
+	Box(a, a.Rect, red)
+	b := image.NewGray(image.Rect(0, 0, 2, 3))
+	Box(b, b.Rect, color.White)
+	out := SideBySide(1, a, b)
+	c.Check(out.Rect, Equals, image.Rect(0, 0, 6, 3))
+	c.Check(out.At(0, 0), DeepEquals, red)
+	c.Check(out.At(0, 2), DeepEquals, color.RGBA{0, 0, 0, 0xFF})
+	c.Check(out.At(3, 0), DeepEquals, color.RGBA{0, 0, 0, 0xFF})
+	c.Check(out.At(5, 2), DeepEquals, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF})
+}
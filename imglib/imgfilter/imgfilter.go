@@ -0,0 +1,319 @@
+// imgfilter provides noise-reduction filters - box and Gaussian blur, median,
+// and morphological erosion, dilation, opening and closing - which modify
+// images in place.  Like imgdraw it works directly on image.Gray, image.RGBA
+// and our RGB and YUYV types, without converting them.
+//
+// Each channel is filtered on its own.  In YUYV images luma is filtered at full
+// resolution and chroma at the half horizontal resolution it's stored at; RGBA
+// alpha is left alone.  Pixels beyond the edges of an image are taken to be
+// copies of the nearest edge pixel.
+//
+// Erosion and dilation are the min and max of each pixel's neighbourhood, so on
+// a binary mask such as Tracker.GetMask returns (0 or 255) they're the usual
+// binary operations.  Opening a mask removes specks smaller than the
+// structuring element; closing fills small holes.
+package imgfilter
+
+import (
+	"code.google.com/p/ncabatoff/imglib"
+	"image"
+	"math"
+	"sort"
+)
+
+// channel locates the samples of one channel within a row of pixels: the
+// first is off bytes from the row start, and each is step bytes after the
+// previous.  n is the number of samples per row.
+type channel struct {
+	off, step, n int
+}
+
+// planes describes where the channels of an image are found in its Pix.
+type planes struct {
+	pix    []byte
+	start  int
+	stride int
+	rows   int
+	chans  []channel
+}
+
+func getPlanes(img image.Image) planes {
+	r := img.Bounds()
+	w := r.Dx()
+	switch concrete := img.(type) {
+	case *image.Gray:
+		return planes{concrete.Pix, concrete.PixOffset(r.Min.X, r.Min.Y), concrete.Stride, r.Dy(),
+			[]channel{{0, 1, w}}}
+	case *imglib.RGB:
+		return planes{concrete.Pix, concrete.PixOffset(r.Min.X, r.Min.Y), concrete.Stride, r.Dy(),
+			[]channel{{0, 3, w}, {1, 3, w}, {2, 3, w}}}
+	case *image.RGBA:
+		return planes{concrete.Pix, concrete.PixOffset(r.Min.X, r.Min.Y), concrete.Stride, r.Dy(),
+			[]channel{{0, 4, w}, {1, 4, w}, {2, 4, w}}}
+	case *imglib.YUYV:
+		// Chroma is filtered for every pair with its first pixel in the image,
+		// which when r.Min.X is odd includes the pair it shares with the pixel
+		// to its left.
+		pmin := r.Min.X &^ 1
+		luma := 2 * (r.Min.X - pmin)
+		return planes{concrete.Pix, concrete.PixOffset(pmin, r.Min.Y), concrete.Stride, r.Dy(),
+			[]channel{{luma, 2, w}, {1, 4, (r.Max.X - pmin) / 2}, {3, 4, (r.Max.X - pmin) / 2}}}
+	}
+	panic("imgfilter: can't filter image of this type")
+}
+
+// Filter holds scratch buffers which are reused from one call to the next, so
+// that filtering a stream of frames doesn't allocate.  The zero value is ready
+// to use.  A Filter must not be used by more than one goroutine at a time.
+type Filter struct {
+	plane, tmp      []byte
+	lineIn, lineOut []byte
+	window          []byte
+	kernel          []int
+	kernelSigma     float64
+}
+
+func grow(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
+}
+
+// lineOp computes dst from src, which are the same length.
+type lineOp func(dst, src []byte)
+
+// eachPlane copies each channel of img into f.plane in turn, calls op with its
+// dimensions, and copies the result back.
+func (f *Filter) eachPlane(img image.Image, op func(w, h int)) {
+	p := getPlanes(img)
+	for _, ch := range p.chans {
+		if ch.n == 0 || p.rows == 0 {
+			continue
+		}
+		f.plane = grow(f.plane, ch.n*p.rows)
+		for y := 0; y < p.rows; y++ {
+			i := p.start + y*p.stride + ch.off
+			row := f.plane[y*ch.n : (y+1)*ch.n]
+			for x := range row {
+				row[x] = p.pix[i]
+				i += ch.step
+			}
+		}
+		op(ch.n, p.rows)
+		for y := 0; y < p.rows; y++ {
+			i := p.start + y*p.stride + ch.off
+			for _, v := range f.plane[y*ch.n : (y+1)*ch.n] {
+				p.pix[i] = v
+				i += ch.step
+			}
+		}
+	}
+}
+
+// separable applies rowOp to each row of f.plane and then colOp to each column.
+func (f *Filter) separable(img image.Image, rowOp, colOp lineOp) {
+	f.eachPlane(img, func(w, h int) {
+		f.tmp = grow(f.tmp, w*h)
+		for y := 0; y < h; y++ {
+			rowOp(f.tmp[y*w:(y+1)*w], f.plane[y*w:(y+1)*w])
+		}
+		f.lineIn, f.lineOut = grow(f.lineIn, h), grow(f.lineOut, h)
+		for x := 0; x < w; x++ {
+			for y := range f.lineIn {
+				f.lineIn[y] = f.tmp[y*w+x]
+			}
+			colOp(f.lineOut, f.lineIn)
+			for y, v := range f.lineOut {
+				f.plane[y*w+x] = v
+			}
+		}
+	})
+}
+
+// clamp returns i limited to [0,n).
+func clamp(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+// BoxBlur replaces each sample of img by the mean of the (2*radius+1)^2 square
+// centred on it.
+func (f *Filter) BoxBlur(img image.Image, radius int) {
+	if radius < 1 {
+		return
+	}
+	op := func(dst, src []byte) {
+		n, size := len(src), 2*radius+1
+		sum := 0
+		for i := -radius; i <= radius; i++ {
+			sum += int(src[clamp(i, n)])
+		}
+		for i := range dst {
+			dst[i] = uint8((sum + size/2) / size)
+			sum += int(src[clamp(i+radius+1, n)]) - int(src[clamp(i-radius, n)])
+		}
+	}
+	f.separable(img, op, op)
+}
+
+// gaussianShift is the number of fraction bits in the Gaussian kernel weights.
+const gaussianShift = 16
+
+// gaussianKernel returns the weights for offsets -r..r from the centre, where
+// r is about 3*sigma.  They sum to exactly 1<<gaussianShift.
+func (f *Filter) gaussianKernel(sigma float64) []int {
+	if f.kernelSigma == sigma && f.kernel != nil {
+		return f.kernel
+	}
+	r := int(math.Ceil(3 * sigma))
+	fk := make([]float64, 2*r+1)
+	var total float64
+	for i := range fk {
+		d := float64(i - r)
+		fk[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		total += fk[i]
+	}
+	k := make([]int, len(fk))
+	sum := 0
+	for i := range fk {
+		k[i] = int(fk[i]/total*(1<<gaussianShift) + 0.5)
+		sum += k[i]
+	}
+	// Put any rounding error in the centre so that flat areas stay flat.
+	k[r] += 1<<gaussianShift - sum
+	f.kernel, f.kernelSigma = k, sigma
+	return k
+}
+
+// GaussianBlur convolves img with a Gaussian of standard deviation sigma, in
+// pixels.  A sigma of about 1 is enough to suppress most sensor noise.
+func (f *Filter) GaussianBlur(img image.Image, sigma float64) {
+	if sigma <= 0 {
+		return
+	}
+	k := f.gaussianKernel(sigma)
+	r := len(k) / 2
+	op := func(dst, src []byte) {
+		n := len(src)
+		for i := range dst {
+			sum := 1 << (gaussianShift - 1)
+			if i >= r && i+r < n {
+				for j, w := range k {
+					sum += w * int(src[i-r+j])
+				}
+			} else {
+				for j, w := range k {
+					sum += w * int(src[clamp(i-r+j, n)])
+				}
+			}
+			dst[i] = uint8(sum >> gaussianShift)
+		}
+	}
+	f.separable(img, op, op)
+}
+
+// Median replaces each sample of img by the median of the size x size square
+// centred on it.  Unlike blurring this removes isolated noisy pixels without
+// softening edges.  size must be odd; 3 and 5 are typical.
+func (f *Filter) Median(img image.Image, size int) {
+	if size%2 == 0 || size < 1 {
+		panic("imgfilter: median size must be odd")
+	}
+	if size == 1 {
+		return
+	}
+	r := size / 2
+	f.window = grow(f.window, size*size)
+	f.eachPlane(img, func(w, h int) {
+		f.tmp = grow(f.tmp, w*h)
+		copy(f.tmp, f.plane[:w*h])
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				win := f.window[:0]
+				for dy := -r; dy <= r; dy++ {
+					row := f.tmp[clamp(y+dy, h)*w:]
+					for dx := -r; dx <= r; dx++ {
+						win = append(win, row[clamp(x+dx, w)])
+					}
+				}
+				f.plane[y*w+x] = median(win)
+			}
+		}
+	})
+}
+
+type byteSlice []byte
+
+func (b byteSlice) Len() int           { return len(b) }
+func (b byteSlice) Less(i, j int) bool { return b[i] < b[j] }
+func (b byteSlice) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// median returns the median of the odd-length slice b, reordering it.
+func median(b []byte) byte {
+	if len(b) <= 25 {
+		// Insertion sort is quicker than sort.Sort for the usual window sizes.
+		for i := 1; i < len(b); i++ {
+			for j := i; j > 0 && b[j] < b[j-1]; j-- {
+				b[j], b[j-1] = b[j-1], b[j]
+			}
+		}
+	} else {
+		sort.Sort(byteSlice(b))
+	}
+	return b[len(b)/2]
+}
+
+// extremeOp returns a lineOp setting each sample to the min (or max) of those
+// within radius of it.
+func extremeOp(radius int, max bool) lineOp {
+	return func(dst, src []byte) {
+		n := len(src)
+		for i := range dst {
+			v := src[i]
+			for j := clamp(i-radius, n); j <= clamp(i+radius, n); j++ {
+				if max && src[j] > v || !max && src[j] < v {
+					v = src[j]
+				}
+			}
+			dst[i] = v
+		}
+	}
+}
+
+// Erode replaces each sample of img by the minimum of the (2*radius+1)^2
+// square centred on it.
+func (f *Filter) Erode(img image.Image, radius int) {
+	if radius > 0 {
+		op := extremeOp(radius, false)
+		f.separable(img, op, op)
+	}
+}
+
+// Dilate replaces each sample of img by the maximum of the (2*radius+1)^2
+// square centred on it.
+func (f *Filter) Dilate(img image.Image, radius int) {
+	if radius > 0 {
+		op := extremeOp(radius, true)
+		f.separable(img, op, op)
+	}
+}
+
+// Open erodes and then dilates img, removing bright features which don't
+// contain a (2*radius+1)^2 square.
+func (f *Filter) Open(img image.Image, radius int) {
+	f.Erode(img, radius)
+	f.Dilate(img, radius)
+}
+
+// Close dilates and then erodes img, filling dark gaps which don't contain a
+// (2*radius+1)^2 square.
+func (f *Filter) Close(img image.Image, radius int) {
+	f.Dilate(img, radius)
+	f.Erode(img, radius)
+}
@@ -0,0 +1,130 @@
+package imgfilter
+
+import . "gopkg.in/check.v1"
+import "code.google.com/p/ncabatoff/imglib"
+import "image"
+import "image/color"
+import "testing"
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+func grayOf(w int, pix ...byte) *image.Gray {
+	g := image.NewGray(image.Rect(0, 0, w, len(pix)/w))
+	copy(g.Pix, pix)
+	return g
+}
+
+func (s *MySuite) TestBoxBlur(c *C) {
+	var f Filter
+	g := grayOf(3,
+		0, 0, 0,
+		0, 90, 0,
+		0, 0, 0)
+	f.BoxBlur(g, 1)
+	c.Check(g.Pix, DeepEquals, []byte{10, 10, 10, 10, 10, 10, 10, 10, 10})
+
+	// Flat images stay flat, whatever the type.
+	rgb := imglib.NewRGB(image.Rect(0, 0, 5, 4))
+	for i := range rgb.Pix {
+		rgb.Pix[i] = 77
+	}
+	f.BoxBlur(rgb, 2)
+	f.GaussianBlur(rgb, 1.5)
+	for _, v := range rgb.Pix {
+		c.Assert(v, Equals, uint8(77))
+	}
+}
+
+func (s *MySuite) TestGaussianBlur(c *C) {
+	var f Filter
+	g := image.NewGray(image.Rect(0, 0, 9, 9))
+	g.SetGray(4, 4, color.Gray{255})
+	f.GaussianBlur(g, 1)
+	centre, edge, corner := g.GrayAt(4, 4).Y, g.GrayAt(5, 4).Y, g.GrayAt(5, 5).Y
+	c.Check(centre > edge && edge > corner && corner > 0, Equals, true, Commentf("%d %d %d", centre, edge, corner))
+	c.Check(g.GrayAt(4, 3), Equals, g.GrayAt(3, 4))
+	c.Check(g.GrayAt(0, 0).Y, Equals, uint8(0))
+
+	// Kernels are reused for the same sigma.
+	k := f.gaussianKernel(1)
+	c.Check(&f.gaussianKernel(1)[0], Equals, &k[0])
+	sum := 0
+	for _, w := range f.gaussianKernel(0.7) {
+		sum += w
+	}
+	c.Check(sum, Equals, 1<<gaussianShift)
+}
+
+func (s *MySuite) TestMedian(c *C) {
+	var f Filter
+	g := grayOf(4,
+		10, 10, 10, 10,
+		10, 255, 10, 10,
+		10, 10, 10, 200,
+		10, 10, 200, 200)
+	f.Median(g, 3)
+	c.Check(g.Pix, DeepEquals, []byte{
+		10, 10, 10, 10,
+		10, 10, 10, 10,
+		10, 10, 10, 200,
+		10, 10, 200, 200})
+
+	c.Check(func() { f.Median(g, 4) }, PanicMatches, "imgfilter: .*")
+	c.Check(func() { f.Median(image.NewNRGBA(g.Rect), 3) }, PanicMatches, "imgfilter: .*")
+}
+
+func (s *MySuite) TestMorphology(c *C) {
+	var f Filter
+	speck := grayOf(6,
+		0, 0, 0, 0, 0, 0,
+		0, 255, 0, 0, 0, 0,
+		0, 0, 0, 255, 255, 255,
+		0, 0, 0, 255, 255, 255,
+		0, 0, 0, 255, 255, 255)
+	f.Open(speck, 1)
+	c.Check(speck.Pix, DeepEquals, []byte{
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0,
+		0, 0, 0, 255, 255, 255,
+		0, 0, 0, 255, 255, 255,
+		0, 0, 0, 255, 255, 255})
+
+	hole := grayOf(5,
+		255, 255, 255, 255, 255,
+		255, 255, 255, 255, 255,
+		255, 255, 0, 255, 255,
+		255, 255, 255, 255, 255)
+	f.Close(hole, 1)
+	for _, v := range hole.Pix {
+		c.Assert(v, Equals, uint8(255))
+	}
+
+	g := grayOf(4, 0, 0, 9, 0)
+	f.Dilate(g, 1)
+	c.Check(g.Pix, DeepEquals, []byte{0, 9, 9, 9})
+	f.Erode(g, 1)
+	c.Check(g.Pix, DeepEquals, []byte{0, 0, 9, 9})
+}
+
+func (s *MySuite) TestChannels(c *C) {
+	var f Filter
+	// YUYV chroma is filtered separately from luma, at half resolution.
+	yuyv := imglib.NewYUYV(image.Rect(0, 0, 4, 1))
+	copy(yuyv.Pix, []byte{0, 100, 0, 200, 90, 120, 0, 220})
+	f.Dilate(yuyv, 1)
+	c.Check(yuyv.Pix, DeepEquals, []byte{0, 120, 90, 220, 90, 120, 90, 220})
+
+	// RGBA alpha is left alone, and subimages don't touch pixels outside them.
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	rgba.SetRGBA(1, 0, color.RGBA{50, 0, 0, 1})
+	f.Dilate(rgba.SubImage(image.Rect(1, 0, 3, 1)), 1)
+	c.Check(rgba.RGBAAt(0, 0), Equals, color.RGBA{})
+	c.Check(rgba.RGBAAt(1, 0), Equals, color.RGBA{50, 0, 0, 1})
+	c.Check(rgba.RGBAAt(2, 0), Equals, color.RGBA{50, 0, 0, 0})
+	c.Check(rgba.RGBAAt(3, 0), Equals, color.RGBA{})
+}
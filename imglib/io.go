@@ -2,35 +2,40 @@ package imglib
 
 import "path/filepath"
 import "image"
+import "io"
 import "os"
 import "fmt"
 
+// LoadPixelSequence is like LoadImage, only images not already in a supported
+// layout (e.g. PNGs, JPEGs and GIFs) are converted as by NewPixelSequence.
 func LoadPixelSequence(path string) (PixelSequence, error) {
-	switch(filepath.Ext(path)) {
-	case ".yuv":
-		if yuyv, err := NewYUYVFromFile(path); err != nil {
-			return PixelSequence{}, err
-		} else {
-			return GetPixelSequence(yuyv), nil
-		}
-	case ".rgb":
-		if rgb, err := NewRGBFromFile(path); err != nil {
-			return PixelSequence{}, err
-		} else {
-			return GetPixelSequence(rgb), nil
-		}
+	if img, err := LoadImage(path); err != nil {
+		return PixelSequence{}, err
+	} else {
+		return NewPixelSequence(img)
 	}
-	return PixelSequence{}, fmt.Errorf("can't load file '%s', unknown format", path)
 }
 
-// Read and possibly convert or decode the input file
+// Read and possibly convert or decode the input file.  Headerless files are
+// recognized by the extensions given by PixelFormat.Ext; their dimensions come
+// from the RawInfoFile in their directory, or are guessed from their size if
+// there isn't one.  Their content isn't examined, since they have no header to
+// check and stepping through a directory of frames should cost no more than
+// reading each one.  Otherwise frame containers and y4m streams are recognized
+// by their content whatever they're called, and their first frame is returned.
+// Anything else is left to image.Decode, which sniffs the content for the
+// formats registered with it.
 func LoadImage(path string) (image.Image, error) {
-	if filepath.Ext(path) == ".yuv" {
-		if yuyv, err := NewYUYVFromFile(path); err != nil {
-			return nil, err
-		} else {
-			return yuyv, nil
-		}
+	if pf := PixelFormatForExt(filepath.Ext(path)); pf != PixelFormatUnknown {
+		return loadRawFile(path, pf)
+	}
+
+	if magic, err := readMagic(path); err != nil {
+		return nil, err
+	} else if IsFrames(magic) {
+		return LoadFramesImage(path)
+	} else if IsY4M(magic) {
+		return LoadY4MImage(path)
 	}
 
 	if file, err := os.Open(path); err != nil {
@@ -38,57 +43,68 @@ func LoadImage(path string) (image.Image, error) {
 	} else {
 		defer file.Close()
 		img, _, err := image.Decode(file)
-		return img, err
+		if err != nil {
+			return nil, fmt.Errorf("can't load file '%s': %v", path, err)
+		}
+		return img, nil
+	}
+}
+
+// SniffExt examines the content of the file at path and returns the
+// conventional extension for its format: FramesExt, Y4MExt, or "." followed
+// by the name of a format registered with the image package, e.g. ".png".  It
+// returns "" if the format isn't recognized, which includes headerless files.
+func SniffExt(path string) (string, error) {
+	if magic, err := readMagic(path); err != nil {
+		return "", err
+	} else if IsFrames(magic) {
+		return FramesExt, nil
+	} else if IsY4M(magic) {
+		return Y4MExt, nil
+	}
+	if file, err := os.Open(path); err != nil {
+		return "", err
+	} else {
+		defer file.Close()
+		if _, format, err := image.DecodeConfig(file); err == nil {
+			return "." + format, nil
+		}
 	}
+	return "", nil
 }
 
-func guessRect(numpix int) *image.Rectangle {
-	var r image.Rectangle
-	switch numpix {
-	case 1280 * 720:
-		r.Max = image.Point{1280, 720}
-	case 640 * 480:
-		r.Max = image.Point{640, 480}
-	case 320 * 240:
-		r.Max = image.Point{320, 240}
-	default:
-		return nil
+// readMagic returns the first few bytes of the file at path, or fewer if it's
+// shorter than that.
+func readMagic(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	magic := make([]byte, 16)
+	n, err := io.ReadFull(file, magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
 	}
-	return &r
+	return magic[:n], err
 }
 
+// NewYUYVFromFile reads the headerless YUYV file at path, whose dimensions are
+// found as described for LoadImage.
 func NewYUYVFromFile(path string) (*YUYV, error) {
-	if fi, err := os.Stat(path); err != nil {
+	if img, err := loadRawFile(path, PixelFormatYUYV); err != nil {
 		return nil, err
 	} else {
-		bpp := YuyvBytes{}.GetBytesPerPixel()
-		if r := guessRect(int(fi.Size())/bpp); r == nil {
-			return nil, fmt.Errorf("unknown dims, filesize=%d", fi.Size())
-		} else {
-			yuyv := NewYUYV(*r)
-			if err := yuyv.LoadRaw(path); err != nil {
-				return nil, err
-			} else {
-				return yuyv, nil
-			}
-		}
+		return img.(*YUYV), nil
 	}
 }
 
+// NewRGBFromFile reads the headerless RGB file at path, whose dimensions are
+// found as described for LoadImage.
 func NewRGBFromFile(path string) (*RGB, error) {
-	if fi, err := os.Stat(path); err != nil {
+	if img, err := loadRawFile(path, PixelFormatRGB); err != nil {
 		return nil, err
 	} else {
-		bpp := RgbBytes{}.GetBytesPerPixel()
-		if r := guessRect(int(fi.Size())/bpp); r == nil {
-			return nil, fmt.Errorf("unknown dims, filesize=%d", fi.Size())
-		} else {
-			rgb := NewRGB(*r)
-			if err := rgb.LoadRaw(path); err != nil {
-				return nil, err
-			} else {
-				return rgb, nil
-			}
-		}
+		return img.(*RGB), nil
 	}
 }
@@ -0,0 +1,230 @@
+package imglib
+
+import (
+	"fmt"
+	"image"
+)
+
+// Orientation describes one of the eight ways to map a rectangular image onto
+// itself using only quarter-turn rotations and mirroring.  Cameras are often
+// mounted sideways or upside down, and this is how we correct for it.
+type Orientation int
+
+const (
+	OrientNone       Orientation = iota
+	OrientRotate90               // rotate 90 degrees clockwise
+	OrientRotate180              // rotate 180 degrees
+	OrientRotate270              // rotate 90 degrees counter-clockwise
+	OrientFlipH                  // mirror left-right
+	OrientFlipV                  // mirror top-bottom
+	OrientTranspose              // mirror about the top-left to bottom-right diagonal
+	OrientTransverse             // mirror about the top-right to bottom-left diagonal
+)
+
+var orientNames = []string{"none", "rot90", "rot180", "rot270", "fliph", "flipv", "transpose", "transverse"}
+
+func (o Orientation) String() string {
+	if o < 0 || int(o) >= len(orientNames) {
+		return fmt.Sprintf("Orientation(%d)", int(o))
+	}
+	return orientNames[o]
+}
+
+// ParseOrientation is the inverse of Orientation.String.
+func ParseOrientation(s string) (Orientation, error) {
+	for i, n := range orientNames {
+		if n == s {
+			return Orientation(i), nil
+		}
+	}
+	return OrientNone, fmt.Errorf("unknown orientation '%s'", s)
+}
+
+// SwapsAxes returns true if o turns a WxH image into an HxW image.
+func (o Orientation) SwapsAxes() bool {
+	return o == OrientRotate90 || o == OrientRotate270 || o == OrientTranspose || o == OrientTransverse
+}
+
+// Size returns the dimensions of the output of o given input dimensions sz.
+func (o Orientation) Size(sz image.Point) image.Point {
+	if o.SwapsAxes() {
+		return image.Point{sz.Y, sz.X}
+	}
+	return sz
+}
+
+// srcMapping describes where the output pixel (dx,dy) comes from in a
+// w x h input: the source coordinates relative to the input's Min are
+// origin + dx*xdir + dy*ydir.
+func (o Orientation) srcMapping(w, h int) (origin, xdir, ydir image.Point) {
+	switch o {
+	case OrientRotate90:
+		return image.Point{0, h - 1}, image.Point{0, -1}, image.Point{1, 0}
+	case OrientRotate180:
+		return image.Point{w - 1, h - 1}, image.Point{-1, 0}, image.Point{0, -1}
+	case OrientRotate270:
+		return image.Point{w - 1, 0}, image.Point{0, 1}, image.Point{-1, 0}
+	case OrientFlipH:
+		return image.Point{w - 1, 0}, image.Point{-1, 0}, image.Point{0, 1}
+	case OrientFlipV:
+		return image.Point{0, h - 1}, image.Point{1, 0}, image.Point{0, -1}
+	case OrientTranspose:
+		return image.Point{0, 0}, image.Point{0, 1}, image.Point{1, 0}
+	case OrientTransverse:
+		return image.Point{w - 1, h - 1}, image.Point{0, -1}, image.Point{-1, 0}
+	}
+	return image.Point{0, 0}, image.Point{1, 0}, image.Point{0, 1}
+}
+
+// transformPacked copies pixels from a packed format with bpp bytes per pixel
+// into dest, which must be at least as big as o.Size(srect.Size()) and have
+// its Rect.Min at (0,0).  src must start at the pixel srect.Min.
+func transformPacked(dest []byte, dstride int, src []byte, sstride int, srect image.Rectangle, bpp int, o Orientation) {
+	w, h := srect.Dx(), srect.Dy()
+	dsz := o.Size(srect.Size())
+	origin, xdir, ydir := o.srcMapping(w, h)
+	xstep := xdir.X*bpp + xdir.Y*sstride
+	ystep := ydir.X*bpp + ydir.Y*sstride
+	rowstart := origin.X*bpp + origin.Y*sstride
+	for dy := 0; dy < dsz.Y; dy++ {
+		di, si := dy*dstride, rowstart
+		for dx := 0; dx < dsz.X; dx++ {
+			copy(dest[di:di+bpp], src[si:si+bpp])
+			di += bpp
+			si += xstep
+		}
+		rowstart += ystep
+	}
+}
+
+// OrientRGB returns a new RGB containing img transformed by o.
+func OrientRGB(img *RGB, o Orientation) *RGB {
+	sz := o.Size(img.Rect.Size())
+	ret := NewRGB(image.Rect(0, 0, sz.X, sz.Y))
+	if sz.X > 0 && sz.Y > 0 {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y):]
+		transformPacked(ret.Pix, ret.Stride, src, img.Stride, img.Rect, rgbBpp, o)
+	}
+	return ret
+}
+
+// OrientRGBA returns a new image.RGBA containing img transformed by o.
+func OrientRGBA(img *image.RGBA, o Orientation) *image.RGBA {
+	sz := o.Size(img.Rect.Size())
+	ret := image.NewRGBA(image.Rect(0, 0, sz.X, sz.Y))
+	if sz.X > 0 && sz.Y > 0 {
+		src := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y):]
+		transformPacked(ret.Pix, ret.Stride, src, img.Stride, img.Rect, rgbaBpp, o)
+	}
+	return ret
+}
+
+// yuyvChroma returns the Cb and Cr shared by the pixel pair containing (x,y),
+// following the same pairing convention as At().
+func (img *YUYV) yuyvChroma(x, y int) (uint8, uint8) {
+	i := img.PixOffset(x, y)
+	if x%2 == 0 {
+		return img.Pix[i+1], img.Pix[i+3]
+	}
+	return img.Pix[i-1], img.Pix[i+1]
+}
+
+// OrientYUYV returns a new YUYV containing img transformed by o.  Since two
+// horizontally adjacent pixels share their chroma, any transform which changes
+// which pixels are paired (rotations and transposes) averages the chroma of the
+// two source pixels that become an output pair.  Flips of images with even
+// bounds preserve the pairing and are lossless.  If the output has an odd
+// width its last pixel has a pair to itself, keeping its own chroma, and the
+// rows are padded as by NewYUYV.
+func OrientYUYV(img *YUYV, o Orientation) *YUYV {
+	return transformYUYV(img, img.Rect, o)
+}
+
+// transformYUYV does the work for OrientYUYV and CropYUYV: r is the region of
+// img to transform, which must be contained in img.Rect.
+func transformYUYV(img *YUYV, r image.Rectangle, o Orientation) *YUYV {
+	sz := o.Size(r.Size())
+	ret := NewYUYV(image.Rect(0, 0, sz.X, sz.Y))
+	origin, xdir, ydir := o.srcMapping(r.Dx(), r.Dy())
+	origin = origin.Add(r.Min)
+	p := 0
+	for dy := 0; dy < sz.Y; dy++ {
+		s := origin.Add(ydir.Mul(dy))
+		for dx := 0; dx < sz.X; dx += 2 {
+			if dx+1 == sz.X {
+				cb, cr := img.yuyvChroma(s.X, s.Y)
+				ret.Pix[p+0] = img.Pix[img.PixOffset(s.X, s.Y)]
+				ret.Pix[p+1] = cb
+				ret.Pix[p+3] = cr
+				p += 4
+				break
+			}
+			s2 := s.Add(xdir)
+			cb1, cr1 := img.yuyvChroma(s.X, s.Y)
+			cb2, cr2 := img.yuyvChroma(s2.X, s2.Y)
+			ret.Pix[p+0] = img.Pix[img.PixOffset(s.X, s.Y)]
+			ret.Pix[p+1] = uint8((int(cb1) + int(cb2) + 1) / 2)
+			ret.Pix[p+2] = img.Pix[img.PixOffset(s2.X, s2.Y)]
+			ret.Pix[p+3] = uint8((int(cr1) + int(cr2) + 1) / 2)
+			p += 4
+			s = s2.Add(xdir)
+		}
+	}
+	return ret
+}
+
+// Orient returns a new image containing img transformed by o.  *YUYV, *RGB
+// and *image.RGBA are transformed in their native format; anything else is
+// converted to *image.RGBA first.
+func Orient(img image.Image, o Orientation) image.Image {
+	switch concrete := img.(type) {
+	case *YUYV:
+		return OrientYUYV(concrete, o)
+	case *RGB:
+		return OrientRGB(concrete, o)
+	case *image.RGBA:
+		return OrientRGBA(concrete, o)
+	}
+	return OrientRGBA(StdImage{img}.GetRGBA(), o)
+}
+
+// CropRGB returns a new RGB containing a copy of the portion of img within r.
+// Unlike SubImage, the result doesn't share pixels with img and its bounds
+// start at (0,0).
+func CropRGB(img *RGB, r image.Rectangle) *RGB {
+	sub := img.SubImage(r).(*RGB)
+	return OrientRGB(sub, OrientNone)
+}
+
+// CropRGBA returns a new image.RGBA containing a copy of the portion of img
+// within r, with bounds starting at (0,0).
+func CropRGBA(img *image.RGBA, r image.Rectangle) *image.RGBA {
+	sub := img.SubImage(r).(*image.RGBA)
+	return OrientRGBA(sub, OrientNone)
+}
+
+// CropYUYV returns a new YUYV containing a copy of the portion of img within
+// r, with bounds starting at (0,0).  If r starts on an odd column, the output
+// pairs straddle input pairs and chroma is averaged as in OrientYUYV.  If its
+// width is odd, the last pixel of each row is unpaired as described there.
+func CropYUYV(img *YUYV, r image.Rectangle) *YUYV {
+	r = r.Intersect(img.Rect)
+	if r.Empty() {
+		return &YUYV{}
+	}
+	return transformYUYV(img, r, OrientNone)
+}
+
+// Crop returns a copy of the portion of img within r, in the same format as img
+// for the types supported by Orient and as *image.RGBA otherwise.
+func Crop(img image.Image, r image.Rectangle) image.Image {
+	switch concrete := img.(type) {
+	case *YUYV:
+		return CropYUYV(concrete, r)
+	case *RGB:
+		return CropRGB(concrete, r)
+	case *image.RGBA:
+		return CropRGBA(concrete, r)
+	}
+	return CropRGBA(StdImage{img}.GetRGBA(), r.Sub(img.Bounds().Min))
+}
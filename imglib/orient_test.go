@@ -0,0 +1,118 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "image"
+import "image/color"
+
+// orientWithAt is a slow reference implementation of OrientRGB built on At().
+func orientWithAt(img *RGB, o Orientation) *RGB {
+	sz := o.Size(img.Rect.Size())
+	ret := NewRGB(image.Rect(0, 0, sz.X, sz.Y))
+	origin, xdir, ydir := o.srcMapping(img.Rect.Dx(), img.Rect.Dy())
+	for y := 0; y < sz.Y; y++ {
+		for x := 0; x < sz.X; x++ {
+			s := origin.Add(xdir.Mul(x)).Add(ydir.Mul(y)).Add(img.Rect.Min)
+			ret.SetRGBA(x, y, img.At(s.X, s.Y).(color.RGBA))
+		}
+	}
+	return ret
+}
+
+func (s *MySuite) TestOrientRGB(c *C) {
+	rgb := getTestRgbImage(image.Point{3, 2})
+	rot := OrientRGB(rgb, OrientRotate90)
+	c.Assert(rot.Rect, DeepEquals, image.Rect(0, 0, 2, 3))
+	// The bottom-left pixel of the input ends up at the top-left.
+	c.Check(rot.At(0, 0), DeepEquals, rgb.At(0, 1))
+	c.Check(rot.At(1, 0), DeepEquals, rgb.At(0, 0))
+	c.Check(rot.At(0, 2), DeepEquals, rgb.At(2, 1))
+
+	rgb = getTestRgbImage(image.Point{7, 5})
+	for o := OrientNone; o <= OrientTransverse; o++ {
+		c.Check(OrientRGB(rgb, o), DeepEquals, orientWithAt(rgb, o), Commentf("%v", o))
+	}
+}
+
+func (s *MySuite) TestOrientInverses(c *C) {
+	rgb := getTestRgbImage(image.Point{6, 4})
+	r := rgb
+	for i := 0; i < 4; i++ {
+		r = OrientRGB(r, OrientRotate90)
+	}
+	c.Check(r, DeepEquals, rgb)
+	c.Check(OrientRGB(OrientRGB(rgb, OrientRotate90), OrientRotate270), DeepEquals, rgb)
+	c.Check(OrientRGB(OrientRGB(rgb, OrientTransverse), OrientTransverse), DeepEquals, rgb)
+	c.Check(OrientRGB(OrientRGB(rgb, OrientFlipH), OrientFlipV), DeepEquals, OrientRGB(rgb, OrientRotate180))
+}
+
+func (s *MySuite) TestOrientSubImage(c *C) {
+	rgb := getTestRgbImage(image.Point{8, 8})
+	r := image.Rect(1, 2, 6, 5)
+	sub := rgb.SubImage(r).(*RGB)
+	c.Check(OrientRGB(sub, OrientRotate270), DeepEquals, orientWithAt(sub, OrientRotate270))
+	c.Check(CropRGB(rgb, r), DeepEquals, orientWithAt(sub, OrientNone))
+
+	rgba := StdImage{rgb}.GetRGBA()
+	c.Check(CropRGBA(rgba, r), DeepEquals, StdImage{CropRGB(rgb, r)}.GetRGBA())
+}
+
+func (s *MySuite) TestOrientYUYV(c *C) {
+	yuyv := getTestYuyvImage(image.Point{8, 6})
+	// Flips preserve pixel pairing, so these should be lossless.
+	c.Check(OrientYUYV(OrientYUYV(yuyv, OrientFlipH), OrientFlipH), DeepEquals, yuyv)
+	c.Check(OrientYUYV(OrientYUYV(yuyv, OrientRotate180), OrientRotate180), DeepEquals, yuyv)
+	c.Check(OrientYUYV(yuyv, OrientFlipV).At(3, 0), DeepEquals, yuyv.At(3, 5))
+	c.Check(OrientYUYV(yuyv, OrientFlipH).At(0, 1), DeepEquals, yuyv.At(7, 1))
+
+	// Rotation preserves luma exactly; chroma is averaged across new pairs.
+	rot := OrientYUYV(yuyv, OrientRotate90)
+	c.Assert(rot.Rect, DeepEquals, image.Rect(0, 0, 6, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 6; x++ {
+			c.Check(rot.At(x, y).(color.YCbCr).Y, Equals, yuyv.At(y, 5-x).(color.YCbCr).Y)
+		}
+	}
+	cb1, cr1 := yuyv.yuyvChroma(0, 5)
+	cb2, cr2 := yuyv.yuyvChroma(0, 4)
+	c.Check(rot.At(0, 0), DeepEquals, color.YCbCr{yuyv.Pix[yuyv.PixOffset(0, 5)],
+		uint8((int(cb1) + int(cb2) + 1) / 2), uint8((int(cr1) + int(cr2) + 1) / 2)})
+
+	// An odd height becomes an odd width, whose last pixel is unpaired.
+	yuyv = getTestYuyvImage(image.Point{8, 5})
+	for _, o := range []Orientation{OrientRotate90, OrientRotate270, OrientTranspose, OrientTransverse} {
+		rot = OrientYUYV(yuyv, o)
+		c.Assert(rot.Rect, DeepEquals, image.Rect(0, 0, 5, 8), Commentf("%v", o))
+		origin, xdir, ydir := o.srcMapping(8, 5)
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 5; x++ {
+				sp := origin.Add(xdir.Mul(x)).Add(ydir.Mul(y))
+				c.Check(rot.At(x, y).(color.YCbCr).Y, Equals, yuyv.At(sp.X, sp.Y).(color.YCbCr).Y, Commentf("%v (%d,%d)", o, x, y))
+			}
+		}
+	}
+	rot = OrientYUYV(yuyv, OrientRotate90)
+	c.Check(rot.At(4, 0), DeepEquals, yuyv.At(0, 0))
+}
+
+func (s *MySuite) TestCropYUYV(c *C) {
+	yuyv := getTestYuyvImage(image.Point{8, 6})
+	crop := CropYUYV(yuyv, image.Rect(2, 1, 7, 4))
+	// With an odd width the last pixel is unpaired but keeps its chroma.
+	c.Assert(crop.Rect, DeepEquals, image.Rect(0, 0, 5, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			c.Check(crop.At(x, y), DeepEquals, yuyv.At(x+2, y+1))
+		}
+	}
+	c.Check(Crop(yuyv, image.Rect(20, 20, 30, 30)), DeepEquals, &YUYV{})
+}
+
+func (s *MySuite) TestParseOrientation(c *C) {
+	for o := OrientNone; o <= OrientTransverse; o++ {
+		p, err := ParseOrientation(o.String())
+		c.Check(err, IsNil)
+		c.Check(p, Equals, o)
+	}
+	_, err := ParseOrientation("sideways")
+	c.Check(err, NotNil)
+}
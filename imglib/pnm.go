@@ -0,0 +1,194 @@
+package imglib
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// PPM (P6) and PGM (P5) are the binary flavours of the netpbm formats: a tiny
+// text header followed by the packed samples.  PPM samples are laid out just
+// like our RGB type and PGM ones like image.Gray, so reading and writing them
+// costs little more than a copy.  Only 8-bit samples (maxval < 256) are
+// supported.
+
+func init() {
+	image.RegisterFormat("ppm", "P6", DecodePNM, DecodePNMConfig)
+	image.RegisterFormat("pgm", "P5", DecodePNM, DecodePNMConfig)
+}
+
+type pnmHeader struct {
+	magic         string
+	width, height int
+	maxval        int
+}
+
+// readPNMToken returns the next whitespace-delimited token, skipping comments.
+func readPNMToken(br *bufio.Reader) (string, error) {
+	var tok []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(tok) > 0 {
+				return string(tok), nil
+			}
+			return "", err
+		}
+		switch {
+		case b == '#' && len(tok) == 0:
+			if _, err := br.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			if len(tok) > 0 {
+				// The single whitespace byte after maxval has been consumed,
+				// which is what the spec calls for.
+				return string(tok), nil
+			}
+		default:
+			tok = append(tok, b)
+		}
+	}
+}
+
+func readPNMHeader(br *bufio.Reader) (pnmHeader, error) {
+	var h pnmHeader
+	var err error
+	if h.magic, err = readPNMToken(br); err != nil {
+		return h, err
+	}
+	if h.magic != "P5" && h.magic != "P6" {
+		return h, fmt.Errorf("unsupported netpbm type '%s'", h.magic)
+	}
+	for _, p := range []*int{&h.width, &h.height, &h.maxval} {
+		tok, err := readPNMToken(br)
+		if err != nil {
+			return h, fmt.Errorf("error reading netpbm header: %v", err)
+		}
+		if _, err := fmt.Sscanf(tok, "%d", p); err != nil {
+			return h, fmt.Errorf("bad netpbm header value '%s'", tok)
+		}
+	}
+	if h.width <= 0 || h.height <= 0 {
+		return h, fmt.Errorf("bad netpbm dimensions %dx%d", h.width, h.height)
+	}
+	if h.maxval <= 0 || h.maxval > 255 {
+		return h, fmt.Errorf("unsupported netpbm maxval %d", h.maxval)
+	}
+	return h, nil
+}
+
+// DecodePNM reads a binary PPM or PGM image from r, returning an *RGB or an
+// *image.Gray respectively.  Samples are rescaled if maxval isn't 255.
+func DecodePNM(r io.Reader) (image.Image, error) {
+	br := bufio.NewReader(r)
+	h, err := readPNMHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	rect := image.Rect(0, 0, h.width, h.height)
+	var pix []byte
+	var img image.Image
+	if h.magic == "P6" {
+		rgb := NewRGB(rect)
+		pix, img = rgb.Pix, rgb
+	} else {
+		gray := image.NewGray(rect)
+		pix, img = gray.Pix, gray
+	}
+	if _, err := io.ReadFull(br, pix); err != nil {
+		return nil, fmt.Errorf("error reading netpbm samples: %v", err)
+	}
+	if h.maxval != 255 {
+		for i, v := range pix {
+			pix[i] = uint8((int(v)*255 + h.maxval/2) / h.maxval)
+		}
+	}
+	return img, nil
+}
+
+// DecodePNMConfig returns the color model and dimensions of a binary PPM or
+// PGM image without reading the samples.
+func DecodePNMConfig(r io.Reader) (image.Config, error) {
+	h, err := readPNMHeader(bufio.NewReader(r))
+	if err != nil {
+		return image.Config{}, err
+	}
+	cm := color.GrayModel
+	if h.magic == "P6" {
+		cm = color.RGBAModel
+	}
+	return image.Config{ColorModel: cm, Width: h.width, Height: h.height}, nil
+}
+
+// EncodePPM writes img to w as a binary PPM.  RGB images are written
+// directly and YUYV ones are converted a row at a time; anything else goes
+// through StdImage.GetRGBA.
+func EncodePPM(w io.Writer, img image.Image) error {
+	r := img.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P6\n%d %d\n255\n", r.Dx(), r.Dy())
+	row := make([]byte, r.Dx()*rgbBpp)
+	switch concrete := img.(type) {
+	case *RGB:
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := concrete.PixOffset(r.Min.X, y)
+			bw.Write(concrete.Pix[i : i+len(row)])
+		}
+	case *YUYV:
+		crow := make([]color.YCbCr, r.Dx(), r.Dx()+1)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			concrete.GetRow(y, crow)
+			for x, c := range crow {
+				row[x*rgbBpp], row[x*rgbBpp+1], row[x*rgbBpp+2] = color.YCbCrToRGB(c.Y, c.Cb, c.Cr)
+			}
+			bw.Write(row)
+		}
+	default:
+		rgba := StdImage{img}.GetRGBA()
+		for y := 0; y < r.Dy(); y++ {
+			src := rgba.Pix[rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y+y):]
+			for x := 0; x < r.Dx(); x++ {
+				copy(row[x*rgbBpp:x*rgbBpp+rgbBpp], src[x*rgbaBpp:])
+			}
+			bw.Write(row)
+		}
+	}
+	return bw.Flush()
+}
+
+// EncodePGM writes the luma of img to w as a binary PGM.  For YUYV images
+// that's just the Y samples; RGB and RGBA are converted by GetGray, and
+// anything else one pixel at a time using color.GrayModel.
+func EncodePGM(w io.Writer, img image.Image) error {
+	switch img.(type) {
+	case *RGB, *image.RGBA:
+		// Converting the whole image with packed arithmetic is much quicker
+		// than going through At for each pixel.
+		img = GetGray(img)
+	}
+	r := img.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P5\n%d %d\n255\n", r.Dx(), r.Dy())
+	row := make([]byte, r.Dx())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		switch concrete := img.(type) {
+		case *YUYV:
+			i := concrete.PixOffset(r.Min.X, y)
+			for x := range row {
+				row[x] = concrete.Pix[i+x*yuvBpp]
+			}
+		case *image.Gray:
+			i := concrete.PixOffset(r.Min.X, y)
+			copy(row, concrete.Pix[i:i+len(row)])
+		default:
+			for x := range row {
+				row[x] = color.GrayModel.Convert(img.At(r.Min.X+x, y)).(color.Gray).Y
+			}
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
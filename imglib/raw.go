@@ -0,0 +1,311 @@
+package imglib
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RawInfoFile is the name of the file describing the headerless images in a
+// directory.  Each line is a RawFormat as written by its String method; a directory holding
+// both .yuv and .rgb files can have a line for each.
+const RawInfoFile = "rawinfo"
+
+// Ext returns the extension used for headerless files containing pixels in
+// format pf, or "" if pf is unknown.
+func (pf PixelFormat) Ext() string {
+	switch pf {
+	case PixelFormatYUYV:
+		return ".yuv"
+	case PixelFormatRGB:
+		return ".rgb"
+	case PixelFormatRGBA:
+		return ".rgba"
+	case PixelFormatGray:
+		return ".gray"
+	}
+	return ""
+}
+
+// PixelFormatForExt is the inverse of PixelFormat.Ext.
+func PixelFormatForExt(ext string) PixelFormat {
+	for _, pf := range []PixelFormat{PixelFormatYUYV, PixelFormatRGB, PixelFormatRGBA, PixelFormatGray} {
+		if pf.Ext() == ext {
+			return pf
+		}
+	}
+	return PixelFormatUnknown
+}
+
+// ParsePixelFormat is the inverse of PixelFormat.String.
+func ParsePixelFormat(s string) (PixelFormat, error) {
+	for _, pf := range []PixelFormat{PixelFormatYUYV, PixelFormatRGB, PixelFormatRGBA, PixelFormatGray} {
+		if pf.String() == s {
+			return pf, nil
+		}
+	}
+	return PixelFormatUnknown, fmt.Errorf("unknown pixel format '%s'", s)
+}
+
+// RawFormat describes headerless pixel data.  A zero Stride means the rows
+// are packed.
+type RawFormat struct {
+	Format        PixelFormat
+	Width, Height int
+	Stride        int
+}
+
+// GetStride returns the number of bytes between the start of consecutive rows.
+func (rf RawFormat) GetStride() int {
+	if rf.Stride != 0 {
+		return rf.Stride
+	}
+	return rf.Format.RowBytes(rf.Width)
+}
+
+// FrameSize returns the number of bytes in an image described by rf.
+func (rf RawFormat) FrameSize() int {
+	return rf.GetStride() * rf.Height
+}
+
+// String returns rf in the form parsed by ParseRawFormat, e.g. "yuyv 640x480"
+// or "rgb 640x480 stride=2048".
+func (rf RawFormat) String() string {
+	s := fmt.Sprintf("%v %dx%d", rf.Format, rf.Width, rf.Height)
+	if rf.Stride != 0 {
+		s += fmt.Sprintf(" stride=%d", rf.Stride)
+	}
+	return s
+}
+
+// ParseRawFormat is the inverse of RawFormat.String.
+func ParseRawFormat(s string) (RawFormat, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return RawFormat{}, fmt.Errorf("bad raw format '%s'", s)
+	}
+	var rf RawFormat
+	var err error
+	if rf.Format, err = ParsePixelFormat(fields[0]); err != nil {
+		return RawFormat{}, err
+	}
+	if _, err := fmt.Sscanf(fields[1], "%dx%d", &rf.Width, &rf.Height); err != nil {
+		return RawFormat{}, fmt.Errorf("bad dimensions in raw format '%s': %v", s, err)
+	}
+	for _, f := range fields[2:] {
+		if strings.HasPrefix(f, "stride=") {
+			if rf.Stride, err = strconv.Atoi(f[len("stride="):]); err != nil {
+				return RawFormat{}, fmt.Errorf("bad stride in raw format '%s': %v", s, err)
+			}
+		}
+	}
+	if rf.Width <= 0 || rf.Height <= 0 || (rf.Stride != 0 && rf.Stride < rf.Width*rf.Format.BytesPerPixel()) {
+		return RawFormat{}, fmt.Errorf("invalid raw format '%s'", s)
+	}
+	return rf, nil
+}
+
+// ReadRawInfo returns the formats listed in the RawInfoFile in dir.
+func ReadRawInfo(dir string) ([]RawFormat, error) {
+	file, err := os.Open(filepath.Join(dir, RawInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rfs []RawFormat
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && line[0] != '#' {
+			if rf, err := ParseRawFormat(line); err != nil {
+				return nil, err
+			} else {
+				rfs = append(rfs, rf)
+			}
+		}
+	}
+	return rfs, scanner.Err()
+}
+
+// WriteRawInfo writes the RawInfoFile in dir, replacing any existing one.
+func WriteRawInfo(dir string, rfs ...RawFormat) error {
+	lines := make([]string, len(rfs))
+	for i, rf := range rfs {
+		lines[i] = rf.String() + "\n"
+	}
+	rawInfoCache.Lock()
+	delete(rawInfoCache.m, filepath.Clean(dir))
+	rawInfoCache.Unlock()
+	return ioutil.WriteFile(filepath.Join(dir, RawInfoFile), []byte(strings.Join(lines, "")), 0644)
+}
+
+// rawInfo is a RawInfoFile as read by cachedRawInfo, with the modification
+// time and size it had.
+type rawInfo struct {
+	rfs     []RawFormat
+	modTime time.Time
+	size    int64
+}
+
+// rawInfoCache holds the RawInfoFiles read by cachedRawInfo, by directory.
+var rawInfoCache = struct {
+	sync.Mutex
+	m map[string]rawInfo
+}{m: make(map[string]rawInfo)}
+
+// cachedRawInfo is like ReadRawInfo, but only rereads the RawInfoFile of a
+// directory when its modification time or size has changed, since stepping
+// through a directory of headerless frames would otherwise reread it for every
+// one.  That way a file rewritten by another process, e.g. a capture restarted
+// at a new resolution, is still noticed.
+func cachedRawInfo(dir string) ([]RawFormat, error) {
+	dir = filepath.Clean(dir)
+	rawInfoCache.Lock()
+	defer rawInfoCache.Unlock()
+	fi, err := os.Stat(filepath.Join(dir, RawInfoFile))
+	if err != nil {
+		delete(rawInfoCache.m, dir)
+		return nil, err
+	}
+	if ri, ok := rawInfoCache.m[dir]; ok && ri.modTime.Equal(fi.ModTime()) && ri.size == fi.Size() {
+		return ri.rfs, nil
+	}
+	rfs, err := ReadRawInfo(dir)
+	if err != nil {
+		delete(rawInfoCache.m, dir)
+		return nil, err
+	}
+	rawInfoCache.m[dir] = rawInfo{rfs, fi.ModTime(), fi.Size()}
+	return rfs, nil
+}
+
+// common resolutions used when a headerless file has no RawInfoFile.
+var guessableSizes = []image.Point{
+	{160, 120}, {176, 144}, {320, 240}, {352, 288}, {640, 360}, {640, 480},
+	{800, 600}, {960, 720}, {1024, 768}, {1280, 720}, {1280, 960}, {1280, 1024},
+	{1600, 1200}, {1920, 1080},
+}
+
+func guessRect(numpix int) *image.Rectangle {
+	for _, sz := range guessableSizes {
+		if sz.X*sz.Y == numpix {
+			return &image.Rectangle{Max: sz}
+		}
+	}
+	return nil
+}
+
+// rawFormatFor works out the layout of the headerless file at path, which
+// contains pixels in format pf: from the RawInfoFile in its directory if that
+// has an entry for pf, and otherwise by guessing from the file's size.
+func rawFormatFor(path string, pf PixelFormat) (RawFormat, error) {
+	if rfs, err := cachedRawInfo(filepath.Dir(path)); err == nil {
+		for _, rf := range rfs {
+			if rf.Format == pf {
+				return rf, nil
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return RawFormat{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return RawFormat{}, err
+	}
+	if r := guessRect(int(fi.Size()) / pf.BytesPerPixel()); r != nil {
+		return RawFormat{Format: pf, Width: r.Dx(), Height: r.Dy()}, nil
+	}
+	return RawFormat{}, fmt.Errorf("unknown dims, filesize=%d; add a %s file to the directory", fi.Size(), RawInfoFile)
+}
+
+// LoadRawImage reads the headerless image at path, whose layout is rf.  Only
+// the first rf.FrameSize() bytes are read; it's an error if there are fewer.
+func LoadRawImage(path string, rf RawFormat) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	pix := make([]byte, rf.FrameSize())
+	if _, err := io.ReadFull(file, pix); err != nil {
+		return nil, fmt.Errorf("error reading %v image from '%s': %v", rf, path, err)
+	}
+	return rf.Format.NewImage(pix, rf.GetStride(), image.Rect(0, 0, rf.Width, rf.Height))
+}
+
+// LoadRawPixelSequence is like LoadRawImage but returns a PixelSequence.
+func LoadRawPixelSequence(path string, rf RawFormat) (PixelSequence, error) {
+	if img, err := LoadRawImage(path, rf); err != nil {
+		return PixelSequence{}, err
+	} else {
+		return GetPixelSequence(img), nil
+	}
+}
+
+// loadRawFile loads the headerless file at path containing pixels in format pf,
+// working out its dimensions with rawFormatFor.
+func loadRawFile(path string, pf PixelFormat) (image.Image, error) {
+	rf, err := rawFormatFor(path, pf)
+	if err != nil {
+		return nil, err
+	}
+	return LoadRawImage(path, rf)
+}
+
+// RawFile provides random access to a file of concatenated headerless images
+// all having the same layout, which is mapped into memory.  The images it
+// returns share memory with the mapping, so they must not be used after Close.
+type RawFile struct {
+	rf    RawFormat
+	data  []byte
+	unmap func() error
+}
+
+// OpenRawFile maps the file at path, which holds images laid out as rf.  A
+// truncated final image is ignored.
+func OpenRawFile(path string, rf RawFormat) (*RawFile, error) {
+	if rf.FrameSize() <= 0 {
+		return nil, fmt.Errorf("invalid raw format %v", rf)
+	}
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &RawFile{rf: rf, data: data, unmap: unmap}, nil
+}
+
+// Format returns the layout of the images in the file.
+func (raw *RawFile) Format() RawFormat {
+	return raw.rf
+}
+
+// Len returns the number of images.
+func (raw *RawFile) Len() int {
+	return len(raw.data) / raw.rf.FrameSize()
+}
+
+// Image returns the ith image as an image sharing memory with raw.
+func (raw *RawFile) Image(i int) image.Image {
+	fsz := raw.rf.FrameSize()
+	pix := raw.data[i*fsz : (i+1)*fsz : (i+1)*fsz]
+	img, _ := raw.rf.Format.NewImage(pix, raw.rf.GetStride(), image.Rect(0, 0, raw.rf.Width, raw.rf.Height))
+	return img
+}
+
+// Close releases the memory mapping.
+func (raw *RawFile) Close() error {
+	raw.data = nil
+	if raw.unmap == nil {
+		return nil
+	}
+	err := raw.unmap()
+	raw.unmap = nil
+	return err
+}
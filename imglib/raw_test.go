@@ -0,0 +1,126 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "bytes"
+import "image"
+import "image/png"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+
+func (s *MySuite) TestParseRawFormat(c *C) {
+	for _, rf := range []RawFormat{
+		{Format: PixelFormatYUYV, Width: 640, Height: 480},
+		{Format: PixelFormatRGB, Width: 5, Height: 3, Stride: 16},
+	} {
+		p, err := ParseRawFormat(rf.String())
+		c.Check(err, IsNil)
+		c.Check(p, Equals, rf)
+	}
+	for _, bad := range []string{"", "yuyv", "bgr 4x4", "rgb 4by4", "rgb 0x4", "rgb 4x4 stride=8"} {
+		_, err := ParseRawFormat(bad)
+		c.Check(err, NotNil, Commentf("%s", bad))
+	}
+	c.Check(PixelFormatForExt(PixelFormatRGBA.Ext()), Equals, PixelFormatRGBA)
+	c.Check(PixelFormatForExt(".png"), Equals, PixelFormatUnknown)
+}
+
+func (s *MySuite) TestLoadRawWithInfo(c *C) {
+	dir := c.MkDir()
+	rgb := getTestRgbImage(image.Point{5, 3})
+	path := filepath.Join(dir, "test"+PixelFormatRGB.Ext())
+	c.Assert(rgb.StoreRaw(path), IsNil)
+
+	// 5x3 isn't a size we can guess.
+	_, err := LoadImage(path)
+	c.Check(err, ErrorMatches, "unknown dims.*")
+
+	rf := RawFormat{Format: PixelFormatRGB, Width: 5, Height: 3}
+	c.Assert(WriteRawInfo(dir, RawFormat{Format: PixelFormatYUYV, Width: 8, Height: 2}, rf), IsNil)
+	rfs, err := ReadRawInfo(dir)
+	c.Assert(err, IsNil)
+	c.Check(rfs, HasLen, 2)
+
+	img, err := LoadImage(path)
+	c.Assert(err, IsNil)
+	c.Check(img, DeepEquals, rgb)
+	ps, err := LoadPixelSequence(path)
+	c.Assert(err, IsNil)
+	c.Check(ps, DeepEquals, GetPixelSequence(rgb))
+	ps, err = LoadRawPixelSequence(path, RawFormat{Format: PixelFormatRGB, Width: 3, Height: 2})
+	c.Assert(err, IsNil)
+	c.Check(ps.Row(1), DeepEquals, rgb.Pix[9:18])
+
+	// The file is too short for this.
+	_, err = LoadRawImage(path, RawFormat{Format: PixelFormatRGB, Width: 5, Height: 4})
+	c.Check(err, NotNil)
+	// A RawInfoFile rewritten behind our back, as by a capture restarted at
+	// another size, is noticed.
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, RawInfoFile), []byte("# restarted\nrgb 3x2\n"), 0644), IsNil)
+	img, err = LoadImage(path)
+	c.Assert(err, IsNil)
+	c.Check(img.Bounds(), Equals, image.Rect(0, 0, 3, 2))
+	c.Assert(WriteRawInfo(dir, rf), IsNil)
+	img, err = LoadImage(path)
+	c.Assert(err, IsNil)
+	c.Check(img.Bounds(), Equals, image.Rect(0, 0, 5, 3))
+}
+
+func (s *MySuite) TestLoadSniffed(c *C) {
+	dir := c.MkDir()
+	rgba := StdImage{getTestRgbImage(image.Point{6, 4})}.GetRGBA()
+	var buf bytes.Buffer
+	c.Assert(png.Encode(&buf, rgba), IsNil)
+	// The content, not the extension, determines how the file is decoded.
+	path := filepath.Join(dir, "snapshot.dat")
+	f, err := os.Create(path)
+	c.Assert(err, IsNil)
+	_, err = f.Write(buf.Bytes())
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	ext, err := SniffExt(path)
+	c.Check(err, IsNil)
+	c.Check(ext, Equals, ".png")
+	ps, err := LoadPixelSequence(path)
+	c.Assert(err, IsNil)
+	c.Check(ps.GetImage(), DeepEquals, rgba)
+
+	ypath := filepath.Join(dir, "stream.dat")
+	f, err = os.Create(ypath)
+	c.Assert(err, IsNil)
+	yw, err := NewY4MWriter(f, Y4MHeader{Width: 6, Height: 4, Chroma: "422"})
+	c.Assert(err, IsNil)
+	c.Assert(yw.WriteImage(rgba), IsNil)
+	c.Assert(yw.Flush(), IsNil)
+	c.Assert(f.Close(), IsNil)
+	ext, err = SniffExt(ypath)
+	c.Check(ext, Equals, Y4MExt)
+	img, err := LoadImage(ypath)
+	c.Assert(err, IsNil)
+	c.Check(img, FitsTypeOf, &YUYV{})
+
+	_, err = LoadImage(filepath.Join(dir, "missing.png"))
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestRawFile(c *C) {
+	a, b := getTestRgbImage(image.Point{5, 3}), getTestRgbImage(image.Point{5, 3})
+	b.Pix[0] = ^b.Pix[0]
+	path := filepath.Join(c.MkDir(), "frames.rgb")
+	// A truncated third image follows the two complete ones.
+	data := append(append(append([]byte(nil), a.Pix...), b.Pix...), a.Pix[:7]...)
+	c.Assert(ioutil.WriteFile(path, data, 0644), IsNil)
+
+	rf := RawFormat{Format: PixelFormatRGB, Width: 5, Height: 3}
+	raw, err := OpenRawFile(path, rf)
+	c.Assert(err, IsNil)
+	c.Check(raw.Format(), Equals, rf)
+	c.Assert(raw.Len(), Equals, 2)
+	c.Check(raw.Image(0), DeepEquals, a)
+	c.Check(raw.Image(1), DeepEquals, b)
+	c.Check(raw.Close(), IsNil)
+
+	_, err = OpenRawFile(path, RawFormat{Width: 5, Height: 3})
+	c.Check(err, NotNil)
+}
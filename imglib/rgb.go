@@ -92,11 +92,11 @@ func (img *RGB) SetRGBA(x, y int, c color.RGBA) {
 // doing a full conversion, assuming you're going to look at most of the pixels
 // in the image.
 func (img *RGB) GetRow(y int, dest interface{}) {
-    ret := dest.([]color.RGBA)[:0]
-    start := img.PixOffset(0, y)
-    end := start + img.Stride
-    for i := start; i < end; i += 3 {
-        ret = append(ret, color.RGBA{img.Pix[i+0], img.Pix[i+1], img.Pix[i+2], 0xFF})
+    ret := dest.([]color.RGBA)[:img.Rect.Dx()]
+    i := img.PixOffset(img.Rect.Min.X, y)
+    for x := range ret {
+        ret[x] = color.RGBA{img.Pix[i+0], img.Pix[i+1], img.Pix[i+2], 0xFF}
+        i += 3
     }
 }
 
@@ -147,7 +147,7 @@ func (img *RGB) StrictSubImage(r image.Rectangle) image.Image {
 		return &RGB{}
 	}
 	i := img.PixOffset(r.Min.X, r.Min.Y)
-	endi := img.PixOffset(r.Max.X, r.Max.Y)
+	endi := img.PixOffset(r.Max.X, r.Max.Y-1)
 	return &RGB{
 		Pix:    img.Pix[i:endi],
 		Stride: img.Stride,
@@ -0,0 +1,189 @@
+package imglib
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Luma values at or beyond these are considered clipped when computing
+// Stats.ClippedLow and Stats.ClippedHigh.  A covered lens gives mostly
+// ClippedLow pixels, overexposure mostly ClippedHigh ones.
+const (
+	ClipLow  = 4
+	ClipHigh = 251
+)
+
+// Histogram counts the samples of a channel by value.
+type Histogram [256]int
+
+// Count returns the number of samples.
+func (h *Histogram) Count() int {
+	n := 0
+	for _, c := range h {
+		n += c
+	}
+	return n
+}
+
+// CountRange returns the number of samples with values in [lo,hi].
+func (h *Histogram) CountRange(lo, hi uint8) int {
+	n := 0
+	for v := int(lo); v <= int(hi); v++ {
+		n += h[v]
+	}
+	return n
+}
+
+// Percentile returns the smallest value v such that at least fraction p of
+// the samples are <= v.
+func (h *Histogram) Percentile(p float64) uint8 {
+	want := int(math.Ceil(p * float64(h.Count())))
+	n := 0
+	for v, c := range h {
+		n += c
+		if n >= want && n > 0 {
+			return uint8(v)
+		}
+	}
+	return 255
+}
+
+// ChannelStats summarises the samples of one channel.
+type ChannelStats struct {
+	Hist         Histogram
+	Min, Max     uint8
+	Mean, StdDev float64
+}
+
+// finish computes the summary fields from the histogram.
+func (cs *ChannelStats) finish() {
+	n := cs.Hist.Count()
+	if n == 0 {
+		*cs = ChannelStats{}
+		return
+	}
+	cs.Min, cs.Max = 255, 0
+	var sum, sumsq float64
+	for v, c := range cs.Hist {
+		if c == 0 {
+			continue
+		}
+		if uint8(v) < cs.Min {
+			cs.Min = uint8(v)
+		}
+		cs.Max = uint8(v)
+		sum += float64(v * c)
+		sumsq += float64(v * v * c)
+	}
+	cs.Mean = sum / float64(n)
+	cs.StdDev = math.Sqrt(math.Max(0, sumsq/float64(n)-cs.Mean*cs.Mean))
+}
+
+func (cs ChannelStats) String() string {
+	return fmt.Sprintf("min=%d max=%d mean=%.1f sd=%.1f", cs.Min, cs.Max, cs.Mean, cs.StdDev)
+}
+
+// Stats describes the distribution of pixel values in an image.  Y, Cb and Cr
+// are always filled in; for YUYV images the chroma channels have one sample
+// per pixel pair, as stored.  R, G and B are only computed for RGB and RGBA
+// images, and are nil otherwise.
+type Stats struct {
+	Pixels    int
+	Y, Cb, Cr ChannelStats
+	R, G, B   *ChannelStats
+	// ClippedLow and ClippedHigh are the fractions of pixels whose luma is at
+	// most ClipLow or at least ClipHigh.
+	ClippedLow, ClippedHigh float64
+}
+
+func (s *Stats) String() string {
+	return fmt.Sprintf("%d pixels, Y %v, Cb %v, Cr %v, clipped low=%.3f high=%.3f",
+		s.Pixels, s.Y, s.Cb, s.Cr, s.ClippedLow, s.ClippedHigh)
+}
+
+func (s *Stats) finish() {
+	for _, cs := range []*ChannelStats{&s.Y, &s.Cb, &s.Cr, s.R, s.G, s.B} {
+		if cs != nil {
+			cs.finish()
+		}
+	}
+	if s.Pixels > 0 {
+		s.ClippedLow = float64(s.Y.Hist.CountRange(0, ClipLow)) / float64(s.Pixels)
+		s.ClippedHigh = float64(s.Y.Hist.CountRange(ClipHigh, 255)) / float64(s.Pixels)
+	}
+}
+
+// GetStats returns the Stats for all of img.
+func GetStats(img image.Image) *Stats {
+	return GetStatsRect(img, img.Bounds())
+}
+
+// GetStatsRect returns the Stats for the part of img within r.  YUYV, RGB and
+// RGBA images are read in a single pass over Pix; anything else is converted
+// to RGBA first.  For YUYV images r is widened if need be so as not to split
+// pixel pairs, though never beyond the bounds of img.
+func GetStatsRect(img image.Image, r image.Rectangle) *Stats {
+	r = r.Intersect(img.Bounds())
+	s := &Stats{}
+	if !r.Empty() {
+		switch concrete := img.(type) {
+		case *YUYV:
+			statsYUYV(s, concrete, r)
+		case *RGB:
+			statsPacked(s, concrete.Pix, concrete.PixOffset(r.Min.X, r.Min.Y), concrete.Stride, r.Size(), rgbBpp)
+		case *image.RGBA:
+			statsPacked(s, concrete.Pix, concrete.PixOffset(r.Min.X, r.Min.Y), concrete.Stride, r.Size(), rgbaBpp)
+		default:
+			rgba := StdImage{img}.GetRGBA()
+			r = r.Sub(img.Bounds().Min)
+			statsPacked(s, rgba.Pix, rgba.PixOffset(r.Min.X, r.Min.Y), rgba.Stride, r.Size(), rgbaBpp)
+		}
+	}
+	s.finish()
+	return s
+}
+
+func statsYUYV(s *Stats, img *YUYV, r image.Rectangle) {
+	// Pairs start on even columns, following At(), and Pix always holds whole
+	// pairs even when the image bounds split them.  Only the luma of pixels
+	// within the image is counted, but the chroma of every pair touched is.
+	r.Min.X, r.Max.X = pairFloor(r.Min.X), pairCeil(r.Max.X)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		i := img.PixOffset(r.Min.X, y)
+		for x := r.Min.X; x < r.Max.X; x += 2 {
+			if x >= img.Rect.Min.X {
+				s.Y.Hist[img.Pix[i+0]]++
+			}
+			s.Cb.Hist[img.Pix[i+1]]++
+			if x+1 < img.Rect.Max.X {
+				s.Y.Hist[img.Pix[i+2]]++
+			}
+			s.Cr.Hist[img.Pix[i+3]]++
+			i += 4
+		}
+	}
+	s.Pixels = s.Y.Hist.Count()
+}
+
+// statsPacked handles RGB and RGBA, whose pixels are bpp bytes apart.  Alpha is
+// ignored.
+func statsPacked(s *Stats, pix []byte, start, stride int, sz image.Point, bpp int) {
+	s.R, s.G, s.B = &ChannelStats{}, &ChannelStats{}, &ChannelStats{}
+	for y := 0; y < sz.Y; y++ {
+		i := start + y*stride
+		for x := 0; x < sz.X; x++ {
+			r, g, b := pix[i+0], pix[i+1], pix[i+2]
+			s.R.Hist[r]++
+			s.G.Hist[g]++
+			s.B.Hist[b]++
+			yy, cb, cr := color.RGBToYCbCr(r, g, b)
+			s.Y.Hist[yy]++
+			s.Cb.Hist[cb]++
+			s.Cr.Hist[cr]++
+			i += bpp
+		}
+	}
+	s.Pixels = sz.X * sz.Y
+}
@@ -0,0 +1,87 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "image"
+import "image/color"
+import "math"
+
+func (s *MySuite) TestHistogram(c *C) {
+	var h Histogram
+	h[10], h[20], h[30] = 1, 2, 1
+	c.Check(h.Count(), Equals, 4)
+	c.Check(h.CountRange(15, 30), Equals, 3)
+	c.Check(h.Percentile(0.25), Equals, uint8(10))
+	c.Check(h.Percentile(0.5), Equals, uint8(20))
+	c.Check(h.Percentile(1), Equals, uint8(30))
+}
+
+func (s *MySuite) TestStatsRGB(c *C) {
+	rgb := NewRGB(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		rgb.SetRGBA(x, 0, color.RGBA{uint8(x * 10), 0, 255, 255})
+		rgb.SetRGBA(x, 1, color.RGBA{255, 255, 255, 255})
+	}
+	st := GetStats(rgb)
+	c.Check(st.Pixels, Equals, 8)
+	c.Assert(st.R, NotNil)
+	c.Check(st.R.Min, Equals, uint8(0))
+	c.Check(st.R.Max, Equals, uint8(255))
+	c.Check(st.B.StdDev, Equals, 0.0)
+	c.Check(st.R.Mean, Equals, (0+10+20+30+4*255)/8.0)
+	c.Check(st.ClippedHigh, Equals, 0.5)
+	c.Check(st.Y.Max, Equals, uint8(255))
+
+	// RGBA gives the same answers.
+	c.Check(GetStats(StdImage{rgb}.GetRGBA()), DeepEquals, st)
+
+	sub := GetStatsRect(rgb, image.Rect(1, 0, 3, 1))
+	c.Check(sub.Pixels, Equals, 2)
+	c.Check(sub.R.Mean, Equals, 15.0)
+	c.Check(sub.ClippedHigh, Equals, 0.0)
+	c.Check(GetStatsRect(rgb, image.Rect(10, 10, 20, 20)).Pixels, Equals, 0)
+}
+
+func (s *MySuite) TestStatsYUYV(c *C) {
+	yuyv := NewYUYV(image.Rect(0, 0, 4, 2))
+	copy(yuyv.Pix, []byte{
+		0, 100, 2, 150, 4, 110, 6, 160,
+		250, 120, 252, 170, 254, 130, 255, 180,
+	})
+	st := GetStats(yuyv)
+	c.Check(st.Pixels, Equals, 8)
+	c.Check(st.R, IsNil)
+	c.Check(st.Cb.Hist.Count(), Equals, 4)
+	c.Check(st.Cb.Mean, Equals, 115.0)
+	c.Check(st.Cr.Min, Equals, uint8(150))
+	c.Check(st.ClippedLow, Equals, 3.0/8)
+	c.Check(st.ClippedHigh, Equals, 3.0/8)
+	c.Check(st.Y.Mean, Equals, 1023.0/8)
+	c.Check(math.Abs(st.Y.StdDev-125) < 1, Equals, true)
+
+	// Sub-rectangles don't split pixel pairs.
+	sub := GetStatsRect(yuyv, image.Rect(1, 1, 3, 2))
+	c.Check(sub.Pixels, Equals, 4)
+	c.Check(sub.Y.Min, Equals, uint8(250))
+	c.Check(sub.Y.Max, Equals, uint8(255))
+
+	// Odd widths count the lone pixel at the end of each row, and the chroma
+	// of its pair; sub-images starting on odd columns skip the luma to their
+	// left.
+	odd := NewYUYV(image.Rect(0, 0, 3, 2))
+	copy(odd.Pix, yuyv.Pix)
+	st = GetStats(odd)
+	c.Check(st.Pixels, Equals, 6)
+	c.Check(st.Cb.Hist.Count(), Equals, 4)
+	c.Check(st.Y.Max, Equals, uint8(254))
+	st = GetStats(yuyv.SubImage(image.Rect(1, 0, 4, 2)))
+	c.Check(st.Pixels, Equals, 6)
+	c.Check(st.Y.Min, Equals, uint8(2))
+	c.Check(st.Cr.Hist.Count(), Equals, 4)
+
+	// Other types are converted.
+	gray := image.NewGray(image.Rect(1, 1, 3, 3))
+	gray.Pix[0] = 255
+	st = GetStats(gray)
+	c.Check(st.Pixels, Equals, 4)
+	c.Check(st.ClippedLow, Equals, 0.75)
+}
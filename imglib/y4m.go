@@ -0,0 +1,371 @@
+package imglib
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Y4MExt is the conventional file extension for YUV4MPEG2 streams.
+const Y4MExt = ".y4m"
+
+const (
+	y4mMagic       = "YUV4MPEG2"
+	y4mFrameMarker = "FRAME"
+)
+
+// y4mMaxDim and y4mMaxPixels bound the frame size we'll accept from a header,
+// so that a corrupt one can't make us allocate absurd amounts or overflow
+// FrameSize.  Even 4:4:4 frames of y4mMaxPixels fit in an int32.
+const (
+	y4mMaxDim    = 1 << 16
+	y4mMaxPixels = 1 << 28
+)
+
+// Y4MHeader holds the stream parameters of a YUV4MPEG2 stream.  Chroma is the
+// value of the C parameter, e.g. "422" or "420jpeg"; all the 4:2:0 siting
+// variants are treated alike.  Unset (zero) rates and aspects are omitted when
+// writing.
+type Y4MHeader struct {
+	Width, Height int
+	FrameRateNum  int
+	FrameRateDen  int
+	AspectNum     int
+	AspectDen     int
+	Interlace     string
+	Chroma        string
+}
+
+// SubsampleRatio returns the image.YCbCrSubsampleRatio corresponding to Chroma.
+func (h Y4MHeader) SubsampleRatio() (image.YCbCrSubsampleRatio, error) {
+	switch h.Chroma {
+	case "444":
+		return image.YCbCrSubsampleRatio444, nil
+	case "422":
+		return image.YCbCrSubsampleRatio422, nil
+	case "", "420", "420jpeg", "420mpeg2", "420paldv":
+		// 420jpeg is the default when C is absent.
+		return image.YCbCrSubsampleRatio420, nil
+	}
+	return 0, fmt.Errorf("unsupported y4m chroma format '%s'", h.Chroma)
+}
+
+// FrameSize returns the number of bytes of pixel data in each frame.
+func (h Y4MHeader) FrameSize() int {
+	ratio, err := h.SubsampleRatio()
+	if err != nil {
+		return 0
+	}
+	cw, ch := chromaSize(h.Width, h.Height, ratio)
+	return h.Width*h.Height + 2*cw*ch
+}
+
+func chromaSize(w, h int, ratio image.YCbCrSubsampleRatio) (int, int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return (w + 1) / 2, h
+	case image.YCbCrSubsampleRatio420:
+		return (w + 1) / 2, (h + 1) / 2
+	}
+	return w, h
+}
+
+func (h Y4MHeader) String() string {
+	s := fmt.Sprintf("%s W%d H%d", y4mMagic, h.Width, h.Height)
+	if h.FrameRateNum != 0 && h.FrameRateDen != 0 {
+		s += fmt.Sprintf(" F%d:%d", h.FrameRateNum, h.FrameRateDen)
+	}
+	if h.Interlace != "" {
+		s += " I" + h.Interlace
+	}
+	if h.AspectNum != 0 && h.AspectDen != 0 {
+		s += fmt.Sprintf(" A%d:%d", h.AspectNum, h.AspectDen)
+	}
+	if h.Chroma != "" {
+		s += " C" + h.Chroma
+	}
+	return s
+}
+
+func parseRatio(s string) (int, int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("bad ratio '%s'", s)
+	}
+	n, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	d, err := strconv.Atoi(parts[1])
+	return n, d, err
+}
+
+// parseY4MHeader parses the stream header line, without its trailing newline.
+// Unknown parameters are ignored as the spec requires.
+func parseY4MHeader(line string) (Y4MHeader, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != y4mMagic {
+		return Y4MHeader{}, fmt.Errorf("not a y4m stream")
+	}
+	var h Y4MHeader
+	var err error
+	for _, f := range fields[1:] {
+		v := f[1:]
+		switch f[0] {
+		case 'W':
+			h.Width, err = strconv.Atoi(v)
+		case 'H':
+			h.Height, err = strconv.Atoi(v)
+		case 'F':
+			h.FrameRateNum, h.FrameRateDen, err = parseRatio(v)
+		case 'A':
+			h.AspectNum, h.AspectDen, err = parseRatio(v)
+		case 'I':
+			h.Interlace = v
+		case 'C':
+			h.Chroma = v
+		}
+		if err != nil {
+			return Y4MHeader{}, fmt.Errorf("bad y4m header parameter '%s': %v", f, err)
+		}
+	}
+	if h.Width <= 0 || h.Height <= 0 {
+		return Y4MHeader{}, fmt.Errorf("y4m header lacks valid dimensions: '%s'", line)
+	}
+	if h.Width > y4mMaxDim || h.Height > y4mMaxDim || h.Width > y4mMaxPixels/h.Height {
+		return Y4MHeader{}, fmt.Errorf("y4m frame size %dx%d too large", h.Width, h.Height)
+	}
+	if _, err := h.SubsampleRatio(); err != nil {
+		return Y4MHeader{}, err
+	}
+	return h, nil
+}
+
+// IsY4M returns true if b starts with the YUV4MPEG2 signature.
+func IsY4M(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(y4mMagic))
+}
+
+// y4mImage returns an image.YCbCr whose planes are slices of buf.
+func (h Y4MHeader) y4mImage(buf []byte) *image.YCbCr {
+	ratio, _ := h.SubsampleRatio()
+	cw, ch := chromaSize(h.Width, h.Height, ratio)
+	ysz, csz := h.Width*h.Height, cw*ch
+	return &image.YCbCr{
+		Y:              buf[0:ysz:ysz],
+		Cb:             buf[ysz : ysz+csz : ysz+csz],
+		Cr:             buf[ysz+csz : ysz+2*csz : ysz+2*csz],
+		YStride:        h.Width,
+		CStride:        cw,
+		SubsampleRatio: ratio,
+		Rect:           image.Rect(0, 0, h.Width, h.Height),
+	}
+}
+
+// Y4MReader reads frames sequentially from a YUV4MPEG2 stream.
+type Y4MReader struct {
+	r   *bufio.Reader
+	hdr Y4MHeader
+}
+
+// NewY4MReader reads the stream header from r.
+func NewY4MReader(r io.Reader) (*Y4MReader, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("error reading y4m header: %v", err)
+	}
+	hdr, err := parseY4MHeader(strings.TrimSuffix(line, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	return &Y4MReader{r: br, hdr: hdr}, nil
+}
+
+// Header returns the stream header.
+func (yr *Y4MReader) Header() Y4MHeader {
+	return yr.hdr
+}
+
+// ReadYCbCr returns the next frame, or io.EOF if there are no more.  A
+// truncated frame yields io.ErrUnexpectedEOF.
+func (yr *Y4MReader) ReadYCbCr() (*image.YCbCr, error) {
+	line, err := yr.r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if !strings.HasPrefix(line, y4mFrameMarker) {
+		return nil, fmt.Errorf("expected y4m frame header, got '%s'", strings.TrimSpace(line))
+	}
+	buf := make([]byte, yr.hdr.FrameSize())
+	if _, err := io.ReadFull(yr.r, buf); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return yr.hdr.y4mImage(buf), nil
+}
+
+// ReadYUYV is like ReadYCbCr but converts the frame to YUYV.
+func (yr *Y4MReader) ReadYUYV() (*YUYV, error) {
+	ycbcr, err := yr.ReadYCbCr()
+	if err != nil {
+		return nil, err
+	}
+	return NewYUYVFromYCbCr(ycbcr), nil
+}
+
+// Y4MWriter writes frames to a YUV4MPEG2 stream.  Call Flush when done.
+type Y4MWriter struct {
+	w     *bufio.Writer
+	hdr   Y4MHeader
+	ratio image.YCbCrSubsampleRatio
+}
+
+// NewY4MWriter writes the stream header hdr to w.
+func NewY4MWriter(w io.Writer, hdr Y4MHeader) (*Y4MWriter, error) {
+	ratio, err := hdr.SubsampleRatio()
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(hdr.String() + "\n"); err != nil {
+		return nil, err
+	}
+	return &Y4MWriter{w: bw, hdr: hdr, ratio: ratio}, nil
+}
+
+// Header returns the stream header.
+func (yw *Y4MWriter) Header() Y4MHeader {
+	return yw.hdr
+}
+
+// WriteImage appends img to the stream, converting it to the stream's chroma
+// format if need be.  img must have the dimensions given in the header.
+func (yw *Y4MWriter) WriteImage(img image.Image) error {
+	if sz := img.Bounds().Size(); sz.X != yw.hdr.Width || sz.Y != yw.hdr.Height {
+		return fmt.Errorf("image is %dx%d, y4m stream is %dx%d", sz.X, sz.Y, yw.hdr.Width, yw.hdr.Height)
+	}
+	ycbcr := NewYCbCr(img, yw.ratio)
+	if _, err := yw.w.WriteString(y4mFrameMarker + "\n"); err != nil {
+		return err
+	}
+	cw, ch := chromaSize(yw.hdr.Width, yw.hdr.Height, yw.ratio)
+	if err := writePlane(yw.w, ycbcr.Y, ycbcr.YStride, yw.hdr.Width, yw.hdr.Height); err != nil {
+		return err
+	}
+	if err := writePlane(yw.w, ycbcr.Cb, ycbcr.CStride, cw, ch); err != nil {
+		return err
+	}
+	return writePlane(yw.w, ycbcr.Cr, ycbcr.CStride, cw, ch)
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (yw *Y4MWriter) Flush() error {
+	return yw.w.Flush()
+}
+
+func writePlane(w io.Writer, plane []byte, stride, width, height int) error {
+	for y := 0; y < height; y++ {
+		if _, err := w.Write(plane[y*stride : y*stride+width]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Y4MFile provides random access to the frames of a YUV4MPEG2 file, which is
+// mapped into memory.  The images it returns share memory with the mapping,
+// so they must not be used after Close.
+type Y4MFile struct {
+	hdr     Y4MHeader
+	data    []byte
+	offsets []int
+	unmap   func() error
+}
+
+// OpenY4MFile maps the file at path and builds an index of its frames.  A
+// truncated final frame is ignored.
+func OpenY4MFile(path string) (*Y4MFile, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	yf, err := newY4MFile(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("error reading '%s': %v", path, err)
+	}
+	yf.unmap = unmap
+	return yf, nil
+}
+
+func newY4MFile(data []byte) (*Y4MFile, error) {
+	eol := bytes.IndexByte(data, '\n')
+	if eol < 0 {
+		return nil, fmt.Errorf("not a y4m stream")
+	}
+	hdr, err := parseY4MHeader(string(data[:eol]))
+	if err != nil {
+		return nil, err
+	}
+	yf := &Y4MFile{hdr: hdr, data: data}
+	fsz := hdr.FrameSize()
+	for p := eol + 1; p < len(data); {
+		if !bytes.HasPrefix(data[p:], []byte(y4mFrameMarker)) {
+			return nil, fmt.Errorf("expected frame header at offset %d", p)
+		}
+		feol := bytes.IndexByte(data[p:], '\n')
+		if feol < 0 || p+feol+1+fsz > len(data) {
+			break
+		}
+		p += feol + 1
+		yf.offsets = append(yf.offsets, p)
+		p += fsz
+	}
+	return yf, nil
+}
+
+// Header returns the stream header.
+func (yf *Y4MFile) Header() Y4MHeader {
+	return yf.hdr
+}
+
+// Len returns the number of frames.
+func (yf *Y4MFile) Len() int {
+	return len(yf.offsets)
+}
+
+// YCbCr returns the ith frame.
+func (yf *Y4MFile) YCbCr(i int) *image.YCbCr {
+	p := yf.offsets[i]
+	return yf.hdr.y4mImage(yf.data[p : p+yf.hdr.FrameSize()])
+}
+
+// Close releases the memory mapping.
+func (yf *Y4MFile) Close() error {
+	yf.data, yf.offsets = nil, nil
+	if yf.unmap == nil {
+		return nil
+	}
+	err := yf.unmap()
+	yf.unmap = nil
+	return err
+}
+
+// LoadY4MImage returns the first frame of the YUV4MPEG2 file at path as a YUYV.
+func LoadY4MImage(path string) (*YUYV, error) {
+	yf, err := OpenY4MFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer yf.Close()
+	if yf.Len() == 0 {
+		return nil, fmt.Errorf("no frames in '%s'", path)
+	}
+	return NewYUYVFromYCbCr(yf.YCbCr(0)), nil
+}
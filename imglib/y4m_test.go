@@ -0,0 +1,101 @@
+package imglib
+
+import . "gopkg.in/check.v1"
+import "bytes"
+import "image"
+import "io"
+import "io/ioutil"
+import "path/filepath"
+
+func (s *MySuite) TestY4MHeader(c *C) {
+	h, err := parseY4MHeader("YUV4MPEG2 W640 H480 F30000:1001 Ip A1:1 C420jpeg XYSCSS=420JPEG")
+	c.Assert(err, IsNil)
+	c.Check(h, DeepEquals, Y4MHeader{Width: 640, Height: 480, FrameRateNum: 30000, FrameRateDen: 1001,
+		AspectNum: 1, AspectDen: 1, Interlace: "p", Chroma: "420jpeg"})
+	c.Check(h.String(), Equals, "YUV4MPEG2 W640 H480 F30000:1001 Ip A1:1 C420jpeg")
+	c.Check(h.FrameSize(), Equals, 640*480*3/2)
+
+	_, err = parseY4MHeader("YUV4MPEG2 W640 H480 Cmono")
+	c.Check(err, NotNil)
+	_, err = parseY4MHeader("YUV4MPEG2 H480")
+	c.Check(err, NotNil)
+	_, err = parseY4MHeader("YUV4MPEG2 W1000000 H1000000 C444")
+	c.Check(err, ErrorMatches, ".*too large")
+	_, err = parseY4MHeader("YUV4MPEG2 W65536 H65536 C444")
+	c.Check(err, ErrorMatches, ".*too large")
+}
+
+func (s *MySuite) TestY4MRoundTrip422(c *C) {
+	imgs := []*YUYV{getTestYuyvImage(image.Point{8, 6}), NewYUYV(image.Rect(0, 0, 8, 6))}
+	var buf bytes.Buffer
+	yw, err := NewY4MWriter(&buf, Y4MHeader{Width: 8, Height: 6, FrameRateNum: 25, FrameRateDen: 1, Chroma: "422"})
+	c.Assert(err, IsNil)
+	for _, img := range imgs {
+		c.Check(yw.WriteImage(img), IsNil)
+	}
+	c.Check(yw.WriteImage(NewYUYV(image.Rect(0, 0, 4, 4))), NotNil)
+	c.Check(yw.Flush(), IsNil)
+
+	path := filepath.Join(c.MkDir(), "test"+Y4MExt)
+	c.Assert(ioutil.WriteFile(path, buf.Bytes(), 0644), IsNil)
+
+	yr, err := NewY4MReader(bytes.NewReader(buf.Bytes()))
+	c.Assert(err, IsNil)
+	c.Check(yr.Header().FrameRateNum, Equals, 25)
+	for _, img := range imgs {
+		yuyv, err := yr.ReadYUYV()
+		c.Check(err, IsNil)
+		c.Check(yuyv, DeepEquals, img)
+	}
+	_, err = yr.ReadYUYV()
+	c.Check(err, Equals, io.EOF)
+
+	yf, err := OpenY4MFile(path)
+	c.Assert(err, IsNil)
+	defer yf.Close()
+	c.Assert(yf.Len(), Equals, 2)
+	c.Check(NewYUYVFromYCbCr(yf.YCbCr(1)), DeepEquals, imgs[1])
+	c.Check(yf.YCbCr(0), DeepEquals, imgs[0].ToYCbCrMinZp())
+
+	loaded, err := LoadImage(path)
+	c.Check(err, IsNil)
+	c.Check(loaded, DeepEquals, imgs[0])
+}
+
+func (s *MySuite) TestY4M420(c *C) {
+	yuyv := getTestYuyvImage(image.Point{8, 6})
+	var buf bytes.Buffer
+	yw, err := NewY4MWriter(&buf, Y4MHeader{Width: 8, Height: 6, Chroma: "420"})
+	c.Assert(err, IsNil)
+	c.Check(yw.WriteImage(yuyv), IsNil)
+	c.Check(yw.Flush(), IsNil)
+	c.Check(buf.Len(), Equals, len("YUV4MPEG2 W8 H6 C420\nFRAME\n")+8*6+2*4*3)
+
+	// Only the chroma should differ, being averaged vertically.
+	yf, err := newY4MFile(buf.Bytes())
+	c.Assert(err, IsNil)
+	out := NewYUYVFromYCbCr(yf.YCbCr(0))
+	for i := 0; i < len(out.Pix); i += 2 {
+		c.Check(out.Pix[i], Equals, yuyv.Pix[i])
+	}
+	ref := yuyv.ToYCbCr(image.YCbCrSubsampleRatio420)
+	c.Check(out.At(3, 3), DeepEquals, ref.At(3, 3))
+
+	// A truncated final frame is ignored.
+	yf, err = newY4MFile(buf.Bytes()[:buf.Len()-1])
+	c.Assert(err, IsNil)
+	c.Check(yf.Len(), Equals, 0)
+}
+
+func (s *MySuite) TestNewYCbCr(c *C) {
+	yuyv := getTestYuyvImage(image.Point{8, 6})
+	c.Check(yuyv.ToYCbCr(image.YCbCrSubsampleRatio422), DeepEquals, yuyv.ToYCbCrMinZp())
+	c.Check(NewYUYVFromYCbCr(NewYCbCr(yuyv, image.YCbCrSubsampleRatio422)), DeepEquals, yuyv)
+	sub := yuyv.SubImage(image.Rect(2, 1, 6, 5)).(*YUYV)
+	c.Check(NewYUYVFromYCbCr(sub.ToYCbCr(image.YCbCrSubsampleRatio422)), DeepEquals, CropYUYV(yuyv, sub.Rect))
+
+	rgb := getTestRgbImage(image.Point{4, 2})
+	ycbcr := NewYCbCr(rgb, image.YCbCrSubsampleRatio444)
+	c.Check(ycbcr.Bounds(), DeepEquals, rgb.Bounds())
+	c.Check(NewYCbCr(ycbcr, image.YCbCrSubsampleRatio444) == ycbcr, Equals, true)
+}
@@ -20,13 +20,28 @@ type YUYV struct {
 	Rect   image.Rectangle
 }
 
-// NewYUYV returns a new blank YUYV with the given bounds.
+// NewYUYV returns a new blank YUYV with the given bounds.  Pixels are paired
+// starting at even columns, so if r has an odd Min.X or Max.X the rows are
+// padded to hold the other pixel of the first or last pair.
 func NewYUYV(r image.Rectangle) *YUYV {
-	w, h := r.Dx(), r.Dy()
+	w, h := pairCeil(r.Max.X)-pairFloor(r.Min.X), r.Dy()
+	if r.Empty() {
+		w, h = 0, 0
+	}
 	buf := make([]uint8, yuyvBytesPP*w*h)
 	return &YUYV{buf, yuyvBytesPP * w, r}
 }
 
+// pairFloor returns the column of the first pixel of the pair containing x.
+func pairFloor(x int) int {
+	return x &^ 1
+}
+
+// pairCeil returns x rounded up to a pair boundary.
+func pairCeil(x int) int {
+	return (x + 1) &^ 1
+}
+
 // ColorModel returns image/color.YCbCrModel, which I think is not quite
 // right but seems to work mostly.
 func (img *YUYV) ColorModel() color.Model {
@@ -41,9 +56,10 @@ func (img *YUYV) Bounds() image.Rectangle {
 // PixOffset returns the index of the first element of Pix that corresponds to
 // the pixel at (x, y).  This doesn't have as well-defined a meaning as the
 // image formats in the Go standard library, since the Y component is shared
-// between adjacent pixels.
+// between adjacent pixels.  Pix always starts with a whole pair, so if
+// Rect.Min.X is odd the first two bytes belong to the pixel to its left.
 func (img *YUYV) PixOffset(x, y int) int {
-	return (y-img.Rect.Min.Y)*img.Stride + (x-img.Rect.Min.X)*yuyvBytesPP
+	return (y-img.Rect.Min.Y)*img.Stride + (x-pairFloor(img.Rect.Min.X))*yuyvBytesPP
 }
 
 // At returns the pixel at (x,y).  While the operation itself is quite fast,
@@ -81,40 +97,54 @@ func (img *YUYV) GetStride() int {
 	return img.Stride
 }
 
-// NewYUYVFromYCbCrMinZP returns a new YUYV using img as input.  This is
-// a relatively efficient conversion.
+// NewYUYVFromYCbCrMinZP returns a new YUYV using img as input, which must be
+// 4:2:2.  This is a relatively efficient conversion.
 func NewYUYVFromYCbCrMinZP(img *image.YCbCr) *YUYV {
 	if img.Rect.Min != image.ZP {
 		panic("ToYCbCrMinZp does not work for subimages")
 	}
+	if img.SubsampleRatio != image.YCbCrSubsampleRatio422 {
+		panic("NewYUYVFromYCbCrMinZP only supports 4:2:2")
+	}
 	ret := NewYUYV(img.Rect)
-	p := 0
-	for i := 0; i < len(img.Y); i += 2 {
-		ret.Pix[p+0] = img.Y[i]
-		ret.Pix[p+1] = img.Cb[i/2]
-		ret.Pix[p+2] = img.Y[i+1]
-		ret.Pix[p+3] = img.Cr[i/2]
-		p += 4
+	w := img.Rect.Dx()
+	for y := 0; y < img.Rect.Dy(); y++ {
+		p, yi, ci := ret.PixOffset(0, y), y*img.YStride, y*img.CStride
+		for x := 0; x < w; x += 2 {
+			ret.Pix[p+0] = img.Y[yi]
+			ret.Pix[p+1] = img.Cb[ci]
+			if x+1 < w {
+				ret.Pix[p+2] = img.Y[yi+1]
+			}
+			ret.Pix[p+3] = img.Cr[ci]
+			p += 4
+			yi += 2
+			ci++
+		}
 	}
 	return ret
 }
 
-// ToYCbCrMinZp returns a new image.YCbCr by converting from img.  This is a relatively efficient conversion.
+// ToYCbCrMinZp returns a new 4:2:2 image.YCbCr by converting from img.  This is a relatively efficient conversion.
 func (img *YUYV) ToYCbCrMinZp() *image.YCbCr {
 	if img.Rect.Min != image.ZP {
 		panic("ToYCbCrMinZp does not work for subimages")
 	}
 	ycbcr := image.NewYCbCr(img.Rect, image.YCbCrSubsampleRatio422)
-	i, icbcr, iy := 0, 0, 0
-	for iy < len(ycbcr.Y) {
-		y1, b, y2, r := img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]
-		ycbcr.Cb[icbcr] = b
-		ycbcr.Cr[icbcr] = r
-		ycbcr.Y[iy] = y1
-		ycbcr.Y[iy+1] = y2
-		icbcr++
-		iy += 2
-		i += 4
+	w := img.Rect.Dx()
+	for y := 0; y < img.Rect.Dy(); y++ {
+		i, iy, icbcr := img.PixOffset(0, y), y*ycbcr.YStride, y*ycbcr.CStride
+		for x := 0; x < w; x += 2 {
+			ycbcr.Y[iy] = img.Pix[i]
+			ycbcr.Cb[icbcr] = img.Pix[i+1]
+			if x+1 < w {
+				ycbcr.Y[iy+1] = img.Pix[i+2]
+			}
+			ycbcr.Cr[icbcr] = img.Pix[i+3]
+			icbcr++
+			iy += 2
+			i += 4
+		}
 	}
 
 	return ycbcr
@@ -126,30 +156,58 @@ func (img *YUYV) ToYCbCrMinZp() *image.YCbCr {
 // in the image.
 func (img *YUYV) GetRow(y int, dest interface{}) {
 	width := img.Rect.Dx()
-	ret := dest.([]color.YCbCr)[:0]
+	ret := dest.([]color.YCbCr)[:width]
 	i := img.PixOffset(img.Rect.Min.X, y)
-	end := i + width*yuyvBytesPP
-
-	for i < end {
+	x := 0
+	if img.Rect.Min.X%2 != 0 && width > 0 {
+		// The row starts with the second pixel of a pair.
+		ret[0] = color.YCbCr{img.Pix[i], img.Pix[i-1], img.Pix[i+1]}
+		i += 2
+		x++
+	}
+	for ; x+1 < width; x += 2 {
 		y1, b, y2, r := img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]
-		ret = append(ret, color.YCbCr{y1, b, r}, color.YCbCr{y2, b, r})
+		ret[x], ret[x+1] = color.YCbCr{y1, b, r}, color.YCbCr{y2, b, r}
 		i += 4
 	}
+	if x < width {
+		ret[x] = color.YCbCr{img.Pix[i], img.Pix[i+1], img.Pix[i+3]}
+	}
 }
 
 // SetRow fills row y using the slice of color.YCbCr in src.  As with GetRow(), this is
 // better than making many calls to At() but still somewhat slower than doing a full
 // conversion.
+// Pairs get the average of the chroma of their two pixels; where only one
+// pixel of a pair is in the image, its chroma is used as is.
 func (img *YUYV) SetRow(y int, src interface{}) {
-	pix := img.Pix[img.PixOffset(img.Rect.Min.X, y):]
-	o := 0
-
 	cols := src.([]color.YCbCr)
-	for i := 0; i < len(cols); i += 2 {
+	if len(cols) > img.Rect.Dx() {
+		cols = cols[:img.Rect.Dx()]
+	}
+	pix := img.Pix
+	o := img.PixOffset(img.Rect.Min.X, y)
+	i := 0
+	if img.Rect.Min.X%2 != 0 && len(cols) > 0 {
+		c := cols[0]
+		pix[o-1], pix[o], pix[o+1] = c.Cb, c.Y, c.Cr
+		o += 2
+		i++
+	}
+	for ; i+1 < len(cols); i += 2 {
 		c1, c2 := cols[i], cols[i+1]
-		pix[o], pix[o+1], pix[o+2], pix[o+3] = c1.Y, c1.Cb|c2.Cb, c2.Y, c1.Cr|c2.Cr
+		pix[o], pix[o+1], pix[o+2], pix[o+3] = c1.Y, avg8(c1.Cb, c2.Cb), c2.Y, avg8(c1.Cr, c2.Cr)
 		o += 4
 	}
+	if i < len(cols) {
+		c := cols[i]
+		pix[o], pix[o+1], pix[o+3] = c.Y, c.Cb, c.Cr
+	}
+}
+
+// avg8 returns the mean of a and b, rounding halves up.
+func avg8(a, b uint8) uint8 {
+	return uint8((int(a) + int(b) + 1) / 2)
 }
 
 // ToRGBGeneric is a fairly fast generic convertor to arbitrary packed RGB
@@ -185,18 +243,27 @@ func (img *YUYV) ToRGBMinZp(dest []byte, or, og, ob, xs int) {
 		panic("ToRGBMinZp only supports images with Rect.Min = (0,0)")
 	}
 
-	j := 0
-	for i := 0; i+3 < len(img.Pix); i += 4 {
-		y1, cb, y2, cr := img.Pix[i+0], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]
-		r1, g1, b1 := color.YCbCrToRGB(y1, cb, cr)
-		r2, g2, b2 := color.YCbCrToRGB(y2, cb, cr)
-		dest[j+or], dest[j+og], dest[j+ob] = uint8(r1), uint8(g1), uint8(b1)
-		dest[j+xs+or], dest[j+xs+og], dest[j+xs+ob] = uint8(r2), uint8(g2), uint8(b2)
-		if xs == 4 {
-			dest[j+3] = 0xFF
-			dest[j+xs+3] = 0xFF
+	j, w := 0, img.Rect.Dx()
+	for y := 0; y < img.Rect.Dy(); y++ {
+		i := y * img.Stride
+		for x := 0; x < w; x += 2 {
+			y1, cb, y2, cr := img.Pix[i+0], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3]
+			r1, g1, b1 := color.YCbCrToRGB(y1, cb, cr)
+			dest[j+or], dest[j+og], dest[j+ob] = uint8(r1), uint8(g1), uint8(b1)
+			if xs == 4 {
+				dest[j+3] = 0xFF
+			}
+			j += xs
+			if x+1 < w {
+				r2, g2, b2 := color.YCbCrToRGB(y2, cb, cr)
+				dest[j+or], dest[j+og], dest[j+ob] = uint8(r2), uint8(g2), uint8(b2)
+				if xs == 4 {
+					dest[j+3] = 0xFF
+				}
+				j += xs
+			}
+			i += 4
 		}
-		j += xs + xs
 	}
 }
 
@@ -210,7 +277,7 @@ func (img *YUYV) SubImage(r image.Rectangle) image.Image {
 	if r.Empty() {
 		return &YUYV{}
 	}
-	i := img.PixOffset(r.Min.X, r.Min.Y)
+	i := img.PixOffset(pairFloor(r.Min.X), r.Min.Y)
 	return &YUYV{
 		Pix:    img.Pix[i:],
 		Stride: img.Stride,
@@ -230,8 +297,12 @@ func (img *YUYV) StrictSubImage(r image.Rectangle) image.Image {
 	if r.Empty() {
 		return &YUYV{}
 	}
-	i := img.PixOffset(r.Min.X, r.Min.Y)
-	endi := img.PixOffset(r.Max.X, r.Max.Y)
+	i := img.PixOffset(pairFloor(r.Min.X), r.Min.Y)
+	endi := img.PixOffset(pairCeil(r.Max.X), r.Max.Y-1)
+	if endi > len(img.Pix) {
+		// Unpadded images with an odd width lack the last pair's second pixel.
+		endi = len(img.Pix)
+	}
 	return &YUYV{
 		Pix:    img.Pix[i:endi],
 		Stride: img.Stride,
@@ -264,3 +335,45 @@ func (img *YUYV) LoadRaw(path string) error {
 		return img.loadRaw(file)
 	}
 }
+
+// NewYUYVFromYCbCr returns a new YUYV using img as input, which may use any
+// subsampling ratio.  Unlike NewYUYVFromYCbCrMinZP it works with subimages,
+// but it's slower.  Chroma samples shared by a pixel pair are averaged.  The
+// output bounds start at (0,0).
+func NewYUYVFromYCbCr(img *image.YCbCr) *YUYV {
+	ret := NewYUYV(image.Rect(0, 0, img.Rect.Dx(), img.Rect.Dy()))
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		p := ret.PixOffset(0, y-img.Rect.Min.Y)
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x += 2 {
+			yi := img.YOffset(x, y)
+			ci1, ci2 := img.COffset(x, y), img.COffset(x, y)
+			ret.Pix[p+0] = img.Y[yi]
+			if x+1 < img.Rect.Max.X {
+				ci2 = img.COffset(x+1, y)
+				ret.Pix[p+2] = img.Y[yi+1]
+			}
+			ret.Pix[p+1] = avg8(img.Cb[ci1], img.Cb[ci2])
+			ret.Pix[p+3] = avg8(img.Cr[ci1], img.Cr[ci2])
+			p += 4
+		}
+	}
+	return ret
+}
+
+// ToYCbCr returns a new image.YCbCr with the given subsampling ratio by
+// converting from img.  Where the output has fewer chroma samples than img,
+// they're averaged.  The output bounds start at (0,0).
+func (img *YUYV) ToYCbCr(ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	ret := image.NewYCbCr(image.Rect(0, 0, img.Rect.Dx(), img.Rect.Dy()), ratio)
+	acc := newChromaAccumulator(ret)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			dx, dy := x-img.Rect.Min.X, y-img.Rect.Min.Y
+			cb, cr := img.yuyvChroma(x, y)
+			ret.Y[ret.YOffset(dx, dy)] = img.Pix[img.PixOffset(x, y)]
+			acc.add(ret.COffset(dx, dy), cb, cr)
+		}
+	}
+	acc.store(ret)
+	return ret
+}
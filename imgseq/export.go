@@ -0,0 +1,288 @@
+package imgseq
+
+import (
+	"code.google.com/p/ncabatoff/imglib"
+	"code.google.com/p/ncabatoff/imglib/imgdraw"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ExportOptions controls ExportAVI, ExportGIF and ExportPipe.
+type ExportOptions struct {
+	// Overlay outlines the motion rects recorded in each image's Metadata.
+	Overlay bool
+	// FPS is the frame rate used if the images don't have increasing
+	// CreationTs timestamps to go by; zero means 10.
+	FPS float64
+	// MaxGap bounds the time an image is shown for, so that the long quiet
+	// periods in a capture of motion don't become minutes of frozen video.
+	// Zero means a second.
+	MaxGap time.Duration
+	// Quality is the JPEG quality of AVI frames; zero means the default.
+	Quality int
+}
+
+func (opts ExportOptions) fps() float64 {
+	if opts.FPS <= 0 {
+		return 10
+	}
+	return opts.FPS
+}
+
+func (opts ExportOptions) maxGap() time.Duration {
+	if opts.MaxGap <= 0 {
+		return time.Second
+	}
+	return opts.MaxGap
+}
+
+// OutlineMotion returns img with the motion rects recorded in its Metadata
+// outlined, on a copy so that img is untouched.  It returns img itself if
+// there are none.
+func OutlineMotion(img Img) Img {
+	ii := img.GetImgInfo()
+	if ii.Meta == nil || len(ii.Meta.Rects) == 0 {
+		return img
+	}
+	outlined := imglib.Crop(img.GetImage(), img.GetImage().Bounds())
+	imgdraw.Rects(outlined, ii.Meta.Rects, 1, color.White)
+	return &RawImg{ii, imglib.GetPixelSequence(outlined)}
+}
+
+// exportImage returns the ith image of seq as opts says it should be shown.
+func exportImage(seq Sequence, i int, opts ExportOptions) (image.Image, error) {
+	img, err := seq.At(i)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Overlay {
+		img = OutlineMotion(img)
+	}
+	return img.GetImage(), nil
+}
+
+// exportPeriod returns the frame period to export seq at: the median interval
+// between its images, ignoring gaps longer than opts.MaxGap, or 1/opts.FPS if
+// they're not timestamped.
+func exportPeriod(seq Sequence, opts ExportOptions) time.Duration {
+	var gaps []time.Duration
+	for i := 1; i < seq.Len(); i++ {
+		prev, cur := seq.Info(i-1).CreationTs, seq.Info(i).CreationTs
+		if prev.IsZero() || cur.Before(prev) {
+			gaps = nil
+			break
+		}
+		if gap := cur.Sub(prev); gap > 0 && gap <= opts.maxGap() {
+			gaps = append(gaps, gap)
+		}
+	}
+	if len(gaps) == 0 {
+		return time.Duration(float64(time.Second) / opts.fps())
+	}
+	// Prefer the lower median, so that irregular images are placed more
+	// accurately rather than dropped.
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	return gaps[(len(gaps)-1)/2]
+}
+
+// schedule returns how many frame periods each image of seq is shown for when
+// exported at a constant frame rate, so that each appears at its CreationTs
+// relative to the first, with gaps shortened to opts.MaxGap.  Images whose
+// time comes before the next period starts are dropped, getting zero.  If the
+// images aren't timestamped each is shown for one period.
+func schedule(seq Sequence, period time.Duration, opts ExportOptions) []int {
+	n := seq.Len()
+	counts := make([]int, n)
+	var elapsed time.Duration
+	slot := func() int64 { return int64((elapsed + period/2) / period) }
+	prevSlot := int64(0)
+	for i := 0; i < n; i++ {
+		counts[i] = 1
+		if i == 0 {
+			continue
+		}
+		prev, cur := seq.Info(i-1).CreationTs, seq.Info(i).CreationTs
+		if prev.IsZero() || cur.Before(prev) {
+			for j := range counts {
+				counts[j] = 1
+			}
+			return counts
+		}
+		gap := cur.Sub(prev)
+		if gap > opts.maxGap() {
+			gap = opts.maxGap()
+		}
+		elapsed += gap
+		s := slot()
+		counts[i-1] = int(s - prevSlot)
+		prevSlot = s
+	}
+	return counts
+}
+
+// ExportAVI writes the images of seq to w as a Motion JPEG AVI file, which is
+// finished but not closed.  The frame rate is chosen by exportPeriod, and
+// images are repeated or dropped so that each is shown at the right time.
+// All the images must be the same size.
+func ExportAVI(w io.WriteSeeker, seq Sequence, opts ExportOptions) error {
+	if seq.Len() == 0 {
+		return fmt.Errorf("no images to export")
+	}
+	period := exportPeriod(seq, opts)
+	var aw *imglib.AVIWriter
+	for i, count := range schedule(seq, period, opts) {
+		if count == 0 {
+			continue
+		}
+		img, err := exportImage(seq, i, opts)
+		if err != nil {
+			return err
+		}
+		if aw == nil {
+			sz := img.Bounds().Size()
+			if aw, err = imglib.NewAVIWriter(w, sz.X, sz.Y, period, opts.Quality); err != nil {
+				return err
+			}
+		}
+		if err := aw.WriteImage(img); err != nil {
+			return fmt.Errorf("image %d: %v", i, err)
+		}
+		for ; count > 1; count-- {
+			if err := aw.Repeat(); err != nil {
+				return err
+			}
+		}
+	}
+	return aw.Close()
+}
+
+// ExportGIF writes the images of seq to w as an animated GIF, each shown for
+// as long as its CreationTs says, with gaps shortened to opts.MaxGap; the
+// delays are in hundredths of a second, so images less than that apart are
+// dropped.  The images are dithered to the Plan 9 palette, and all held in
+// memory until the end, so GIF only suits short clips.
+func ExportGIF(w io.Writer, seq Sequence, opts ExportOptions) error {
+	if seq.Len() == 0 {
+		return fmt.Errorf("no images to export")
+	}
+	period := exportPeriod(seq, opts)
+	// Browsers treat delays below 2 as 10, so don't go faster than 50fps.
+	cs := int((period + 5*time.Millisecond) / (10 * time.Millisecond))
+	if cs < 2 {
+		cs = 2
+	}
+	anim := &gif.GIF{}
+	for i, count := range schedule(seq, time.Duration(cs)*10*time.Millisecond, opts) {
+		if count == 0 {
+			continue
+		}
+		img, err := exportImage(seq, i, opts)
+		if err != nil {
+			return err
+		}
+		b := img.Bounds()
+		pal := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.Plan9)
+		draw.FloydSteinberg.Draw(pal, pal.Bounds(), img, b.Min)
+		anim.Image = append(anim.Image, pal)
+		anim.Delay = append(anim.Delay, count*cs)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+// ffmpegPixFmts gives the names encoders use for our pixel formats.
+var ffmpegPixFmts = map[imglib.PixelFormat]string{
+	imglib.PixelFormatYUYV: "yuyv422",
+	imglib.PixelFormatRGB:  "rgb24",
+	imglib.PixelFormatRGBA: "rgba",
+	imglib.PixelFormatGray: "gray",
+}
+
+// EncoderArgs returns the arguments telling an ffmpeg-compatible encoder
+// that its input is a stream on stdin of raw frames laid out as rf, shown for
+// period each.
+func EncoderArgs(rf imglib.RawFormat, period time.Duration) ([]string, error) {
+	pixfmt, ok := ffmpegPixFmts[rf.Format]
+	if !ok {
+		return nil, fmt.Errorf("no encoder pixel format for %v", rf.Format)
+	}
+	rate := strconv.FormatInt(int64(time.Second/time.Microsecond), 10) + "/" + strconv.FormatInt(int64(period/time.Microsecond), 10)
+	return []string{"-f", "rawvideo", "-pix_fmt", pixfmt, "-s", fmt.Sprintf("%dx%d", rf.Width, rf.Height),
+		"-r", rate, "-i", "-"}, nil
+}
+
+// ExportPipe runs the encoder name, e.g. "ffmpeg", with EncoderArgs
+// describing the images of seq followed by args, which give the output, and
+// writes the images to its stdin as raw frames, timed as for ExportAVI.  The
+// layout of the frames is that of the first image; all the others must match
+// it.
+func ExportPipe(seq Sequence, opts ExportOptions, name string, args ...string) error {
+	if seq.Len() == 0 {
+		return fmt.Errorf("no images to export")
+	}
+	period := exportPeriod(seq, opts)
+	counts := schedule(seq, period, opts)
+	first, err := exportImage(seq, 0, opts)
+	if err != nil {
+		return err
+	}
+	ps := imglib.GetPixelSequence(first)
+	rf := imglib.RawFormat{Format: imglib.GetPixelFormat(ps.ImageBytes), Width: ps.Dx, Height: ps.Dy}
+	encargs, err := EncoderArgs(rf, period)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(name, append(encargs, args...)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	werr := writeRawFrames(stdin, seq, counts, rf, opts)
+	if cerr := stdin.Close(); werr == nil {
+		werr = cerr
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %v", name, err)
+	}
+	return werr
+}
+
+// writeRawFrames writes the images of seq to w packed as rf, each repeated
+// counts times.
+func writeRawFrames(w io.Writer, seq Sequence, counts []int, rf imglib.RawFormat, opts ExportOptions) error {
+	frame := make([]byte, 0, rf.FrameSize())
+	for i, count := range counts {
+		if count == 0 {
+			continue
+		}
+		img, err := exportImage(seq, i, opts)
+		if err != nil {
+			return err
+		}
+		ps := imglib.GetPixelSequence(img)
+		if pf := imglib.GetPixelFormat(ps.ImageBytes); pf != rf.Format || ps.Dx != rf.Width || ps.Dy != rf.Height {
+			return fmt.Errorf("image %d is %v %dx%d, expected %v", i, pf, ps.Dx, ps.Dy, rf)
+		}
+		frame = frame[:0]
+		for y := 0; y < ps.Dy; y++ {
+			frame = append(frame, ps.Row(y)...)
+		}
+		for ; count > 0; count-- {
+			if _, err := w.Write(frame); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package imgseq
+
+import . "gopkg.in/check.v1"
+
+import "bytes"
+import "code.google.com/p/ncabatoff/imglib"
+import "encoding/binary"
+import "image"
+import "image/gif"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "strings"
+import "time"
+
+// timedSequence returns a SliceSequence of testYuyvs(len(offsets)) created at
+// the given offsets from t0, in milliseconds, or with no timestamps if t0 is
+// zero.
+func timedSequence(t0 time.Time, offsets ...int) SliceSequence {
+	var ss SliceSequence
+	for i, img := range testYuyvs(len(offsets)) {
+		ii := ImgInfo{SeqNum: i}
+		if !t0.IsZero() {
+			ii.CreationTs = t0.Add(time.Duration(offsets[i]) * time.Millisecond)
+		}
+		ss = append(ss, &RawImg{ii, imglib.GetPixelSequence(img)})
+	}
+	return ss
+}
+
+func (s *MySuite) TestSchedule(c *C) {
+	t0 := time.Unix(1400000000, 0)
+	seq := timedSequence(t0, 0, 100, 200, 210, 400, 5000)
+	opts := ExportOptions{}
+	period := exportPeriod(seq, opts)
+	c.Check(period, Equals, 100*time.Millisecond)
+	// The image at 210ms replaces the one at 200ms, the one at 400ms gets the
+	// slots at 300ms too, and the gap to 5s is shortened to a second.
+	c.Check(schedule(seq, period, opts), DeepEquals, []int{1, 1, 0, 2, 10, 1})
+
+	seq = timedSequence(time.Time{}, 0, 0, 0)
+	c.Check(exportPeriod(seq, ExportOptions{FPS: 25}), Equals, 40*time.Millisecond)
+	c.Check(schedule(seq, 40*time.Millisecond, opts), DeepEquals, []int{1, 1, 1})
+}
+
+func (s *MySuite) TestExportAVI(c *C) {
+	path := filepath.Join(c.MkDir(), "test"+imglib.AVIExt)
+	file, err := os.Create(path)
+	c.Assert(err, IsNil)
+	seq := timedSequence(time.Unix(1400000000, 0), 0, 100, 300)
+	c.Assert(ExportAVI(file, seq, ExportOptions{}), IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(string(data[24:28]), Equals, "avih")
+	c.Check(binary.LittleEndian.Uint32(data[32:]), Equals, uint32(100000))
+	c.Check(binary.LittleEndian.Uint32(data[48:]), Equals, uint32(4))
+
+	c.Check(ExportAVI(file, SliceSequence{}, ExportOptions{}), NotNil)
+}
+
+func (s *MySuite) TestExportGIF(c *C) {
+	seq := timedSequence(time.Unix(1400000000, 0), 0, 100, 300)
+	md := &Metadata{Rects: []image.Rectangle{image.Rect(0, 0, 4, 2)}}
+	seq[0].(*RawImg).Meta = md
+	var buf bytes.Buffer
+	c.Assert(ExportGIF(&buf, seq, ExportOptions{Overlay: true}), IsNil)
+	anim, err := gif.DecodeAll(&buf)
+	c.Assert(err, IsNil)
+	c.Check(anim.Delay, DeepEquals, []int{10, 20, 10})
+	c.Assert(anim.Image, HasLen, 3)
+	// The overlay outlines the whole of the first image in white.
+	r, g, b, _ := anim.Image[0].At(0, 0).RGBA()
+	c.Check([]uint32{r >> 8, g >> 8, b >> 8}, DeepEquals, []uint32{0xff, 0xff, 0xff})
+	r, _, _, _ = anim.Image[1].At(0, 0).RGBA()
+	c.Check(r>>8 < 0xf0, Equals, true)
+	c.Check(seq[0].GetImage(), DeepEquals, testYuyvs(1)[0])
+}
+
+func (s *MySuite) TestExportPipe(c *C) {
+	dir := c.MkDir()
+	rf := imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2}
+	args, err := EncoderArgs(rf, 40*time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Check(strings.Join(args, " "), Equals, "-f rawvideo -pix_fmt yuyv422 -s 4x2 -r 1000000/40000 -i -")
+
+	// A stand-in for the encoder records its arguments and input.
+	encoder := filepath.Join(dir, "encoder")
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat > " + filepath.Join(dir, "frames") + "\n"
+	c.Assert(ioutil.WriteFile(encoder, []byte(script), 0755), IsNil)
+	seq := timedSequence(time.Unix(1400000000, 0), 0, 40, 120)
+	c.Assert(ExportPipe(seq, ExportOptions{}, encoder, "out.mp4"), IsNil)
+	got, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	c.Assert(err, IsNil)
+	c.Check(string(got), Equals, strings.Join(args, " ")+" out.mp4\n")
+	frames, err := ioutil.ReadFile(filepath.Join(dir, "frames"))
+	c.Assert(err, IsNil)
+	imgs := testYuyvs(3)
+	c.Check(frames, DeepEquals, bytes.Join([][]byte{imgs[0].Pix, imgs[1].Pix, imgs[1].Pix, imgs[2].Pix}, nil))
+
+	c.Check(ExportPipe(seq, ExportOptions{}, filepath.Join(dir, "missing")), NotNil)
+}
@@ -1,23 +1,27 @@
 // imgseq contains a few helper tools as well as the local image type Img.
 package imgseq
 
+import "context"
 import "path/filepath"
 import "fmt"
 import "image"
 import "os"
 import "time"
-import "sort"
 import "code.google.com/p/ncabatoff/imglib"
 import "github.com/golang/glog"
 
 var defaultPrefix = "test"
 
 // ImgInfo identifies images by providing them a unique id, a timestamp, and an
-// optional path to the file if any.
+// optional path to the file if any.  Stats is nil unless someone has asked for
+// them, e.g. using WithStats.  Meta is nil unless metadata was recorded for
+// the image in a MetadataFile.
 type ImgInfo struct {
 	SeqNum     int
 	CreationTs time.Time
 	Path       string
+	Stats      *imglib.Stats
+	Meta       *Metadata
 }
 
 // Img is a wrapper for image.Image, imglib.PixelSequence, and ImgInfo.  The
@@ -49,28 +53,26 @@ func (r *RawImg) GetImgInfo() ImgInfo {
 	return r.ImgInfo
 }
 
-// DirList holds a directory path and a list of its unqualified files.
+// WithStats returns an Img sharing pixels with img whose ImgInfo has Stats
+// computed over the whole image.
+func WithStats(img Img) Img {
+	ii := img.GetImgInfo()
+	ii.Stats = imglib.GetStats(img.GetImage())
+	return &RawImg{ImgInfo: ii, PixelSequence: img.GetPixelSequence()}
+}
+
+// DirList holds a directory path and a list of its unqualified files, and
+// the scheme they're named by, which is DefaultNameScheme if zero.
 type DirList struct {
 	Path string
 	Files []string
+	Names NameScheme
 }
 
-// GetDirList reads a directory and returns its contents excluding files without extensions.
+// GetDirList reads a directory and returns its contents excluding files without
+// extensions.  Use ScanDir for more control.
 func GetDirList(dir string) (DirList, error) {
-	if fd, err := os.Open(dir); err != nil {
-		return DirList{}, err
-	} else if fs, err := fd.Readdirnames(0); err != nil {
-		return DirList{}, err
-	} else {
-		dl := DirList{Path: dir, Files: make([]string, len(fs))}
-		for i, f := range fs {
-			if filepath.Ext(f) != "" {
-				dl.Files[i] = f
-			}
-		}
-		sort.Sort(sort.StringSlice(dl.Files))
-		return dl, nil
-	}
+	return ScanDir(dir, ScanOptions{})
 }
 
 // Fqfns returns the files fully qualified.
@@ -83,51 +85,158 @@ func (dl DirList) Fqfns() []string {
 }
 
 // ImgInfos returns the ImgInfo for each file in the DirList, if possible
-// obtaining the CreationTs from the filename using TimeFromFname.
+// obtaining the CreationTs from the filename using dl.Names.  SeqNum is the
+// index in Files unless the names include sequence numbers.  Meta comes from
+// the MetadataFile in each file's directory.
 func (dl DirList) ImgInfos() []ImgInfo {
+	ns := dl.Names
+	if ns == (NameScheme{}) {
+		ns = DefaultNameScheme
+	}
 	ret := make([]ImgInfo, len(dl.Files))
+	mc := make(metadataCache)
 	for i := range dl.Files {
 		ret[i] = ImgInfo{Path: filepath.Join(dl.Path, dl.Files[i])}
 		ret[i].SeqNum = i
-		if t := TimeFromFname(defaultPrefix, dl.Files[i]); t != nil {
-			ret[i].CreationTs = *t
+		if seqnum, t, ok := ns.Parse(dl.Files[i]); ok {
+			ret[i].CreationTs = t
+			if ns.SeqDigits > 0 {
+				ret[i].SeqNum = seqnum
+			}
 		}
+		ret[i].Meta = mc.get(ret[i].Path, ret[i].SeqNum)
 	}
 	return ret
 }
 
 // TimeToFname returns the suggested filename for pix given creation time t and prefix pfx.
 func TimeToFname(pfx string, t time.Time) string {
-	return fmt.Sprintf("%s%d", pfx, t.UnixNano())
+	return NameScheme{Prefix: pfx}.Fname(0, t)
 }
 
 // TimeFromFname is the inverse of TimeToFname.  It returns nil if the fname
 // can't have come from TimeToFname with the given pfx.
 func TimeFromFname(pfx string, fname string) *time.Time {
-	epochNanos := int64(0)
-	if _, err := fmt.Sscanf(fname, pfx + "%d", &epochNanos); err != nil {
-		return nil
+	if _, t, ok := (NameScheme{Prefix: pfx}).Parse(fname); ok {
+		return &t
+	}
+	return nil
+}
+
+// LoadRawImg returns an Img for ii, loading its pixels from ii.Path.
+func LoadRawImg(ii ImgInfo) (Img, error) {
+	if ps, err := imglib.LoadPixelSequence(ii.Path); err != nil {
+		return nil, fmt.Errorf("error loading image '%s': %v", ii.Path, err)
+	} else {
+		return &RawImg{ImgInfo: ii, PixelSequence: ps}, nil
 	}
-	t := time.Unix(0, epochNanos)
-	return &t
 }
 
 // LoadRawImgOrDie returns an Img for ii, calling glog.Fatalf on failure.
 func LoadRawImgOrDie(ii ImgInfo) Img {
-	if ps, err := imglib.LoadPixelSequence(ii.Path); err != nil {
-		glog.Fatalf("error loading image '%s': %v", ii.Path, err)
+	img, err := LoadRawImg(ii)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+	return img
+}
+
+// ErrorPolicy says what LoadRawImgs does with an image it can't load.
+type ErrorPolicy int
+
+const (
+	// StopOnError stops loading and returns the error.
+	StopOnError ErrorPolicy = iota
+	// SkipOnError logs the error and carries on with the next image.
+	SkipOnError
+	// QuarantineOnError is like SkipOnError but also moves the file into
+	// QuarantineDir so that it won't be tried again.
+	QuarantineOnError
+)
+
+func (p ErrorPolicy) String() string {
+	switch p {
+	case StopOnError:
+		return "stop"
+	case SkipOnError:
+		return "skip"
+	case QuarantineOnError:
+		return "quarantine"
+	}
+	return fmt.Sprintf("ErrorPolicy(%d)", int(p))
+}
+
+// ParseErrorPolicy is the inverse of ErrorPolicy.String.
+func ParseErrorPolicy(s string) (ErrorPolicy, error) {
+	for _, p := range []ErrorPolicy{StopOnError, SkipOnError, QuarantineOnError} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return StopOnError, fmt.Errorf("unknown error policy '%s'", s)
+}
+
+// QuarantineDir is the subdirectory corrupt images are moved into.  It has no
+// extension so GetDirList skips it.
+const QuarantineDir = "quarantine"
+
+// Quarantine moves the file at path into QuarantineDir in its directory,
+// creating that if need be.
+func Quarantine(path string) error {
+	qdir := filepath.Join(filepath.Dir(path), QuarantineDir)
+	if err := os.MkdirAll(qdir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(qdir, filepath.Base(path)))
+}
+
+// handle applies p to err, which arose loading ii, returning nil if loading
+// should carry on.
+func (p ErrorPolicy) handle(ii ImgInfo, err error) error {
+	switch p {
+	case SkipOnError:
+		glog.Warningf("skipping: %v", err)
+		return nil
+	case QuarantineOnError:
+		if qerr := Quarantine(ii.Path); qerr != nil {
+			return fmt.Errorf("%v; quarantine failed: %v", err, qerr)
+		}
+		glog.Warningf("quarantined: %v", err)
 		return nil
-	} else {
-		return &RawImg{ImgInfo: ii, PixelSequence: ps}
 	}
+	return err
 }
 
-// LoadRawImgsOrDie calls LoadRawImgsOrDie for each file in dl and sends
-// the images to imagechan.
-func LoadRawImgsOrDie(dl DirList, imagechan chan<- Img) {
-	for _, f := range dl.ImgInfos() {
-		imagechan <- LoadRawImgOrDie(f)
+// LoadRawImgs loads each file in dl in turn and sends the images to
+// imagechan, closing it when done.  Images which can't be loaded are dealt
+// with according to policy.  Loading stops early if ctx is cancelled, in
+// which case ctx.Err() is returned.
+func LoadRawImgs(ctx context.Context, dl DirList, policy ErrorPolicy, imagechan chan<- Img) error {
+	defer close(imagechan)
+	for _, ii := range dl.ImgInfos() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		img, err := LoadRawImg(ii)
+		if err != nil {
+			if err = policy.handle(ii, err); err != nil {
+				return err
+			}
+			continue
+		}
+		select {
+		case imagechan <- img:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
-	close(imagechan)
+	return nil
 }
 
+// LoadRawImgsOrDie is like LoadRawImgs with StopOnError, but calls
+// glog.Fatalf on failure.
+func LoadRawImgsOrDie(dl DirList, imagechan chan<- Img) {
+	if err := LoadRawImgs(context.Background(), dl, StopOnError, imagechan); err != nil {
+		glog.Fatalf("%v", err)
+	}
+}
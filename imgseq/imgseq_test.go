@@ -0,0 +1,231 @@
+package imgseq
+
+import . "gopkg.in/check.v1"
+import "testing"
+
+import "code.google.com/p/ncabatoff/imglib"
+import "context"
+import "image"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "time"
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+// testYuyvs returns n distinct 4x2 YUYV images.
+func testYuyvs(n int) []*imglib.YUYV {
+	ret := make([]*imglib.YUYV, n)
+	for i := range ret {
+		ret[i] = imglib.NewYUYV(image.Rect(0, 0, 4, 2))
+		for j := range ret[i].Pix {
+			ret[i].Pix[j] = uint8(16*i + j)
+		}
+	}
+	return ret
+}
+
+// checkSequence verifies that seq holds imgs, with the ith created at ts[i].
+func checkSequence(c *C, seq Sequence, imgs []*imglib.YUYV, ts []time.Time) {
+	c.Assert(seq.Len(), Equals, len(imgs))
+	for i := range imgs {
+		c.Check(seq.Info(i).CreationTs.Equal(ts[i]), Equals, true, Commentf("%d", i))
+		img, err := seq.At(i)
+		c.Assert(err, IsNil)
+		c.Check(img.GetImgInfo(), DeepEquals, seq.Info(i))
+		c.Check(img.GetImage(), DeepEquals, imgs[i])
+		c.Check(seq.IndexAt(ts[i]), Equals, i)
+	}
+	c.Check(seq.IndexAt(ts[len(ts)-1].Add(time.Nanosecond)), Equals, len(imgs))
+	c.Check(seq.Close(), IsNil)
+}
+
+func (s *MySuite) TestDirSequence(c *C) {
+	dir := c.MkDir()
+	imgs := testYuyvs(3)
+	t0 := time.Unix(1400000000, 0)
+	ts := []time.Time{t0, t0.Add(time.Second), t0.Add(time.Minute)}
+	for i, img := range imgs {
+		c.Assert(img.StoreRaw(filepath.Join(dir, TimeToFname(defaultPrefix, ts[i])+".yuv")), IsNil)
+	}
+	c.Assert(imglib.WriteRawInfo(dir, imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2}), IsNil)
+	seq, err := OpenSequence(dir)
+	c.Assert(err, IsNil)
+	checkSequence(c, seq, imgs, ts)
+	c.Check(seq.IndexAt(t0.Add(time.Millisecond)), Equals, 1)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "test0.yuv"), []byte{1}, 0644), IsNil)
+	dl, err := GetDirList(dir)
+	c.Assert(err, IsNil)
+	_, err = NewDirSequence(dl).At(0)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestFramesSequence(c *C) {
+	path := filepath.Join(c.MkDir(), "test"+imglib.FramesExt)
+	file, err := os.Create(path)
+	c.Assert(err, IsNil)
+	imgs := testYuyvs(2)
+	t0 := time.Unix(1400000000, 5)
+	ts := []time.Time{t0, t0.Add(time.Second)}
+	fw, err := imglib.NewFramesWriter(file, imglib.FramesHeader{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2})
+	c.Assert(err, IsNil)
+	for i, img := range imgs {
+		c.Assert(fw.WriteFrame(ts[i], i, img.Pix), IsNil)
+	}
+	c.Assert(fw.Close(), IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	seq, err := OpenSequence(path)
+	c.Assert(err, IsNil)
+	checkSequence(c, seq, imgs, ts)
+}
+
+func (s *MySuite) TestY4MSequence(c *C) {
+	path := filepath.Join(c.MkDir(), "test"+imglib.Y4MExt)
+	file, err := os.Create(path)
+	c.Assert(err, IsNil)
+	imgs := testYuyvs(3)
+	imagechan := make(chan Img, len(imgs))
+	for _, img := range imgs {
+		imagechan <- &RawImg{PixelSequence: imglib.GetPixelSequence(img)}
+	}
+	close(imagechan)
+	c.Assert(WriteY4M(file, 25, 1, imagechan), IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	seq, err := OpenSequence(path)
+	c.Assert(err, IsNil)
+	// Frames are 40ms apart at 25fps.
+	ts := []time.Time{time.Unix(0, 0), time.Unix(0, 40e6), time.Unix(0, 80e6)}
+	checkSequence(c, seq, imgs, ts)
+
+	// A write error doesn't leave the producer blocked.
+	file, err = os.Create(path)
+	c.Assert(err, IsNil)
+	c.Assert(file.Close(), IsNil)
+	imagechan = make(chan Img)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			imagechan <- &RawImg{PixelSequence: imglib.GetPixelSequence(imglib.NewYUYV(image.Rect(0, 0, 640, 480)))}
+		}
+		close(imagechan)
+		close(done)
+	}()
+	c.Check(WriteY4M(file, 25, 1, imagechan), NotNil)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Error("producer blocked")
+	}
+}
+
+func (s *MySuite) TestRawSequence(c *C) {
+	path := filepath.Join(c.MkDir(), "capture.yuv")
+	imgs := testYuyvs(2)
+	c.Assert(ioutil.WriteFile(path, append(append([]byte(nil), imgs[0].Pix...), imgs[1].Pix...), 0644), IsNil)
+	seq, err := OpenRawSequence(path, imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2})
+	c.Assert(err, IsNil)
+	c.Assert(seq.Len(), Equals, 2)
+	for i := range imgs {
+		img, err := seq.At(i)
+		c.Assert(err, IsNil)
+		c.Check(img.GetImgInfo(), DeepEquals, ImgInfo{SeqNum: i, Path: path})
+		c.Check(img.GetImage(), DeepEquals, imgs[i])
+	}
+	c.Check(seq.Close(), IsNil)
+
+	_, err = OpenSequence(path)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestSliceSequence(c *C) {
+	imgs := testYuyvs(3)
+	t0 := time.Unix(1400000000, 0)
+	ts := []time.Time{t0, t0, t0.Add(time.Second)}
+	var ss SliceSequence
+	for i, img := range imgs {
+		ss = append(ss, &RawImg{ImgInfo{SeqNum: i, CreationTs: ts[i]}, imglib.GetPixelSequence(img)})
+	}
+	c.Check(ss.IndexAt(t0), Equals, 0)
+	c.Check(ss.IndexAt(t0.Add(time.Millisecond)), Equals, 2)
+	ts[1] = t0.Add(time.Millisecond)
+	ss[1].(*RawImg).CreationTs = ts[1]
+	checkSequence(c, ss, imgs, ts)
+}
+
+// writeTestDir writes imgs to dir as raw files, followed by a truncated one
+// which sorts between the first and second.
+func writeTestDir(c *C, dir string, imgs []*imglib.YUYV) {
+	for i, img := range imgs {
+		c.Assert(img.StoreRaw(filepath.Join(dir, TimeToFname(defaultPrefix, time.Unix(0, int64(2*i+2))))+".yuv"), IsNil)
+	}
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "test3.yuv"), []byte{1, 2, 3}, 0644), IsNil)
+	c.Assert(imglib.WriteRawInfo(dir, imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2}), IsNil)
+}
+
+// collect returns the images sent to imagechan by LoadRawImgs.
+func collect(ctx context.Context, dl DirList, policy ErrorPolicy) ([]Img, error) {
+	imagechan := make(chan Img)
+	errchan := make(chan error, 1)
+	go func() { errchan <- LoadRawImgs(ctx, dl, policy, imagechan) }()
+	var ret []Img
+	for img := range imagechan {
+		ret = append(ret, img)
+	}
+	return ret, <-errchan
+}
+
+func (s *MySuite) TestLoadRawImgs(c *C) {
+	dir := c.MkDir()
+	imgs := testYuyvs(3)
+	writeTestDir(c, dir, imgs)
+	dl, err := GetDirList(dir)
+	c.Assert(err, IsNil)
+	c.Assert(dl.Files, HasLen, 4)
+
+	_, err = LoadRawImg(dl.ImgInfos()[1])
+	c.Check(err, ErrorMatches, "error loading image '.*test3.yuv'.*")
+
+	loaded, err := collect(context.Background(), dl, StopOnError)
+	c.Check(err, NotNil)
+	c.Check(loaded, HasLen, 1)
+
+	loaded, err = collect(context.Background(), dl, SkipOnError)
+	c.Check(err, IsNil)
+	c.Assert(loaded, HasLen, 3)
+	for i, img := range loaded {
+		c.Check(img.GetImage(), DeepEquals, imgs[i])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	loaded, err = collect(ctx, dl, SkipOnError)
+	c.Check(err, Equals, context.Canceled)
+	c.Check(loaded, HasLen, 0)
+
+	loaded, err = collect(context.Background(), dl, QuarantineOnError)
+	c.Check(err, IsNil)
+	c.Check(loaded, HasLen, 3)
+	_, err = os.Stat(filepath.Join(dir, QuarantineDir, "test3.yuv"))
+	c.Check(err, IsNil)
+	dl, err = GetDirList(dir)
+	c.Assert(err, IsNil)
+	c.Check(dl.Files, HasLen, 3)
+}
+
+func (s *MySuite) TestErrorPolicy(c *C) {
+	for _, p := range []ErrorPolicy{StopOnError, SkipOnError, QuarantineOnError} {
+		parsed, err := ParseErrorPolicy(p.String())
+		c.Check(err, IsNil)
+		c.Check(parsed, Equals, p)
+	}
+	_, err := ParseErrorPolicy("ignore")
+	c.Check(err, NotNil)
+}
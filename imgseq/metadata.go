@@ -0,0 +1,168 @@
+package imgseq
+
+import (
+	"bufio"
+	"code.google.com/p/ncabatoff/imglib"
+	"encoding/json"
+	"github.com/golang/glog"
+	"image"
+	"os"
+	"path/filepath"
+)
+
+// MetadataFile is the name of the file in a directory recording the Metadata
+// of the images in it.
+const MetadataFile = "metadata"
+
+// Metadata is what's known about an image beyond the basics in ImgInfo.  All
+// of it is optional.
+type Metadata struct {
+	// Camera and Device identify where the image came from, e.g. "frontdoor"
+	// and "/dev/video0".
+	Camera string `json:"camera,omitempty"`
+	Device string `json:"device,omitempty"`
+	// Format, Width and Height describe the image as captured, e.g. "yuyv",
+	// 640 and 480.
+	Format string `json:"format,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	// Rects are the regions in which motion was found.
+	Rects []image.Rectangle `json:"rects,omitempty"`
+	// Tags holds anything else, e.g. "exposure": "1/60".
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// metaRecord is a line of a MetadataFile.  Seq identifies a frame within a
+// container by its SeqNum; it's omitted for files holding a single image.
+type metaRecord struct {
+	File string `json:"file"`
+	Seq  int    `json:"seq,omitempty"`
+	Metadata
+}
+
+type metaKey struct {
+	file string
+	seq  int
+}
+
+// MetadataIndex holds the Metadata read from a MetadataFile.
+type MetadataIndex map[metaKey]*Metadata
+
+// isContainer returns whether the file at path holds many images, going by
+// its extension.
+func isContainer(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == imglib.FramesExt || ext == imglib.Y4MExt
+}
+
+// key returns the key under which the metadata of the image with the given
+// path and seqnum is recorded.
+func key(path string, seqnum int) metaKey {
+	if !isContainer(path) {
+		seqnum = 0
+	}
+	return metaKey{filepath.Base(path), seqnum}
+}
+
+// Get returns the metadata of the image with the given path and SeqNum, or
+// nil if there is none.  The directory of path is ignored.
+func (mi MetadataIndex) Get(path string, seqnum int) *Metadata {
+	return mi[key(path, seqnum)]
+}
+
+// ReadMetadata reads the MetadataFile in dir.  It's not an error for there to
+// be none.  If an image appears more than once the last line wins.  Bad lines,
+// as left by a writer which was interrupted, are logged and skipped, so that
+// one torn record doesn't lose the metadata of the whole directory.
+func ReadMetadata(dir string) (MetadataIndex, error) {
+	mi := make(MetadataIndex)
+	file, err := os.Open(filepath.Join(dir, MetadataFile))
+	if os.IsNotExist(err) {
+		return mi, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec metaRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			glog.Warningf("skipping bad line %d of '%s': %v", line, file.Name(), err)
+			continue
+		}
+		md := rec.Metadata
+		mi[metaKey{rec.File, rec.Seq}] = &md
+	}
+	return mi, scanner.Err()
+}
+
+// AppendMetadata records md as the metadata of the image described by ii, by
+// appending a line to the MetadataFile in the directory of ii.Path.  Anything
+// recorded earlier for the image is superseded.  If an earlier append was cut
+// short, leaving the file without a final newline, the new line is started
+// afresh rather than joined onto the torn one.
+func AppendMetadata(ii ImgInfo, md *Metadata) error {
+	k := key(ii.Path, ii.SeqNum)
+	line, err := json.Marshal(metaRecord{k.file, k.seq, *md})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(filepath.Dir(ii.Path), MetadataFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if torn, err := endsTorn(file); err != nil {
+		file.Close()
+		return err
+	} else if torn {
+		line = append([]byte{'\n'}, line...)
+	}
+	if _, err = file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// endsTorn returns whether file is nonempty and doesn't end in a newline.
+func endsTorn(file *os.File) (bool, error) {
+	fi, err := file.Stat()
+	if err != nil || fi.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, fi.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+// dirMetadata returns the metadata recorded in the directory of path.
+// Errors reading it are logged rather than preventing the images being used.
+func dirMetadata(path string) MetadataIndex {
+	mi, err := ReadMetadata(filepath.Dir(path))
+	if err != nil {
+		glog.Warningf("ignoring metadata: %v", err)
+	}
+	return mi
+}
+
+// metadataCache reads the MetadataFile of each directory once.
+type metadataCache map[string]MetadataIndex
+
+// get returns the metadata of the image with the given path and SeqNum.
+func (mc metadataCache) get(path string, seqnum int) *Metadata {
+	dir := filepath.Dir(path)
+	mi, ok := mc[dir]
+	if !ok {
+		mi = dirMetadata(path)
+		mc[dir] = mi
+	}
+	return mi.Get(path, seqnum)
+}
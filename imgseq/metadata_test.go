@@ -0,0 +1,88 @@
+package imgseq
+
+import . "gopkg.in/check.v1"
+
+import "code.google.com/p/ncabatoff/imglib"
+import "image"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "time"
+
+func (s *MySuite) TestMetadata(c *C) {
+	dir := c.MkDir()
+	imgs := testYuyvs(2)
+	writeTestDir(c, dir, imgs)
+	dl, err := GetDirList(dir)
+	c.Assert(err, IsNil)
+	iinfos := dl.ImgInfos()
+	c.Check(iinfos[0].Meta, IsNil)
+
+	md := &Metadata{Camera: "frontdoor", Format: "yuyv", Width: 4, Height: 2, Tags: map[string]string{"exposure": "1/60"}}
+	c.Assert(AppendMetadata(iinfos[0], md), IsNil)
+	c.Assert(AppendMetadata(iinfos[2], &Metadata{Camera: "backdoor"}), IsNil)
+	md2 := *md
+	md2.Rects = []image.Rectangle{image.Rect(1, 0, 3, 2)}
+	c.Assert(AppendMetadata(iinfos[2], &md2), IsNil)
+
+	iinfos = dl.ImgInfos()
+	c.Check(iinfos[0].Meta, DeepEquals, md)
+	c.Check(iinfos[1].Meta, IsNil)
+	c.Check(iinfos[2].Meta, DeepEquals, &md2)
+	img, err := NewDirSequence(dl).At(2)
+	c.Assert(err, IsNil)
+	c.Check(img.GetImgInfo().Meta, DeepEquals, &md2)
+}
+
+func (s *MySuite) TestMetadataFrames(c *C) {
+	path := filepath.Join(c.MkDir(), "test"+imglib.FramesExt)
+	file, err := os.Create(path)
+	c.Assert(err, IsNil)
+	fw, err := imglib.NewFramesWriter(file, imglib.FramesHeader{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2})
+	c.Assert(err, IsNil)
+	for i, img := range testYuyvs(3) {
+		c.Assert(fw.WriteFrame(time.Unix(0, int64(i)), 10+i, img.Pix), IsNil)
+	}
+	c.Assert(fw.Close(), IsNil)
+	c.Assert(file.Close(), IsNil)
+
+	md := &Metadata{Rects: []image.Rectangle{image.Rect(0, 0, 1, 1)}}
+	c.Assert(AppendMetadata(ImgInfo{SeqNum: 11, Path: path}, md), IsNil)
+	seq, err := OpenSequence(path)
+	c.Assert(err, IsNil)
+	c.Check(seq.Info(0).Meta, IsNil)
+	c.Check(seq.Info(1).Meta, DeepEquals, md)
+	c.Check(seq.Info(2).Meta, IsNil)
+	c.Check(seq.Close(), IsNil)
+}
+
+func (s *MySuite) TestReadMetadata(c *C) {
+	dir := c.MkDir()
+	mi, err := ReadMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Check(mi, HasLen, 0)
+
+	path := filepath.Join(dir, MetadataFile)
+	good := `{"file":"a.yuv","camera":"x"}` + "\n"
+	c.Assert(ioutil.WriteFile(path, []byte(good+`{"file":"b.y`), 0644), IsNil)
+	mi, err = ReadMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Check(mi.Get(filepath.Join(dir, "a.yuv"), 7), DeepEquals, &Metadata{Camera: "x"})
+	c.Check(mi.Get("b.yuv", 0), IsNil)
+
+	// A bad line in the middle is skipped too.
+	c.Assert(ioutil.WriteFile(path, []byte(`{"file":"b.y`+"\n"+good), 0644), IsNil)
+	mi, err = ReadMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Check(mi.Get("a.yuv", 0), DeepEquals, &Metadata{Camera: "x"})
+
+	// Appending after a torn write starts a new line.
+	c.Assert(ioutil.WriteFile(path, []byte(good+`{"file":"b.y`), 0644), IsNil)
+	c.Assert(AppendMetadata(ImgInfo{Path: filepath.Join(dir, "c.yuv")}, &Metadata{Camera: "y"}), IsNil)
+	c.Assert(AppendMetadata(ImgInfo{Path: filepath.Join(dir, "d.yuv")}, &Metadata{Camera: "z"}), IsNil)
+	mi, err = ReadMetadata(dir)
+	c.Assert(err, IsNil)
+	c.Check(mi, HasLen, 3)
+	c.Check(mi.Get("c.yuv", 0), DeepEquals, &Metadata{Camera: "y"})
+	c.Check(mi.Get("d.yuv", 0), DeepEquals, &Metadata{Camera: "z"})
+}
@@ -0,0 +1,155 @@
+package imgseq
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NameScheme describes how image files are named.  A filename consists of
+// Prefix, then Camera and a dash if Camera is set, then the sequence number
+// zero-padded to SeqDigits digits and a dash if SeqDigits is nonzero, and
+// finally the creation time in nanoseconds since the Unix epoch, e.g.
+// "test1400000000123456789" or "testfrontdoor-000042-1400000000123456789".
+// If DateDirs is set files are placed in YYYY/MM/DD/HH subdirectories
+// according to their local creation time, so that no directory gets too big.
+type NameScheme struct {
+	Prefix    string
+	Camera    string
+	SeqDigits int
+	DateDirs  bool
+}
+
+// DefaultNameScheme is the scheme used by TimeToFname with the "test" prefix,
+// which is what capture has always written.
+var DefaultNameScheme = NameScheme{Prefix: defaultPrefix}
+
+// dateDirLayout is the time layout of the subdirectories used by DateDirs.
+const dateDirLayout = "2006/01/02/15"
+
+// Fname returns the name without extension of the image with the given
+// sequence number and creation time.
+func (ns NameScheme) Fname(seqnum int, t time.Time) string {
+	s := ns.Prefix
+	if ns.Camera != "" {
+		s += ns.Camera + "-"
+	}
+	if ns.SeqDigits > 0 {
+		s += fmt.Sprintf("%0*d-", ns.SeqDigits, seqnum)
+	}
+	return s + strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Dir returns the directory, relative to the top of the tree, in which an
+// image created at t belongs: "" unless DateDirs is set.
+func (ns NameScheme) Dir(t time.Time) string {
+	if !ns.DateDirs {
+		return ""
+	}
+	return filepath.FromSlash(t.Format(dateDirLayout))
+}
+
+// Path returns Fname with ext appended, within Dir.
+func (ns NameScheme) Path(seqnum int, t time.Time, ext string) string {
+	return filepath.Join(ns.Dir(t), ns.Fname(seqnum, t)+ext)
+}
+
+// Parse is the inverse of Fname.  Any directory and extension of fname are
+// ignored.  ok is false if fname can't have come from ns; seqnum is zero if
+// ns doesn't include sequence numbers.
+func (ns NameScheme) Parse(fname string) (seqnum int, t time.Time, ok bool) {
+	s := filepath.Base(fname)
+	s = strings.TrimSuffix(s, filepath.Ext(s))
+	if !strings.HasPrefix(s, ns.Prefix+ns.Camera) {
+		return 0, t, false
+	}
+	s = s[len(ns.Prefix+ns.Camera):]
+	if ns.Camera != "" {
+		if !strings.HasPrefix(s, "-") {
+			return 0, t, false
+		}
+		s = s[1:]
+	}
+	if ns.SeqDigits > 0 {
+		dash := strings.IndexByte(s, '-')
+		if dash < ns.SeqDigits || !allDigits(s[:dash]) {
+			return 0, t, false
+		}
+		seqnum, _ = strconv.Atoi(s[:dash])
+		s = s[dash+1:]
+	}
+	if !allDigits(s) {
+		return 0, t, false
+	}
+	nanos, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, t, false
+	}
+	return seqnum, time.Unix(0, nanos), true
+}
+
+func allDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// String returns ns in the form parsed by ParseNameScheme, e.g.
+// "prefix=test,camera=frontdoor,seq=6,datedirs".
+func (ns NameScheme) String() string {
+	fields := []string{"prefix=" + ns.Prefix}
+	if ns.Camera != "" {
+		fields = append(fields, "camera="+ns.Camera)
+	}
+	if ns.SeqDigits > 0 {
+		fields = append(fields, fmt.Sprintf("seq=%d", ns.SeqDigits))
+	}
+	if ns.DateDirs {
+		fields = append(fields, "datedirs")
+	}
+	return strings.Join(fields, ",")
+}
+
+// ParseNameScheme is the inverse of NameScheme.String.  Fields may appear in
+// any order, and an omitted prefix means the default "test".
+func ParseNameScheme(s string) (NameScheme, error) {
+	ns := DefaultNameScheme
+	for _, f := range strings.Split(s, ",") {
+		kv := strings.SplitN(f, "=", 2)
+		switch {
+		case f == "":
+		case kv[0] == "datedirs" && len(kv) == 1:
+			ns.DateDirs = true
+		case len(kv) != 2:
+			return NameScheme{}, fmt.Errorf("bad field '%s' in name scheme '%s'", f, s)
+		case kv[0] == "prefix":
+			ns.Prefix = kv[1]
+		case kv[0] == "camera":
+			if strings.ContainsAny(kv[1], "-/") {
+				return NameScheme{}, fmt.Errorf("camera '%s' may not contain '-' or '/'", kv[1])
+			}
+			ns.Camera = kv[1]
+		case kv[0] == "seq":
+			if n, err := strconv.Atoi(kv[1]); err != nil || n < 0 {
+				return NameScheme{}, fmt.Errorf("bad sequence digits in name scheme '%s'", s)
+			} else {
+				ns.SeqDigits = n
+			}
+		default:
+			return NameScheme{}, fmt.Errorf("unknown field '%s' in name scheme '%s'", f, s)
+		}
+	}
+	return ns, nil
+}
+
+// GetSchemeDirList is like GetDirList but only includes files named according
+// to ns.  If ns.DateDirs is set it reads the subdirectories of dir too, and
+// Files are relative to dir.  Files are sorted by creation time.
+func GetSchemeDirList(dir string, ns NameScheme) (DirList, error) {
+	return ScanDir(dir, ScanOptions{Recursive: ns.DateDirs, Names: ns, NamedOnly: true, ByTime: true})
+}
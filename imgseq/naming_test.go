@@ -0,0 +1,74 @@
+package imgseq
+
+import . "gopkg.in/check.v1"
+
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "time"
+
+func (s *MySuite) TestNameScheme(c *C) {
+	t := time.Unix(1400000000, 123456789)
+	for _, tc := range []struct {
+		ns    NameScheme
+		fname string
+	}{
+		{DefaultNameScheme, "test1400000000123456789"},
+		{NameScheme{Prefix: "img", Camera: "frontdoor"}, "imgfrontdoor-1400000000123456789"},
+		{NameScheme{Prefix: "", SeqDigits: 6}, "000042-1400000000123456789"},
+		{NameScheme{Prefix: "cap_", Camera: "c1", SeqDigits: 3, DateDirs: true}, "cap_c1-042-1400000000123456789"},
+	} {
+		c.Check(tc.ns.Fname(42, t), Equals, tc.fname)
+		seqnum, pt, ok := tc.ns.Parse(tc.ns.Path(42, t, ".yuv"))
+		c.Check(ok, Equals, true, Commentf("%v", tc.ns))
+		c.Check(pt.Equal(t), Equals, true)
+		if tc.ns.SeqDigits > 0 {
+			c.Check(seqnum, Equals, 42)
+		}
+		parsed, err := ParseNameScheme(tc.ns.String())
+		c.Check(err, IsNil)
+		c.Check(parsed, Equals, tc.ns)
+	}
+
+	ns := NameScheme{Prefix: "test", Camera: "c1", SeqDigits: 3}
+	c.Check(ns.Fname(123456, t), Equals, "testc1-123456-1400000000123456789")
+	for _, bad := range []string{"test1400000000123456789", "testc2-001-1", "testc1-01-1", "testc1-001-1x", "testc1-001-", "rawinfo"} {
+		_, _, ok := ns.Parse(bad)
+		c.Check(ok, Equals, false, Commentf("%s", bad))
+	}
+	c.Check(NameScheme{DateDirs: true}.Dir(t), Equals, filepath.FromSlash(t.Format("2006/01/02/15")))
+	c.Check(*TimeFromFname("test", TimeToFname("test", t)+".yuv"), Equals, t)
+	c.Check(TimeFromFname("test", "other1400000000"), IsNil)
+
+	ns, err := ParseNameScheme("seq=4,camera=x")
+	c.Check(err, IsNil)
+	c.Check(ns, Equals, NameScheme{Prefix: "test", Camera: "x", SeqDigits: 4})
+	for _, bad := range []string{"seq=-1", "seq", "camera=a-b", "size=4"} {
+		_, err := ParseNameScheme(bad)
+		c.Check(err, NotNil, Commentf("%s", bad))
+	}
+}
+
+func (s *MySuite) TestSchemeDirList(c *C) {
+	dir := c.MkDir()
+	ns := NameScheme{Prefix: "test", Camera: "c1", SeqDigits: 2, DateDirs: true}
+	t0 := time.Date(2014, 5, 13, 23, 59, 59, 0, time.Local)
+	var want []string
+	for i, t := range []time.Time{t0, t0.Add(time.Second), t0.Add(time.Hour), t0.Add(24 * time.Hour)} {
+		path := ns.Path(10-i, t, ".yuv")
+		want = append(want, path)
+		c.Assert(os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, path), nil, 0644), IsNil)
+	}
+	// Files not matching the scheme are ignored.
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, ns.Dir(t0), "test1.yuv"), nil, 0644), IsNil)
+
+	dl, err := GetSchemeDirList(dir, ns)
+	c.Assert(err, IsNil)
+	c.Check(dl.Files, DeepEquals, want)
+	iinfos := dl.ImgInfos()
+	c.Assert(iinfos, HasLen, 4)
+	c.Check(iinfos[3].SeqNum, Equals, 7)
+	c.Check(iinfos[3].CreationTs.Equal(t0.Add(24*time.Hour)), Equals, true)
+	c.Check(iinfos[3].Path, Equals, filepath.Join(dir, want[3]))
+}
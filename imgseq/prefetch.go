@@ -0,0 +1,224 @@
+package imgseq
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// PrefetchConfig controls a Prefetcher.
+type PrefetchConfig struct {
+	// Ahead and Behind are the number of images after and before the one
+	// last asked for which are loaded in anticipation.
+	Ahead, Behind int
+	// Workers is the number of images loaded concurrently.
+	Workers int
+	// MaxBytes bounds the pixel memory held by the cache.  The number of
+	// images prefetched is reduced if need be to fit within it.
+	MaxBytes int64
+}
+
+// DefaultPrefetchConfig suits stepping through a directory of VGA frames in
+// either direction.
+var DefaultPrefetchConfig = PrefetchConfig{Ahead: 16, Behind: 4, Workers: 4, MaxBytes: 256 << 20}
+
+// load is an image being loaded or in the cache.
+type load struct {
+	i     int
+	img   Img
+	err   error
+	done  chan struct{}
+	bytes int64
+}
+
+// Prefetcher is a Sequence which loads images from another in the background,
+// so that stepping through a directory on a slow disk doesn't stall on each
+// file.  After each call to At the images around it are queued to be loaded
+// by a pool of workers, replacing whatever was still queued from the previous
+// call, so seeking abandons outstanding work.  Loaded images are kept in an
+// LRU cache.  Errors aren't cached: an image which failed to load is tried
+// again next time it's asked for.
+//
+// Prefetching is only worthwhile for sequences like NewDirSequence's which
+// read files in At; the mapped file sequences are already cheap.
+type Prefetcher struct {
+	seq Sequence
+	cfg PrefetchConfig
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	queue      []int
+	pending    map[int]*load
+	cache      map[int]*list.Element
+	lru        *list.List
+	bytes      int64
+	frameBytes int64
+	closed     bool
+	workers    sync.WaitGroup
+}
+
+// NewPrefetcher returns a Prefetcher reading from seq, which it takes
+// ownership of: closing the Prefetcher closes seq.
+func NewPrefetcher(seq Sequence, cfg PrefetchConfig) *Prefetcher {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	p := &Prefetcher{seq: seq, cfg: cfg, pending: make(map[int]*load),
+		cache: make(map[int]*list.Element), lru: list.New()}
+	p.cond = sync.NewCond(&p.mu)
+	p.workers.Add(cfg.Workers)
+	for w := 0; w < cfg.Workers; w++ {
+		go p.worker()
+	}
+	return p
+}
+
+func (p *Prefetcher) Len() int {
+	return p.seq.Len()
+}
+
+func (p *Prefetcher) Info(i int) ImgInfo {
+	return p.seq.Info(i)
+}
+
+func (p *Prefetcher) IndexAt(t time.Time) int {
+	return p.seq.IndexAt(t)
+}
+
+// At returns the ith image, from the cache if it's there, otherwise waiting
+// for it to be loaded.  Either way the images around it are then prefetched.
+func (p *Prefetcher) At(i int) (Img, error) {
+	p.mu.Lock()
+	if e, ok := p.cache[i]; ok {
+		p.lru.MoveToFront(e)
+		p.schedule(i)
+		p.mu.Unlock()
+		return e.Value.(*load).img, nil
+	}
+	l, ok := p.pending[i]
+	if !ok {
+		l = p.start(i)
+	}
+	p.schedule(i)
+	p.mu.Unlock()
+	if !ok {
+		p.finish(l)
+	}
+	<-l.done
+	return l.img, l.err
+}
+
+// start records that image i is being loaded.  p.mu must be held.
+func (p *Prefetcher) start(i int) *load {
+	l := &load{i: i, done: make(chan struct{})}
+	p.pending[i] = l
+	return l
+}
+
+// finish loads l, adds it to the cache and wakes anyone waiting for it.
+func (p *Prefetcher) finish(l *load) {
+	l.img, l.err = p.seq.At(l.i)
+	if l.err == nil {
+		l.bytes = int64(len(l.img.GetPixelSequence().GetBytes()))
+	}
+	p.mu.Lock()
+	delete(p.pending, l.i)
+	if l.err == nil && !p.closed {
+		p.frameBytes = l.bytes
+		p.cache[l.i] = p.lru.PushFront(l)
+		p.bytes += l.bytes
+		p.evict()
+	}
+	p.mu.Unlock()
+	close(l.done)
+}
+
+// evict drops the least recently used images until the cache fits in
+// MaxBytes, always keeping the most recent.  p.mu must be held.
+func (p *Prefetcher) evict() {
+	for p.bytes > p.cfg.MaxBytes && p.lru.Len() > 1 {
+		l := p.lru.Remove(p.lru.Back()).(*load)
+		delete(p.cache, l.i)
+		p.bytes -= l.bytes
+	}
+}
+
+// schedule replaces the queue with the images around i, nearest first and
+// ahead before behind, limited to as many as will fit in MaxBytes alongside
+// i.  Those already cached are marked as recently used so they're not
+// evicted in favour of images further away.  p.mu must be held.
+func (p *Prefetcher) schedule(i int) {
+	limit := p.cfg.Ahead + p.cfg.Behind
+	if p.frameBytes > 0 {
+		if fit := int(p.cfg.MaxBytes/p.frameBytes) - 1; fit < limit {
+			limit = fit
+		}
+	}
+	var window []int
+	for d := 1; len(window) < limit && (d <= p.cfg.Ahead || d <= p.cfg.Behind); d++ {
+		if j := i + d; d <= p.cfg.Ahead && j < p.seq.Len() {
+			window = append(window, j)
+		}
+		if j := i - d; d <= p.cfg.Behind && j >= 0 && len(window) < limit {
+			window = append(window, j)
+		}
+	}
+	p.queue = p.queue[:0]
+	for k := len(window) - 1; k >= 0; k-- {
+		if e, ok := p.cache[window[k]]; ok {
+			p.lru.MoveToFront(e)
+		}
+	}
+	if e, ok := p.cache[i]; ok {
+		p.lru.MoveToFront(e)
+	}
+	for _, j := range window {
+		if _, ok := p.cache[j]; !ok {
+			if _, ok := p.pending[j]; !ok {
+				p.queue = append(p.queue, j)
+			}
+		}
+	}
+	p.cond.Broadcast()
+}
+
+func (p *Prefetcher) worker() {
+	defer p.workers.Done()
+	p.mu.Lock()
+	for {
+		for len(p.queue) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		i := p.queue[0]
+		p.queue = p.queue[1:]
+		if _, ok := p.cache[i]; ok {
+			continue
+		}
+		if _, ok := p.pending[i]; ok {
+			continue
+		}
+		l := p.start(i)
+		p.mu.Unlock()
+		p.finish(l)
+		p.mu.Lock()
+	}
+}
+
+// Close stops the workers, waiting for any loads in progress, empties the
+// cache and closes the underlying Sequence.
+func (p *Prefetcher) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.queue = nil
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	p.workers.Wait()
+	p.mu.Lock()
+	p.cache, p.lru, p.bytes = make(map[int]*list.Element), list.New(), 0
+	p.mu.Unlock()
+	return p.seq.Close()
+}
@@ -0,0 +1,128 @@
+package imgseq
+
+import . "gopkg.in/check.v1"
+
+import "code.google.com/p/ncabatoff/imglib"
+import "fmt"
+import "sync"
+import "time"
+
+// countingSequence counts the loads of each image.  Loading an image with a
+// channel in block waits until it's closed; loading one in fail is an error.
+type countingSequence struct {
+	SliceSequence
+	block map[int]chan struct{}
+	fail  map[int]bool
+
+	mu    sync.Mutex
+	loads map[int]int
+}
+
+func newCountingSequence(n int) *countingSequence {
+	cs := &countingSequence{block: make(map[int]chan struct{}), fail: make(map[int]bool), loads: make(map[int]int)}
+	for i, img := range testYuyvs(n) {
+		cs.SliceSequence = append(cs.SliceSequence, &RawImg{ImgInfo{SeqNum: i}, imglib.GetPixelSequence(img)})
+	}
+	return cs
+}
+
+func (cs *countingSequence) At(i int) (Img, error) {
+	if ch := cs.block[i]; ch != nil {
+		<-ch
+	}
+	cs.mu.Lock()
+	cs.loads[i]++
+	cs.mu.Unlock()
+	if cs.fail[i] {
+		return nil, fmt.Errorf("image %d is corrupt", i)
+	}
+	return cs.SliceSequence.At(i)
+}
+
+func (cs *countingSequence) count(i int) int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.loads[i]
+}
+
+// eventually waits up to a few seconds for cond to become true.
+func eventually(cond func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func (s *MySuite) TestPrefetch(c *C) {
+	cs := newCountingSequence(20)
+	p := NewPrefetcher(cs, PrefetchConfig{Ahead: 3, Behind: 1, Workers: 2, MaxBytes: 1 << 20})
+	img, err := p.At(5)
+	c.Assert(err, IsNil)
+	c.Check(img.GetImgInfo().SeqNum, Equals, 5)
+	for _, i := range []int{4, 6, 7, 8} {
+		c.Check(eventually(func() bool { return cs.count(i) == 1 }), Equals, true, Commentf("%d", i))
+	}
+	for _, i := range []int{5, 6, 7} {
+		img, err := p.At(i)
+		c.Assert(err, IsNil)
+		c.Check(img, Equals, cs.SliceSequence[i])
+	}
+	c.Check(eventually(func() bool { return cs.count(10) == 1 }), Equals, true)
+	for i := 4; i <= 10; i++ {
+		c.Check(cs.count(i), Equals, 1, Commentf("%d", i))
+	}
+	c.Check(cs.count(3), Equals, 0)
+	c.Check(p.Len(), Equals, 20)
+	c.Check(p.Info(3), DeepEquals, cs.Info(3))
+	c.Check(p.Close(), IsNil)
+}
+
+func (s *MySuite) TestPrefetchSeek(c *C) {
+	cs := newCountingSequence(20)
+	gate := make(chan struct{})
+	cs.block[1] = gate
+	p := NewPrefetcher(cs, PrefetchConfig{Ahead: 4, Workers: 1, MaxBytes: 1 << 20})
+	_, err := p.At(0)
+	c.Assert(err, IsNil)
+	// The only worker is stuck on 1, so 2-4 are still queued when we seek.
+	_, err = p.At(10)
+	c.Assert(err, IsNil)
+	close(gate)
+	c.Check(eventually(func() bool { return cs.count(14) == 1 }), Equals, true)
+	for i := 2; i <= 4; i++ {
+		c.Check(cs.count(i), Equals, 0, Commentf("%d", i))
+	}
+	c.Check(p.Close(), IsNil)
+}
+
+func (s *MySuite) TestPrefetchMemory(c *C) {
+	cs := newCountingSequence(20)
+	fsz := int64(len(cs.SliceSequence[0].GetPixelSequence().GetBytes()))
+	p := NewPrefetcher(cs, PrefetchConfig{Ahead: 10, Workers: 3, MaxBytes: 3 * fsz})
+	_, err := p.At(0)
+	c.Assert(err, IsNil)
+	_, err = p.At(1)
+	c.Assert(err, IsNil)
+	c.Check(eventually(func() bool { return cs.count(3) == 1 }), Equals, true)
+	time.Sleep(10 * time.Millisecond)
+	c.Check(cs.count(4), Equals, 0)
+	p.mu.Lock()
+	c.Check(p.bytes <= 3*fsz, Equals, true)
+	p.mu.Unlock()
+	c.Check(p.Close(), IsNil)
+}
+
+func (s *MySuite) TestPrefetchErrors(c *C) {
+	cs := newCountingSequence(5)
+	cs.fail[2] = true
+	p := NewPrefetcher(cs, PrefetchConfig{Ahead: 2, Workers: 1, MaxBytes: 1 << 20})
+	_, err := p.At(1)
+	c.Check(err, IsNil)
+	c.Check(eventually(func() bool { return cs.count(3) == 1 }), Equals, true)
+	_, err = p.At(2)
+	c.Check(err, ErrorMatches, "image 2 is corrupt")
+	c.Check(cs.count(2), Equals, 2)
+	c.Check(p.Close(), IsNil)
+}
@@ -0,0 +1,189 @@
+package imgseq
+
+import (
+	"code.google.com/p/ncabatoff/imglib"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// scanBatch is the number of directory entries read at a time, so that huge
+// directories aren't read into memory all at once when streaming.
+const scanBatch = 1024
+
+// ScanOptions controls which files ScanDir and StreamDir return.  The zero
+// value gives every file with an extension in the directory itself, other
+// than reservedNames, in lexical order, as GetDirList always has.
+type ScanOptions struct {
+	// Recursive includes files in subdirectories, except QuarantineDir.
+	// Subdirectories are recognised by having no extension, which saves a
+	// stat of every file; a directory with a dot in its name isn't entered.
+	Recursive bool
+	// Exts, if not empty, lists the extensions of the files wanted, e.g.
+	// ".yuv".
+	Exts []string
+	// Glob, if set, is a pattern as for filepath.Match which the base names
+	// of the files wanted must match.
+	Glob string
+	// Names is used to get timestamps from filenames; the zero value means
+	// DefaultNameScheme.  If NamedOnly is set files not named according to it
+	// are skipped.
+	Names     NameScheme
+	NamedOnly bool
+	// From and To, if not zero, restrict the files to those whose names give
+	// a creation time at or after From and before To.
+	From, To time.Time
+	// ByTime sorts the files by the creation time in their names, rather
+	// than lexically.  Files without one come first.
+	ByTime bool
+}
+
+// reservedNames are the files we write alongside images, which are never
+// returned whatever their extension.
+var reservedNames = map[string]bool{
+	imglib.RawInfoFile: true,
+	MetadataFile:       true,
+	StoreIndexFile:     true,
+	storeIndexTmp:      true,
+}
+
+func (opts ScanOptions) names() NameScheme {
+	if opts.Names == (NameScheme{}) {
+		return DefaultNameScheme
+	}
+	return opts.Names
+}
+
+// match returns whether the file f, with its creation time t if ok, is wanted.
+func (opts ScanOptions) match(f string, t time.Time, ok bool) bool {
+	ext := filepath.Ext(f)
+	if ext == "" || reservedNames[f] {
+		return false
+	}
+	if len(opts.Exts) > 0 {
+		found := false
+		for _, e := range opts.Exts {
+			found = found || e == ext
+		}
+		if !found {
+			return false
+		}
+	}
+	if opts.Glob != "" {
+		if m, _ := filepath.Match(opts.Glob, f); !m {
+			return false
+		}
+	}
+	if !ok && (opts.NamedOnly || !opts.From.IsZero() || !opts.To.IsZero()) {
+		return false
+	}
+	if !opts.From.IsZero() && t.Before(opts.From) || !opts.To.IsZero() && !t.Before(opts.To) {
+		return false
+	}
+	return true
+}
+
+// walk calls fn for each file wanted in dir/rel and, if opts.Recursive, its
+// subdirectories, in the order they're read.
+func walk(dir, rel string, opts ScanOptions, fn func(rel string, t time.Time, ok bool) error) error {
+	fd, err := os.Open(filepath.Join(dir, rel))
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	ns := opts.names()
+	for {
+		fs, err := fd.Readdirnames(scanBatch)
+		for _, f := range fs {
+			if filepath.Ext(f) == "" {
+				if sub := filepath.Join(rel, f); opts.Recursive && isSubdir(dir, sub) {
+					if err := walk(dir, sub, opts, fn); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			_, t, ok := ns.Parse(f)
+			if opts.match(f, t, ok) {
+				if err := fn(filepath.Join(rel, f), t, ok); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF || err == nil && len(fs) == 0 {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// isSubdir returns whether rel, relative to dir, is a subdirectory to be
+// scanned when recursing.  Callers have already checked that it has no
+// extension.
+func isSubdir(dir, rel string) bool {
+	if filepath.Base(rel) == QuarantineDir {
+		return false
+	}
+	fi, err := os.Stat(filepath.Join(dir, rel))
+	return err == nil && fi.IsDir()
+}
+
+// ScanDir returns the files in dir chosen by opts, sorted as opts says.
+// Files in subdirectories are given relative to dir.
+func ScanDir(dir string, opts ScanOptions) (DirList, error) {
+	dl := DirList{Path: dir, Files: []string{}, Names: opts.Names}
+	var times []int64
+	err := walk(dir, "", opts, func(rel string, t time.Time, ok bool) error {
+		dl.Files = append(dl.Files, rel)
+		if ok {
+			times = append(times, t.UnixNano())
+		} else {
+			times = append(times, -1<<63)
+		}
+		return nil
+	})
+	if err != nil {
+		return DirList{}, err
+	}
+	if opts.ByTime {
+		sort.Sort(byTime{dl.Files, times})
+	} else {
+		sort.Strings(dl.Files)
+	}
+	return dl, nil
+}
+
+// StreamDir sends the files in dir chosen by opts to out as they're found,
+// without sorting them, and closes out when done.  This allows directories
+// holding millions of files to be processed without waiting for them all to
+// be read.  It stops early if ctx is cancelled, returning ctx.Err().
+func StreamDir(ctx context.Context, dir string, opts ScanOptions, out chan<- string) error {
+	defer close(out)
+	return walk(dir, "", opts, func(rel string, t time.Time, ok bool) error {
+		select {
+		case out <- rel:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// byTime sorts files by the corresponding times.
+type byTime struct {
+	files []string
+	times []int64
+}
+
+func (bt byTime) Len() int { return len(bt.files) }
+func (bt byTime) Less(i, j int) bool {
+	return bt.times[i] < bt.times[j] || bt.times[i] == bt.times[j] && bt.files[i] < bt.files[j]
+}
+func (bt byTime) Swap(i, j int) {
+	bt.files[i], bt.files[j] = bt.files[j], bt.files[i]
+	bt.times[i], bt.times[j] = bt.times[j], bt.times[i]
+}
@@ -0,0 +1,86 @@
+package imgseq
+
+import . "gopkg.in/check.v1"
+
+import "context"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "sort"
+import "time"
+
+// makeTree creates empty files at each of paths under dir.
+func makeTree(c *C, dir string, paths ...string) {
+	for _, p := range paths {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+		c.Assert(os.MkdirAll(filepath.Dir(full), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(full, nil, 0644), IsNil)
+	}
+}
+
+func (s *MySuite) TestScanDir(c *C) {
+	dir := c.MkDir()
+	// Timestamps with fewer digits sort lexically after those with more.
+	makeTree(c, dir, "test900.yuv", "test1000.yuv", "test500.rgb", "snap.png", "noext",
+		StoreIndexFile, storeIndexTmp, MetadataFile, "sub/"+MetadataFile,
+		"sub/test700.yuv", "sub/deeper/test100.yuv", "quarantine/test1.yuv", "skip.d/test2.yuv")
+	fs := func(paths ...string) []string {
+		for i := range paths {
+			paths[i] = filepath.FromSlash(paths[i])
+		}
+		return paths
+	}
+	for _, tc := range []struct {
+		opts ScanOptions
+		want []string
+	}{
+		{ScanOptions{}, fs("skip.d", "snap.png", "test1000.yuv", "test500.rgb", "test900.yuv")},
+		{ScanOptions{Exts: []string{".yuv", ".rgb"}}, fs("test1000.yuv", "test500.rgb", "test900.yuv")},
+		{ScanOptions{Glob: "test9*"}, fs("test900.yuv")},
+		{ScanOptions{ByTime: true, NamedOnly: true}, fs("test500.rgb", "test900.yuv", "test1000.yuv")},
+		{ScanOptions{Recursive: true, ByTime: true, Exts: []string{".yuv"}},
+			fs("sub/deeper/test100.yuv", "sub/test700.yuv", "test900.yuv", "test1000.yuv")},
+		{ScanOptions{Recursive: true, From: time.Unix(0, 500), To: time.Unix(0, 1000)},
+			fs("sub/test700.yuv", "test500.rgb", "test900.yuv")},
+		{ScanOptions{Names: NameScheme{Prefix: "snap"}, NamedOnly: true}, []string{}},
+	} {
+		dl, err := ScanDir(dir, tc.opts)
+		c.Assert(err, IsNil)
+		c.Check(dl.Files, DeepEquals, tc.want, Commentf("%+v", tc.opts))
+	}
+
+	dl, err := GetDirList(dir)
+	c.Assert(err, IsNil)
+	c.Check(dl.ImgInfos()[2].CreationTs.Equal(time.Unix(0, 1000)), Equals, true)
+	_, err = ScanDir(filepath.Join(dir, "missing"), ScanOptions{})
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestStreamDir(c *C) {
+	dir := c.MkDir()
+	var want []string
+	for i := 0; i < 3*scanBatch/2; i++ {
+		want = append(want, TimeToFname(defaultPrefix, time.Unix(0, int64(i)))+".yuv")
+	}
+	makeTree(c, dir, want...)
+	out := make(chan string)
+	errchan := make(chan error, 1)
+	go func() { errchan <- StreamDir(context.Background(), dir, ScanOptions{}, out) }()
+	var got []string
+	for f := range out {
+		got = append(got, f)
+	}
+	c.Check(<-errchan, IsNil)
+	sort.Strings(got)
+	sort.Strings(want)
+	c.Check(got, DeepEquals, want)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out = make(chan string)
+	go func() { errchan <- StreamDir(ctx, dir, ScanOptions{}, out) }()
+	<-out
+	cancel()
+	for range out {
+	}
+	c.Check(<-errchan, Equals, context.Canceled)
+}
@@ -0,0 +1,325 @@
+package imgseq
+
+import (
+	"code.google.com/p/ncabatoff/imglib"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// Sequence is an ordered collection of images which can be read in any order.
+// Images are assumed to be in CreationTs order, which is what allows IndexAt
+// to find them by time; for sources that don't record timestamps they're all
+// zero, or derived from the frame rate if there is one.
+type Sequence interface {
+	// Len returns the number of images.
+	Len() int
+	// Info returns the ImgInfo of the ith image without loading it.
+	Info(i int) ImgInfo
+	// At returns the ith image.
+	At(i int) (Img, error)
+	// IndexAt returns the index of the first image created at or after t,
+	// or Len() if there is none.
+	IndexAt(t time.Time) int
+	// Close releases any resources held by the sequence.  Images obtained
+	// from At may share memory with it, so must not be used afterwards.
+	Close() error
+}
+
+// searchTime implements IndexAt for a Sequence in CreationTs order.
+func searchTime(seq Sequence, t time.Time) int {
+	return sort.Search(seq.Len(), func(i int) bool {
+		return !seq.Info(i).CreationTs.Before(t)
+	})
+}
+
+// SliceSequence is a Sequence of images held in memory.
+type SliceSequence []Img
+
+func (ss SliceSequence) Len() int {
+	return len(ss)
+}
+
+func (ss SliceSequence) Info(i int) ImgInfo {
+	return ss[i].GetImgInfo()
+}
+
+func (ss SliceSequence) At(i int) (Img, error) {
+	return ss[i], nil
+}
+
+func (ss SliceSequence) IndexAt(t time.Time) int {
+	return searchTime(ss, t)
+}
+
+func (ss SliceSequence) Close() error {
+	return nil
+}
+
+// dirSequence is a Sequence of image files in a directory.
+type dirSequence struct {
+	iinfos []ImgInfo
+}
+
+// NewDirSequence returns a Sequence of the files in dl, which are loaded
+// when asked for.  Their ImgInfos are as given by dl.ImgInfos.
+func NewDirSequence(dl DirList) Sequence {
+	return &dirSequence{dl.ImgInfos()}
+}
+
+func (ds *dirSequence) Len() int {
+	return len(ds.iinfos)
+}
+
+func (ds *dirSequence) Info(i int) ImgInfo {
+	return ds.iinfos[i]
+}
+
+func (ds *dirSequence) At(i int) (Img, error) {
+	return LoadRawImg(ds.iinfos[i])
+}
+
+func (ds *dirSequence) IndexAt(t time.Time) int {
+	return searchTime(ds, t)
+}
+
+func (ds *dirSequence) Close() error {
+	return nil
+}
+
+// framesSequence is a Sequence of the frames in a frame container.
+type framesSequence struct {
+	path string
+	ff   *imglib.FramesFile
+	meta MetadataIndex
+}
+
+func newFramesSequence(path string, ff *imglib.FramesFile) *framesSequence {
+	return &framesSequence{path, ff, dirMetadata(path)}
+}
+
+func (fs *framesSequence) Len() int {
+	return fs.ff.Len()
+}
+
+func (fs *framesSequence) Info(i int) ImgInfo {
+	fr := fs.ff.Frame(i)
+	return ImgInfo{SeqNum: fr.SeqNum, CreationTs: fr.CreationTs, Path: fs.path, Meta: fs.meta.Get(fs.path, fr.SeqNum)}
+}
+
+func (fs *framesSequence) At(i int) (Img, error) {
+	return &RawImg{fs.Info(i), imglib.GetPixelSequence(fs.ff.Image(i))}, nil
+}
+
+func (fs *framesSequence) IndexAt(t time.Time) int {
+	return searchTime(fs, t)
+}
+
+func (fs *framesSequence) Close() error {
+	return fs.ff.Close()
+}
+
+// y4mSequence is a Sequence of the frames in a YUV4MPEG2 file.
+type y4mSequence struct {
+	path   string
+	yf     *imglib.Y4MFile
+	period time.Duration
+	meta   MetadataIndex
+}
+
+func newY4MSequence(path string, yf *imglib.Y4MFile) *y4mSequence {
+	ys := &y4mSequence{path: path, yf: yf, meta: dirMetadata(path)}
+	if hdr := yf.Header(); hdr.FrameRateNum > 0 && hdr.FrameRateDen > 0 {
+		ys.period = time.Duration(int64(time.Second) * int64(hdr.FrameRateDen) / int64(hdr.FrameRateNum))
+	}
+	return ys
+}
+
+func (ys *y4mSequence) Len() int {
+	return ys.yf.Len()
+}
+
+// Info returns a CreationTs which is the frame's offset from the start of the
+// stream given its frame rate, counting from the Unix epoch, since YUV4MPEG2
+// has no timestamps.
+func (ys *y4mSequence) Info(i int) ImgInfo {
+	return ImgInfo{SeqNum: i, CreationTs: time.Unix(0, int64(i)*int64(ys.period)), Path: ys.path, Meta: ys.meta.Get(ys.path, i)}
+}
+
+// At returns the frame converted to YUYV, as LoadY4MImgs does.
+func (ys *y4mSequence) At(i int) (Img, error) {
+	yuyv := imglib.NewYUYVFromYCbCr(ys.yf.YCbCr(i))
+	return &RawImg{ys.Info(i), imglib.GetPixelSequence(yuyv)}, nil
+}
+
+func (ys *y4mSequence) IndexAt(t time.Time) int {
+	return searchTime(ys, t)
+}
+
+func (ys *y4mSequence) Close() error {
+	return ys.yf.Close()
+}
+
+// rawSequence is a Sequence of the images in a file of concatenated
+// headerless images.
+type rawSequence struct {
+	path string
+	raw  *imglib.RawFile
+}
+
+// OpenRawSequence returns a Sequence of the images in the file at path, which
+// holds headerless images laid out as rf one after another.  Such files have
+// no timestamps, so CreationTs is always zero.
+func OpenRawSequence(path string, rf imglib.RawFormat) (Sequence, error) {
+	if raw, err := imglib.OpenRawFile(path, rf); err != nil {
+		return nil, err
+	} else {
+		return &rawSequence{path, raw}, nil
+	}
+}
+
+func (rs *rawSequence) Len() int {
+	return rs.raw.Len()
+}
+
+func (rs *rawSequence) Info(i int) ImgInfo {
+	return ImgInfo{SeqNum: i, Path: rs.path}
+}
+
+func (rs *rawSequence) At(i int) (Img, error) {
+	return &RawImg{rs.Info(i), imglib.GetPixelSequence(rs.raw.Image(i))}, nil
+}
+
+func (rs *rawSequence) IndexAt(t time.Time) int {
+	return searchTime(rs, t)
+}
+
+func (rs *rawSequence) Close() error {
+	return rs.raw.Close()
+}
+
+// concatSequence is a Sequence of the images of several others, one after
+// the other.  starts[k] is the index of the first image of seqs[k], and
+// starts[len(seqs)] is the total number of images.
+type concatSequence struct {
+	seqs   []Sequence
+	starts []int
+}
+
+// Concat returns a Sequence of the images in each of seqs in turn.  Closing
+// it closes all of them.
+func Concat(seqs ...Sequence) Sequence {
+	cs := &concatSequence{seqs: seqs, starts: make([]int, len(seqs)+1)}
+	for k, seq := range seqs {
+		cs.starts[k+1] = cs.starts[k] + seq.Len()
+	}
+	return cs
+}
+
+// locate returns the sequence holding image i and its index within it.
+func (cs *concatSequence) locate(i int) (Sequence, int) {
+	k := sort.Search(len(cs.seqs), func(k int) bool { return cs.starts[k+1] > i })
+	return cs.seqs[k], i - cs.starts[k]
+}
+
+func (cs *concatSequence) Len() int {
+	return cs.starts[len(cs.seqs)]
+}
+
+func (cs *concatSequence) Info(i int) ImgInfo {
+	seq, j := cs.locate(i)
+	return seq.Info(j)
+}
+
+func (cs *concatSequence) At(i int) (Img, error) {
+	seq, j := cs.locate(i)
+	return seq.At(j)
+}
+
+func (cs *concatSequence) IndexAt(t time.Time) int {
+	return searchTime(cs, t)
+}
+
+func (cs *concatSequence) Close() error {
+	var err error
+	for _, seq := range cs.seqs {
+		if cerr := seq.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// subSequence is the images lo up to but excluding hi of seq.
+type subSequence struct {
+	seq    Sequence
+	lo, hi int
+}
+
+// Slice returns a Sequence of images lo up to but excluding hi of seq.
+// Closing it closes seq.
+func Slice(seq Sequence, lo, hi int) Sequence {
+	if lo < 0 || hi < lo || hi > seq.Len() {
+		panic("imgseq: slice bounds out of range")
+	}
+	return &subSequence{seq, lo, hi}
+}
+
+func (ss *subSequence) Len() int {
+	return ss.hi - ss.lo
+}
+
+func (ss *subSequence) Info(i int) ImgInfo {
+	return ss.seq.Info(ss.lo + i)
+}
+
+func (ss *subSequence) At(i int) (Img, error) {
+	return ss.seq.At(ss.lo + i)
+}
+
+func (ss *subSequence) IndexAt(t time.Time) int {
+	return searchTime(ss, t)
+}
+
+func (ss *subSequence) Close() error {
+	return ss.seq.Close()
+}
+
+// OpenSequence returns a Sequence of the images at path, which may be a
+// directory as read by GetDirList, a frame container or a YUV4MPEG2 file.
+// Files are identified by their content rather than their extension.  Use
+// OpenRawSequence for files of concatenated headerless images, whose layout
+// can't be determined from their content.
+func OpenSequence(path string) (Sequence, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		if dl, err := GetDirList(path); err != nil {
+			return nil, err
+		} else {
+			return NewDirSequence(dl), nil
+		}
+	}
+	ext, err := imglib.SniffExt(path)
+	if err != nil {
+		return nil, err
+	}
+	switch ext {
+	case imglib.FramesExt:
+		if ff, err := imglib.OpenFramesFile(path); err != nil {
+			return nil, err
+		} else {
+			return newFramesSequence(path, ff), nil
+		}
+	case imglib.Y4MExt:
+		if yf, err := imglib.OpenY4MFile(path); err != nil {
+			return nil, err
+		} else {
+			return newY4MSequence(path, yf), nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' isn't a directory, frame container or y4m file", path)
+}
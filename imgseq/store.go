@@ -0,0 +1,377 @@
+package imgseq
+
+import (
+	"bufio"
+	"code.google.com/p/ncabatoff/imglib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StoreIndexFile is the name of the file in the top directory of a Store
+// listing its segments.  It's rewritten via storeIndexTmp.
+const (
+	StoreIndexFile = "storeindex"
+	storeIndexTmp  = StoreIndexFile + ".tmp"
+)
+
+// segmentDirLayout is the time layout of the directories segments are
+// written in, relative to the top of the store.
+const segmentDirLayout = "2006/01/02/15"
+
+// StoreConfig controls how a Store partitions frames and how long it keeps
+// them.
+type StoreConfig struct {
+	// SegmentDuration is the period covered by each segment file; a new
+	// segment is started when a frame falls in a new period.  Zero means
+	// DefaultStoreConfig.SegmentDuration.
+	SegmentDuration time.Duration
+	// MaxAge, if nonzero, is how long segments are kept, measured from
+	// their last frame to the newest frame written.
+	MaxAge time.Duration
+	// MaxBytes, if nonzero, bounds the total size of the segments.
+	MaxBytes int64
+}
+
+// DefaultStoreConfig keeps frames forever in hourly segments.
+var DefaultStoreConfig = StoreConfig{SegmentDuration: time.Hour}
+
+// Segment describes a frame container within a Store.
+type Segment struct {
+	// Path is relative to the top directory of the store.
+	Path       string
+	Start, End time.Time
+	Count      int
+	Bytes      int64
+}
+
+func (seg Segment) String() string {
+	return fmt.Sprintf("%s %d %d %d %d", filepath.ToSlash(seg.Path), seg.Start.UnixNano(), seg.End.UnixNano(), seg.Count, seg.Bytes)
+}
+
+func parseSegment(s string) (Segment, error) {
+	var seg Segment
+	var path string
+	var start, end int64
+	if _, err := fmt.Sscanf(s, "%s %d %d %d %d", &path, &start, &end, &seg.Count, &seg.Bytes); err != nil {
+		return Segment{}, fmt.Errorf("bad segment '%s': %v", s, err)
+	}
+	seg.Path, seg.Start, seg.End = filepath.FromSlash(path), time.Unix(0, start), time.Unix(0, end)
+	return seg, nil
+}
+
+// Store keeps a stream of frames in a directory tree of frame containers,
+// each holding the frames from one SegmentDuration period, in hourly
+// subdirectories of the form YYYY/MM/DD/HH.  An index of the segments and
+// the times they cover allows time range queries without opening them all.
+// Once a store exceeds its retention limits the oldest segments are deleted.
+//
+// A Store may be written by only one process at a time, but other processes
+// can read it with OpenStore and Range.
+type Store struct {
+	dir string
+	cfg StoreConfig
+
+	mu     sync.Mutex
+	segs   []Segment
+	file   *os.File
+	fw     *imglib.FramesWriter
+	period time.Time
+	pack   []byte
+}
+
+// IsStore returns true if dir is the top directory of a Store.
+func IsStore(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, StoreIndexFile))
+	return err == nil
+}
+
+// OpenStore opens the store in dir, creating it if need be.  If the index is
+// missing it's rebuilt from the segments found.
+func OpenStore(dir string, cfg StoreConfig) (*Store, error) {
+	if cfg.SegmentDuration <= 0 {
+		cfg.SegmentDuration = DefaultStoreConfig.SegmentDuration
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	st := &Store{dir: dir, cfg: cfg}
+	if err := st.readIndex(); os.IsNotExist(err) {
+		if err := st.scan(); err != nil {
+			return nil, err
+		}
+		return st, st.writeIndex()
+	} else if err != nil {
+		return nil, err
+	}
+	// A writer which didn't close the store cleanly may have added frames to
+	// its last segment since the index was written.
+	if n := len(st.segs); n > 0 {
+		if seg, err := st.readSegment(st.segs[n-1].Path); err == nil {
+			st.segs[n-1] = seg
+		} else {
+			st.segs = st.segs[:n-1]
+		}
+	}
+	return st, nil
+}
+
+func (st *Store) readIndex() error {
+	file, err := os.Open(filepath.Join(st.dir, StoreIndexFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			if seg, err := parseSegment(line); err != nil {
+				return err
+			} else {
+				st.segs = append(st.segs, seg)
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// writeIndex replaces the index with one describing st.segs.
+func (st *Store) writeIndex() error {
+	lines := make([]string, len(st.segs))
+	for i, seg := range st.segs {
+		lines[i] = seg.String() + "\n"
+	}
+	tmp := filepath.Join(st.dir, storeIndexTmp)
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "")), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(st.dir, StoreIndexFile))
+}
+
+// readSegment describes the segment at path, relative to the store.
+func (st *Store) readSegment(path string) (Segment, error) {
+	full := filepath.Join(st.dir, path)
+	ff, err := imglib.OpenFramesFile(full)
+	if err != nil {
+		return Segment{}, err
+	}
+	defer ff.Close()
+	seg := Segment{Path: path, Count: ff.Len()}
+	if seg.Count > 0 {
+		seg.Start, seg.End = ff.Frame(0).CreationTs, ff.Frame(seg.Count-1).CreationTs
+	}
+	if fi, err := os.Stat(full); err != nil {
+		return Segment{}, err
+	} else {
+		seg.Bytes = fi.Size()
+	}
+	return seg, nil
+}
+
+// scan rebuilds st.segs from the segments found in the directory tree.
+// Segment names sort in time order.
+func (st *Store) scan() error {
+	paths, err := filepath.Glob(filepath.Join(st.dir, "[0-9]*", "[0-9]*", "[0-9]*", "[0-9]*", "*"+imglib.FramesExt))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		rel, err := filepath.Rel(st.dir, p)
+		if err != nil {
+			return err
+		}
+		if seg, err := st.readSegment(rel); err != nil {
+			return err
+		} else if seg.Count > 0 {
+			st.segs = append(st.segs, seg)
+		}
+	}
+	return nil
+}
+
+// Segments returns the segments in the store, oldest first.
+func (st *Store) Segments() []Segment {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]Segment(nil), st.segs...)
+}
+
+// Write appends img to the store, starting a new segment if it belongs to a
+// new period or differs in format or size from the current segment's frames,
+// and then deletes old segments as the retention limits require.  Images
+// should be written in CreationTs order.
+func (st *Store) Write(img Img) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ii, ps := img.GetImgInfo(), img.GetPixelSequence()
+	hdr := imglib.FramesHeader{Format: imglib.GetPixelFormat(ps.ImageBytes), Width: ps.Dx, Height: ps.Dy}
+	period := periodStart(ii.CreationTs, st.cfg.SegmentDuration)
+	if st.fw != nil {
+		if cur := st.fw.Header(); !period.Equal(st.period) || cur.Format != hdr.Format || cur.Width != hdr.Width || cur.Height != hdr.Height {
+			if err := st.closeSegment(); err != nil {
+				return err
+			}
+		}
+	}
+	if st.fw == nil {
+		if err := st.openSegment(ii.CreationTs, hdr); err != nil {
+			return err
+		}
+		st.period = period
+	}
+
+	// Frames are stored packed, which sub-images aren't.
+	pix := ps.GetBytes()
+	if rowlen := ps.RowBytes(); ps.GetStride() != rowlen || len(pix) != rowlen*ps.Dy {
+		st.pack = st.pack[:0]
+		for y := 0; y < ps.Dy; y++ {
+			st.pack = append(st.pack, ps.Row(y)...)
+		}
+		pix = st.pack
+	}
+	if err := st.fw.WriteFrame(ii.CreationTs, ii.SeqNum, pix); err != nil {
+		return err
+	}
+	seg := &st.segs[len(st.segs)-1]
+	if seg.Count == 0 {
+		seg.Start = ii.CreationTs
+	}
+	seg.End = ii.CreationTs
+	seg.Count++
+	if off, err := st.file.Seek(0, io.SeekCurrent); err != nil {
+		return err
+	} else {
+		seg.Bytes = off
+	}
+	return st.enforce(ii.CreationTs)
+}
+
+// periodStart returns the start of the period of length d containing t.
+// Periods are aligned on the wall clock of t's location rather than on UTC,
+// so that hourly segments start on the hour, and daily ones at midnight, even
+// where the zone's offset isn't a whole number of hours.
+func periodStart(t time.Time, d time.Duration) time.Time {
+	y, mo, dd := t.Date()
+	h, mi, sec := t.Clock()
+	wall := time.Date(y, mo, dd, h, mi, sec, t.Nanosecond(), time.UTC).Truncate(d)
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), t.Location())
+}
+
+// openSegment starts a new segment whose first frame is created at t.
+func (st *Store) openSegment(t time.Time, hdr imglib.FramesHeader) error {
+	rel := filepath.Join(filepath.FromSlash(t.Format(segmentDirLayout)), strconv.FormatInt(t.UnixNano(), 10)+imglib.FramesExt)
+	full := filepath.Join(st.dir, rel)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(full)
+	if err != nil {
+		return err
+	}
+	fw, err := imglib.NewFramesWriter(file, hdr)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	st.file, st.fw = file, fw
+	st.segs = append(st.segs, Segment{Path: rel, Start: t, End: t})
+	return st.writeIndex()
+}
+
+// closeSegment finishes the current segment.
+func (st *Store) closeSegment() error {
+	err := st.fw.Close()
+	if cerr := st.file.Close(); err == nil {
+		err = cerr
+	}
+	st.file, st.fw = nil, nil
+	if werr := st.writeIndex(); err == nil {
+		err = werr
+	}
+	return err
+}
+
+// enforce deletes the oldest segments while the store exceeds its limits at
+// time now.  The newest segment is never deleted.
+func (st *Store) enforce(now time.Time) error {
+	var total int64
+	for _, seg := range st.segs {
+		total += seg.Bytes
+	}
+	removed := false
+	for len(st.segs) > 1 {
+		oldest := st.segs[0]
+		if !(st.cfg.MaxAge > 0 && now.Sub(oldest.End) > st.cfg.MaxAge || st.cfg.MaxBytes > 0 && total > st.cfg.MaxBytes) {
+			break
+		}
+		if err := st.removeSegment(oldest.Path); err != nil {
+			return err
+		}
+		st.segs = st.segs[1:]
+		total -= oldest.Bytes
+		removed = true
+	}
+	if removed {
+		return st.writeIndex()
+	}
+	return nil
+}
+
+// removeSegment deletes the segment at path and any directories left empty.
+func (st *Store) removeSegment(path string) error {
+	if err := os.Remove(filepath.Join(st.dir, path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for dir := filepath.Dir(path); dir != "." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if os.Remove(filepath.Join(st.dir, dir)) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Range returns a Sequence of the frames created at or after from and
+// before to; a zero to means there's no upper limit.  The Sequence must be
+// closed when done with.
+func (st *Store) Range(from, to time.Time) (Sequence, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var parts []Sequence
+	for _, seg := range st.segs {
+		if seg.End.Before(from) || !to.IsZero() && !seg.Start.Before(to) {
+			continue
+		}
+		path := filepath.Join(st.dir, seg.Path)
+		ff, err := imglib.OpenFramesFile(path)
+		if err != nil {
+			Concat(parts...).Close()
+			return nil, err
+		}
+		fs := newFramesSequence(path, ff)
+		lo, hi := fs.IndexAt(from), fs.Len()
+		if !to.IsZero() {
+			if hi = fs.IndexAt(to); hi < lo {
+				hi = lo
+			}
+		}
+		parts = append(parts, Slice(fs, lo, hi))
+	}
+	return Concat(parts...), nil
+}
+
+// Close finishes the current segment, if any.  Sequences returned by Range
+// remain usable.
+func (st *Store) Close() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.fw != nil {
+		return st.closeSegment()
+	}
+	return nil
+}
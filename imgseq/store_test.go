@@ -0,0 +1,158 @@
+package imgseq
+
+import . "gopkg.in/check.v1"
+
+import "code.google.com/p/ncabatoff/imglib"
+import "image"
+import "os"
+import "path/filepath"
+import "time"
+
+// storeFrames returns n 4x2 frames created every step from t0.
+func storeFrames(n int, t0 time.Time, step time.Duration) []Img {
+	var ret []Img
+	for i, yuyv := range testYuyvs(n) {
+		ii := ImgInfo{SeqNum: i, CreationTs: t0.Add(time.Duration(i) * step)}
+		ret = append(ret, &RawImg{ii, imglib.GetPixelSequence(yuyv)})
+	}
+	return ret
+}
+
+// checkRange verifies that seq holds want.
+func checkRange(c *C, seq Sequence, want []Img) {
+	c.Assert(seq.Len(), Equals, len(want))
+	for i := range want {
+		img, err := seq.At(i)
+		c.Assert(err, IsNil)
+		c.Check(img.GetImgInfo().SeqNum, Equals, want[i].GetImgInfo().SeqNum)
+		c.Check(img.GetImgInfo().CreationTs.Equal(want[i].GetImgInfo().CreationTs), Equals, true)
+		c.Check(img.GetImage(), DeepEquals, want[i].GetImage())
+	}
+	c.Check(seq.Close(), IsNil)
+}
+
+func (s *MySuite) TestStore(c *C) {
+	dir := filepath.Join(c.MkDir(), "store")
+	t0 := time.Date(2014, 5, 13, 16, 40, 0, 0, time.Local)
+	frames := storeFrames(12, t0, 10*time.Minute)
+	st, err := OpenStore(dir, DefaultStoreConfig)
+	c.Assert(err, IsNil)
+	c.Check(IsStore(dir), Equals, true)
+	for _, img := range frames {
+		c.Assert(st.Write(img), IsNil)
+	}
+	// 16:40-16:50, 17:00-17:50, 18:00-18:30
+	segs := st.Segments()
+	c.Assert(segs, HasLen, 3)
+	c.Check([]int{segs[0].Count, segs[1].Count, segs[2].Count}, DeepEquals, []int{2, 6, 4})
+	c.Check(filepath.Dir(segs[1].Path), Equals, filepath.FromSlash("2014/05/13/17"))
+	c.Check(segs[1].Start.Equal(t0.Add(20*time.Minute)), Equals, true)
+	c.Check(segs[1].End.Equal(t0.Add(70*time.Minute)), Equals, true)
+
+	// The current segment can be read before it's finished.
+	seq, err := st.Range(time.Time{}, time.Time{})
+	c.Assert(err, IsNil)
+	checkRange(c, seq, frames)
+	c.Assert(st.Close(), IsNil)
+
+	st, err = OpenStore(dir, DefaultStoreConfig)
+	c.Assert(err, IsNil)
+	c.Check(st.Segments(), DeepEquals, segs)
+	seq, err = st.Range(t0.Add(15*time.Minute), t0.Add(90*time.Minute))
+	c.Assert(err, IsNil)
+	c.Check(seq.IndexAt(t0.Add(60*time.Minute)), Equals, 4)
+	checkRange(c, seq, frames[2:9])
+	seq, err = st.Range(t0.Add(time.Hour), t0)
+	c.Assert(err, IsNil)
+	c.Check(seq.Len(), Equals, 0)
+
+	// Without an index the segments are found by scanning.
+	c.Assert(os.Remove(filepath.Join(dir, StoreIndexFile)), IsNil)
+	st, err = OpenStore(dir, DefaultStoreConfig)
+	c.Assert(err, IsNil)
+	c.Check(st.Segments(), DeepEquals, segs)
+}
+
+func (s *MySuite) TestStoreZone(c *C) {
+	// Segments follow the wall clock of the frames' zone, whatever the
+	// machine's zone is, even when its offset isn't a whole number of hours.
+	ist := time.FixedZone("IST", 5*3600+1800)
+	t0 := time.Date(2014, 5, 13, 16, 40, 0, 0, ist)
+	st, err := OpenStore(c.MkDir(), DefaultStoreConfig)
+	c.Assert(err, IsNil)
+	for _, img := range storeFrames(12, t0, 10*time.Minute) {
+		c.Assert(st.Write(img), IsNil)
+	}
+	segs := st.Segments()
+	c.Assert(segs, HasLen, 3)
+	c.Check([]int{segs[0].Count, segs[1].Count, segs[2].Count}, DeepEquals, []int{2, 6, 4})
+	c.Check(filepath.Dir(segs[1].Path), Equals, filepath.FromSlash("2014/05/13/17"))
+	c.Assert(st.Close(), IsNil)
+
+	// Daily segments start at midnight.
+	st, err = OpenStore(c.MkDir(), StoreConfig{SegmentDuration: 24 * time.Hour})
+	c.Assert(err, IsNil)
+	for _, img := range storeFrames(4, time.Date(2014, 5, 13, 22, 0, 0, 0, ist), time.Hour) {
+		c.Assert(st.Write(img), IsNil)
+	}
+	segs = st.Segments()
+	c.Assert(segs, HasLen, 2)
+	c.Check([]int{segs[0].Count, segs[1].Count}, DeepEquals, []int{2, 2})
+	c.Check(filepath.Dir(segs[1].Path), Equals, filepath.FromSlash("2014/05/14/00"))
+	c.Assert(st.Close(), IsNil)
+}
+
+func (s *MySuite) TestStoreRecovery(c *C) {
+	dir := c.MkDir()
+	t0 := time.Date(2014, 5, 13, 16, 0, 0, 0, time.Local)
+	frames := storeFrames(3, t0, time.Second)
+	st, err := OpenStore(dir, DefaultStoreConfig)
+	c.Assert(err, IsNil)
+	for _, img := range frames {
+		c.Assert(st.Write(img), IsNil)
+	}
+	// Reopening without closing first, as after a crash, finds all the frames.
+	st2, err := OpenStore(dir, DefaultStoreConfig)
+	c.Assert(err, IsNil)
+	c.Assert(st2.Segments(), HasLen, 1)
+	c.Check(st2.Segments()[0].Count, Equals, 3)
+	c.Check(st.Close(), IsNil)
+
+	// A change of size starts a new segment.
+	big := &RawImg{ImgInfo{SeqNum: 3, CreationTs: t0.Add(3 * time.Second)},
+		imglib.GetPixelSequence(imglib.NewYUYV(image.Rect(0, 0, 6, 2)))}
+	c.Assert(st2.Write(big), IsNil)
+	c.Check(st2.Segments(), HasLen, 2)
+	c.Check(st2.Close(), IsNil)
+}
+
+func (s *MySuite) TestStoreRetention(c *C) {
+	dir := c.MkDir()
+	t0 := time.Date(2014, 5, 13, 16, 0, 0, 0, time.Local)
+	frames := storeFrames(10, t0, time.Minute)
+	st, err := OpenStore(dir, StoreConfig{SegmentDuration: 2 * time.Minute, MaxAge: 3 * time.Minute})
+	c.Assert(err, IsNil)
+	for _, img := range frames {
+		c.Assert(st.Write(img), IsNil)
+	}
+	// At 16:09 only segments whose last frame is no older than 16:06 remain.
+	segs := st.Segments()
+	c.Assert(segs, HasLen, 2)
+	c.Check(segs[0].Start.Equal(t0.Add(6*time.Minute)), Equals, true)
+	_, err = os.Stat(filepath.Join(dir, filepath.FromSlash("2014/05/13/16")))
+	c.Check(err, IsNil)
+	c.Assert(st.Close(), IsNil)
+
+	dir = c.MkDir()
+	st, err = OpenStore(dir, StoreConfig{SegmentDuration: time.Minute, MaxBytes: 1})
+	c.Assert(err, IsNil)
+	t1 := time.Date(2014, 5, 13, 23, 59, 0, 0, time.Local)
+	for _, img := range storeFrames(2, t1, time.Minute) {
+		c.Assert(st.Write(img), IsNil)
+	}
+	// Only the current segment is left, and the emptied directories are gone.
+	c.Assert(st.Segments(), HasLen, 1)
+	_, err = os.Stat(filepath.Join(dir, filepath.FromSlash("2014/05/13")))
+	c.Check(os.IsNotExist(err), Equals, true)
+	c.Assert(st.Close(), IsNil)
+}
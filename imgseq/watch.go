@@ -0,0 +1,327 @@
+package imgseq
+
+import (
+	"code.google.com/p/ncabatoff/imglib"
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// WatchConfig controls WatchDir and FollowRawFile.
+type WatchConfig struct {
+	// Existing says whether images already present when watching starts are
+	// sent too, or only those which arrive afterwards.
+	Existing bool
+	// Poll is how often files are checked for changes.  With change
+	// notification it only matters for files still being written.
+	Poll time.Duration
+	// Settle is how long a file must go unchanged before it's taken to be
+	// completely written, when that isn't known from a notification.
+	Settle time.Duration
+	// PollOnly disables change notification, e.g. for network filesystems
+	// where changes made by other machines aren't reported.
+	PollOnly bool
+	// Policy says what to do with files which can't be loaded.
+	Policy ErrorPolicy
+}
+
+// DefaultWatchConfig suits following a capture writing a few frames a second.
+var DefaultWatchConfig = WatchConfig{Poll: 250 * time.Millisecond, Settle: time.Second, Policy: SkipOnError}
+
+// notifyEvent is a change reported by a notifier, relative to the directory
+// or file it watches.  If dir is set, the directory rel appeared and should be
+// scanned for files.  Otherwise the file rel was created, or if done is set
+// it was finished: closed after writing, moved into place, or for a watched
+// file, appended to.
+type notifyEvent struct {
+	rel  string
+	dir  bool
+	done bool
+}
+
+// pendingFile is a file seen by a watcher but not yet sent.
+type pendingFile struct {
+	rel     string
+	t       time.Time
+	named   bool
+	seqnum  int
+	done    bool
+	size    int64
+	mtime   time.Time
+	changed time.Time
+}
+
+// before returns whether pf sorts before the file rel created at t, named
+// says if t is known.  Files without times come first, as for ScanDir.
+func (pf *pendingFile) before(rel string, t time.Time, named bool) bool {
+	if pf.named != named {
+		return named
+	}
+	return pf.t.Before(t) || pf.t.Equal(t) && pf.rel < rel
+}
+
+// watcher holds the state of WatchDir.
+type watcher struct {
+	dir     string
+	opts    ScanOptions
+	cfg     WatchConfig
+	pending map[string]*pendingFile
+	// last is the most recent named file sent, or nil.  Named files which
+	// sort before it have already been dealt with; unnamed ones are
+	// remembered in sent instead.
+	last  *pendingFile
+	sent  map[string]bool
+	count int
+}
+
+// WatchDir sends the images in dir chosen by opts to imagechan as they
+// arrive, in creation time order, until ctx is cancelled, when it closes
+// imagechan and returns ctx.Err().  On Linux inotify reports new files
+// promptly; elsewhere, or with cfg.PollOnly, dir is rescanned every cfg.Poll,
+// which is slow for huge directories.
+//
+// A file isn't loaded until it's been completely written: until it's closed
+// after writing or moved into dir, or failing a notification of that, until
+// its size and modification time have been unchanged for cfg.Settle, or it
+// was last modified more than cfg.Settle ago.  A file
+// still being written holds back any newer ones so that the order is kept.
+// Files arriving out of order, with a creation time before that of an image
+// already sent, are ignored.  Files which can't be loaded are dealt with
+// according to cfg.Policy.  SeqNum is the number of images sent before,
+// unless the filenames include sequence numbers.
+func WatchDir(ctx context.Context, dir string, opts ScanOptions, cfg WatchConfig, imagechan chan<- Img) error {
+	defer close(imagechan)
+	w := &watcher{dir: dir, opts: opts, cfg: cfg, pending: make(map[string]*pendingFile), sent: make(map[string]bool)}
+	var events <-chan notifyEvent
+	if !cfg.PollOnly {
+		// Start watching before the first scan so that nothing is missed.
+		if n, err := watchTree(dir, opts.Recursive); err != nil {
+			glog.Warningf("polling '%s': %v", dir, err)
+		} else {
+			defer n.Close()
+			events = n.events
+		}
+	}
+	if err := w.scan(""); err != nil {
+		return err
+	}
+	if !cfg.Existing {
+		for _, pf := range w.sorted() {
+			w.mark(pf)
+		}
+		w.pending = make(map[string]*pendingFile)
+	}
+	ticker := time.NewTicker(cfg.Poll)
+	defer ticker.Stop()
+	for {
+		if err := w.send(ctx, imagechan); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-events:
+			if !ok {
+				glog.Warningf("change notification for '%s' stopped, polling", dir)
+				events = nil
+			} else if ev.dir {
+				if err := w.scan(ev.rel); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			} else if _, t, named := opts.names().Parse(ev.rel); opts.match(filepath.Base(ev.rel), t, named) {
+				if pf := w.add(ev.rel, t, named); pf != nil && ev.done {
+					pf.done = true
+				}
+			}
+		case <-ticker.C:
+			if events == nil {
+				if err := w.scan(""); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// scan adds the files wanted in the directory rel to w.pending.
+func (w *watcher) scan(rel string) error {
+	return walk(w.dir, rel, w.opts, func(rel string, t time.Time, named bool) error {
+		w.add(rel, t, named)
+		return nil
+	})
+}
+
+// add returns the pending entry for the file rel created at t, adding one if
+// need be, or nil if the file has already been dealt with.
+func (w *watcher) add(rel string, t time.Time, named bool) *pendingFile {
+	if pf, ok := w.pending[rel]; ok {
+		return pf
+	}
+	if named && w.last != nil && !w.last.before(rel, t, named) || !named && w.sent[rel] {
+		return nil
+	}
+	pf := &pendingFile{rel: rel, t: t, named: named, size: -1, changed: time.Now()}
+	if named && w.opts.names().SeqDigits > 0 {
+		pf.seqnum, _, _ = w.opts.names().Parse(rel)
+	}
+	w.pending[rel] = pf
+	return pf
+}
+
+// mark records that pf has been dealt with.
+func (w *watcher) mark(pf *pendingFile) {
+	delete(w.pending, pf.rel)
+	if pf.named {
+		w.last = pf
+	} else {
+		w.sent[pf.rel] = true
+	}
+}
+
+// sorted returns the pending files in the order they're to be sent.
+func (w *watcher) sorted() []*pendingFile {
+	pfs := make([]*pendingFile, 0, len(w.pending))
+	for _, pf := range w.pending {
+		pfs = append(pfs, pf)
+	}
+	sort.Slice(pfs, func(i, j int) bool {
+		return pfs[i].before(pfs[j].rel, pfs[j].t, pfs[j].named)
+	})
+	return pfs
+}
+
+// settled returns whether pf has been completely written as of now.
+// Files which have disappeared are dropped.
+func (w *watcher) settled(pf *pendingFile, now time.Time) (bool, error) {
+	if pf.done {
+		return true, nil
+	}
+	fi, err := os.Stat(filepath.Join(w.dir, pf.rel))
+	if os.IsNotExist(err) {
+		delete(w.pending, pf.rel)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if fi.Size() != pf.size || !fi.ModTime().Equal(pf.mtime) {
+		pf.size, pf.mtime, pf.changed = fi.Size(), fi.ModTime(), now
+	}
+	return now.Sub(pf.changed) >= w.cfg.Settle || now.Sub(pf.mtime) >= w.cfg.Settle, nil
+}
+
+// send loads and sends the pending files which have been completely written,
+// stopping at the first which hasn't.
+func (w *watcher) send(ctx context.Context, imagechan chan<- Img) error {
+	now := time.Now()
+	for _, pf := range w.sorted() {
+		if ok, err := w.settled(pf, now); err != nil {
+			return err
+		} else if !ok {
+			if _, still := w.pending[pf.rel]; still {
+				return nil
+			}
+			continue
+		}
+		w.mark(pf)
+		ii := ImgInfo{SeqNum: w.count, CreationTs: pf.t, Path: filepath.Join(w.dir, pf.rel)}
+		if w.opts.names().SeqDigits > 0 && pf.named {
+			ii.SeqNum = pf.seqnum
+		}
+		img, err := LoadRawImg(ii)
+		if err != nil {
+			if err = w.cfg.Policy.handle(ii, err); err != nil {
+				return err
+			}
+			continue
+		}
+		w.count++
+		select {
+		case imagechan <- img:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// FollowRawFile sends the images in the file at path, which holds
+// concatenated headerless images laid out as rf, to imagechan as they're
+// appended to it, until ctx is cancelled, when it closes imagechan and
+// returns ctx.Err().  A partly written image is sent once it's complete.  The
+// file has no timestamps, so CreationTs is the time each image is read, and
+// SeqNum its index in the file.  If the file shrinks it's assumed to have been
+// rewritten and is followed from the start.  cfg.Settle and cfg.Policy aren't
+// used.
+func FollowRawFile(ctx context.Context, path string, rf imglib.RawFormat, cfg WatchConfig, imagechan chan<- Img) error {
+	defer close(imagechan)
+	fsz := int64(rf.FrameSize())
+	if fsz <= 0 {
+		return fmt.Errorf("invalid raw format %v", rf)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var events <-chan notifyEvent
+	if !cfg.PollOnly {
+		if n, err := watchFile(path); err != nil {
+			glog.Warningf("polling '%s': %v", path, err)
+		} else {
+			defer n.Close()
+			events = n.events
+		}
+	}
+	var off int64
+	if !cfg.Existing {
+		if fi, err := file.Stat(); err != nil {
+			return err
+		} else {
+			off = fi.Size() / fsz * fsz
+		}
+	}
+	ticker := time.NewTicker(cfg.Poll)
+	defer ticker.Stop()
+	for {
+		fi, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() < off {
+			glog.Warningf("'%s' shrank from %d to %d bytes, following from the start", path, off, fi.Size())
+			off = 0
+		}
+		for ; off+fsz <= fi.Size(); off += fsz {
+			pix := make([]byte, fsz)
+			if _, err := file.ReadAt(pix, off); err != nil {
+				return err
+			}
+			img, err := rf.Format.NewImage(pix, rf.GetStride(), image.Rect(0, 0, rf.Width, rf.Height))
+			if err != nil {
+				return err
+			}
+			ii := ImgInfo{SeqNum: int(off / fsz), CreationTs: time.Now(), Path: path}
+			select {
+			case imagechan <- &RawImg{ii, imglib.GetPixelSequence(img)}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				glog.Warningf("change notification for '%s' stopped, polling", path)
+				events = nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
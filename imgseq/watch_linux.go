@@ -0,0 +1,169 @@
+//go:build linux
+// +build linux
+
+package imgseq
+
+import (
+	"bytes"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// notifier reports changes to a directory tree or a file on its events
+// channel, using inotify.
+type notifier struct {
+	file      *os.File
+	fd        int
+	path      string
+	recursive bool
+	// watches maps inotify watch descriptors to the directories they watch,
+	// relative to path.  It's only used by run once that's started.
+	watches map[int32]string
+	events  chan notifyEvent
+	quit    chan struct{}
+}
+
+const (
+	treeMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO
+	fileMask = syscall.IN_MODIFY
+)
+
+func newNotifier(path string, recursive bool) (*notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// Being non-blocking the file is read through the runtime poller, so
+	// closing it interrupts a read in progress.  File.Fd would undo that.
+	return &notifier{file: os.NewFile(uintptr(fd), "inotify"), fd: fd, path: path, recursive: recursive,
+		watches: make(map[int32]string), events: make(chan notifyEvent), quit: make(chan struct{})}, nil
+}
+
+// watchTree returns a notifier for the files created in dir and, if
+// recursive, in its subdirectories other than QuarantineDir.
+func watchTree(dir string, recursive bool) (*notifier, error) {
+	n, err := newNotifier(dir, recursive)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.addTree(""); err != nil {
+		n.file.Close()
+		return nil, err
+	}
+	go n.run()
+	return n, nil
+}
+
+// watchFile returns a notifier for appends to the file at path.
+func watchFile(path string) (*notifier, error) {
+	n, err := newNotifier(path, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := n.add("", fileMask); err != nil {
+		n.file.Close()
+		return nil, err
+	}
+	go n.run()
+	return n, nil
+}
+
+func (n *notifier) add(rel string, mask uint32) error {
+	wd, err := syscall.InotifyAddWatch(n.fd, filepath.Join(n.path, rel), mask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	n.watches[int32(wd)] = rel
+	return nil
+}
+
+// addTree watches the directory rel and, if n.recursive, its subdirectories.
+func (n *notifier) addTree(rel string) error {
+	if err := n.add(rel, treeMask); err != nil || !n.recursive {
+		return err
+	}
+	fd, err := os.Open(filepath.Join(n.path, rel))
+	if err != nil {
+		return err
+	}
+	fs, err := fd.Readdirnames(-1)
+	fd.Close()
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		if sub := filepath.Join(rel, f); filepath.Ext(f) == "" && isSubdir(n.path, sub) {
+			if err := n.addTree(sub); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// run reads inotify events and translates them until the notifier is closed.
+func (n *notifier) run() {
+	defer close(n.events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		nr, err := n.file.Read(buf)
+		if err != nil {
+			select {
+			case <-n.quit:
+			default:
+				glog.Errorf("error reading inotify events for '%s': %v", n.path, err)
+			}
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= nr; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			off = start + int(ev.Len)
+			name := string(bytes.TrimRight(buf[start:off], "\x00"))
+			if nev, ok := n.translate(ev, name); ok {
+				select {
+				case n.events <- nev:
+				case <-n.quit:
+					return
+				}
+			}
+		}
+	}
+}
+
+// translate returns the notifyEvent for ev, which concerns the file name in
+// the watched directory, if there is one.
+func (n *notifier) translate(ev *syscall.InotifyEvent, name string) (notifyEvent, bool) {
+	if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+		// Events were lost, so everything must be looked at again.
+		return notifyEvent{dir: true}, true
+	}
+	dir, ok := n.watches[ev.Wd]
+	if !ok {
+		return notifyEvent{}, false
+	}
+	if ev.Mask&syscall.IN_IGNORED != 0 {
+		delete(n.watches, ev.Wd)
+		return notifyEvent{}, false
+	}
+	rel := filepath.Join(dir, name)
+	if ev.Mask&syscall.IN_ISDIR != 0 {
+		if !n.recursive || filepath.Ext(name) != "" || name == QuarantineDir || ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) == 0 {
+			return notifyEvent{}, false
+		}
+		if err := n.addTree(rel); err != nil {
+			glog.Warningf("unable to watch '%s': %v", filepath.Join(n.path, rel), err)
+		}
+		return notifyEvent{rel: rel, dir: true}, true
+	}
+	return notifyEvent{rel: rel, done: ev.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO|syscall.IN_MODIFY) != 0}, true
+}
+
+// Close stops the notifier.
+func (n *notifier) Close() error {
+	close(n.quit)
+	return n.file.Close()
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package imgseq
+
+import "errors"
+
+// errNoNotify is returned by watchTree and watchFile on platforms without
+// change notification, so that the callers fall back to polling.
+var errNoNotify = errors.New("change notification isn't supported on this platform")
+
+// notifier would report changes on its events channel.
+type notifier struct {
+	events chan notifyEvent
+}
+
+func watchTree(dir string, recursive bool) (*notifier, error) {
+	return nil, errNoNotify
+}
+
+func watchFile(path string) (*notifier, error) {
+	return nil, errNoNotify
+}
+
+func (n *notifier) Close() error {
+	return nil
+}
@@ -0,0 +1,147 @@
+package imgseq
+
+import . "gopkg.in/check.v1"
+
+import "code.google.com/p/ncabatoff/imglib"
+import "context"
+import "os"
+import "path/filepath"
+import "time"
+
+// receive returns the next image from imagechan, failing if none arrives
+// within a few seconds.
+func receive(c *C, imagechan <-chan Img) Img {
+	select {
+	case img, ok := <-imagechan:
+		c.Assert(ok, Equals, true)
+		return img
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for image")
+	}
+	return nil
+}
+
+// checkNone verifies that nothing arrives on imagechan for a little while.
+func checkNone(c *C, imagechan <-chan Img) {
+	select {
+	case img := <-imagechan:
+		c.Errorf("unexpected image %v", img.GetImgInfo())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// watchTestFile returns the path in dir of the test image created at t.
+func watchTestFile(dir string, t int64) string {
+	return filepath.Join(dir, TimeToFname(defaultPrefix, time.Unix(0, t))+".yuv")
+}
+
+func (s *MySuite) TestWatchDir(c *C) {
+	dir := c.MkDir()
+	imgs := testYuyvs(4)
+	c.Assert(imglib.WriteRawInfo(dir, imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2}), IsNil)
+	c.Assert(imgs[0].StoreRaw(watchTestFile(dir, 1)), IsNil)
+	old := time.Now().Add(-2 * time.Hour)
+	c.Assert(os.Chtimes(watchTestFile(dir, 1), old, old), IsNil)
+	if n, err := watchTree(dir, false); err != nil {
+		c.Skip(err.Error())
+	} else {
+		n.Close()
+	}
+
+	// New files are only finished when closed, so a long Settle shows that
+	// a partly written file isn't loaded.
+	cfg := WatchConfig{Existing: true, Poll: 10 * time.Millisecond, Settle: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	imagechan := make(chan Img)
+	errchan := make(chan error, 1)
+	go func() { errchan <- WatchDir(ctx, dir, ScanOptions{}, cfg, imagechan) }()
+	img := receive(c, imagechan)
+	c.Check(img.GetImgInfo(), Equals, ImgInfo{SeqNum: 0, CreationTs: time.Unix(0, 1), Path: watchTestFile(dir, 1)})
+	c.Check(img.GetImage(), DeepEquals, imgs[0])
+
+	partial, err := os.Create(watchTestFile(dir, 2))
+	c.Assert(err, IsNil)
+	_, err = partial.Write(imgs[1].Pix[:3])
+	c.Assert(err, IsNil)
+	c.Assert(imgs[2].StoreRaw(watchTestFile(dir, 3)), IsNil)
+	checkNone(c, imagechan)
+
+	_, err = partial.Write(imgs[1].Pix[3:])
+	c.Assert(err, IsNil)
+	c.Assert(partial.Close(), IsNil)
+	for i := 1; i <= 2; i++ {
+		img := receive(c, imagechan)
+		c.Check(img.GetImgInfo().SeqNum, Equals, i)
+		c.Check(img.GetImage(), DeepEquals, imgs[i])
+	}
+
+	// Files older than those already sent are ignored.
+	c.Assert(imgs[3].StoreRaw(watchTestFile(dir, 0)), IsNil)
+	checkNone(c, imagechan)
+
+	cancel()
+	for range imagechan {
+	}
+	c.Check(<-errchan, Equals, context.Canceled)
+}
+
+func (s *MySuite) TestWatchDirPoll(c *C) {
+	dir := c.MkDir()
+	imgs := testYuyvs(2)
+	c.Assert(os.Mkdir(filepath.Join(dir, "sub"), 0755), IsNil)
+	c.Assert(imglib.WriteRawInfo(filepath.Join(dir, "sub"), imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2}), IsNil)
+	c.Assert(imgs[0].StoreRaw(watchTestFile(filepath.Join(dir, "sub"), 1)), IsNil)
+
+	cfg := WatchConfig{Poll: 10 * time.Millisecond, Settle: 20 * time.Millisecond, PollOnly: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	imagechan := make(chan Img)
+	go WatchDir(ctx, dir, ScanOptions{Recursive: true}, cfg, imagechan)
+	checkNone(c, imagechan)
+
+	c.Assert(imgs[1].StoreRaw(watchTestFile(filepath.Join(dir, "sub"), 2)), IsNil)
+	img := receive(c, imagechan)
+	c.Check(img.GetImgInfo().Path, Equals, watchTestFile(filepath.Join(dir, "sub"), 2))
+	c.Check(img.GetImage(), DeepEquals, imgs[1])
+}
+
+func (s *MySuite) TestFollowRawFile(c *C) {
+	path := filepath.Join(c.MkDir(), "capture.yuv")
+	imgs := testYuyvs(3)
+	file, err := os.Create(path)
+	c.Assert(err, IsNil)
+	defer file.Close()
+	_, err = file.Write(imgs[0].Pix)
+	c.Assert(err, IsNil)
+
+	for _, pollOnly := range []bool{false, true} {
+		cfg := WatchConfig{Existing: true, Poll: 10 * time.Millisecond, PollOnly: pollOnly}
+		rf := imglib.RawFormat{Format: imglib.PixelFormatYUYV, Width: 4, Height: 2}
+		ctx, cancel := context.WithCancel(context.Background())
+		imagechan := make(chan Img)
+		errchan := make(chan error, 1)
+		go func() { errchan <- FollowRawFile(ctx, path, rf, cfg, imagechan) }()
+		for i := range imgs {
+			if i > 0 {
+				_, err = file.Write(imgs[i].Pix[:5])
+				c.Assert(err, IsNil)
+				checkNone(c, imagechan)
+				_, err = file.Write(imgs[i].Pix[5:])
+				c.Assert(err, IsNil)
+			}
+			img := receive(c, imagechan)
+			c.Check(img.GetImgInfo().SeqNum, Equals, i)
+			c.Check(img.GetImage(), DeepEquals, imgs[i])
+		}
+		cancel()
+		for range imagechan {
+		}
+		c.Check(<-errchan, Equals, context.Canceled)
+
+		c.Assert(file.Truncate(0), IsNil)
+		_, err = file.Seek(0, 0)
+		c.Assert(err, IsNil)
+		_, err = file.Write(imgs[0].Pix)
+		c.Assert(err, IsNil)
+	}
+}
@@ -0,0 +1,59 @@
+package imgseq
+
+import "io"
+import "os"
+import "code.google.com/p/ncabatoff/imglib"
+
+// LoadY4MImgs reads the YUV4MPEG2 stream at path and sends each frame to
+// imagechan as a YUYV RawImg, closing imagechan when done.  SeqNum is the
+// frame's position in the stream.
+func LoadY4MImgs(path string, imagechan chan<- Img) error {
+	defer close(imagechan)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	yr, err := imglib.NewY4MReader(file)
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		yuyv, err := yr.ReadYUYV()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		iinfo := ImgInfo{SeqNum: i, Path: path}
+		imagechan <- &RawImg{iinfo, imglib.GetPixelSequence(yuyv)}
+	}
+}
+
+// WriteY4M writes each image received from imagechan to w as a YUV4MPEG2
+// stream with the given frame rate.  The stream geometry is taken from the
+// first image and 4:2:2 chroma is used, which is lossless for YUYV input.
+// imagechan is read until it's closed even if writing fails, so that its
+// producer isn't left blocked.
+func WriteY4M(w io.Writer, fpsNum, fpsDen int, imagechan <-chan Img) error {
+	var yw *imglib.Y4MWriter
+	var err error
+	for img := range imagechan {
+		if err != nil {
+			continue
+		}
+		if yw == nil {
+			sz := img.GetImage().Bounds().Size()
+			hdr := imglib.Y4MHeader{Width: sz.X, Height: sz.Y, FrameRateNum: fpsNum, FrameRateDen: fpsDen,
+				Interlace: "p", AspectNum: 1, AspectDen: 1, Chroma: "422"}
+			if yw, err = imglib.NewY4MWriter(w, hdr); err != nil {
+				continue
+			}
+		}
+		err = yw.WriteImage(img.GetImage())
+	}
+	if err != nil || yw == nil {
+		return err
+	}
+	return yw.Flush()
+}
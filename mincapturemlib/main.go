@@ -5,13 +5,11 @@ package mincapturemlib
 
 import (
 	"code.google.com/p/ncabatoff/imglib"
+	"code.google.com/p/ncabatoff/imglib/imgdraw"
 	"code.google.com/p/ncabatoff/imgseq"
 	"code.google.com/p/ncabatoff/motion"
 	"code.google.com/p/ncabatoff/v4l"
 	"code.google.com/p/ncabatoff/vlib"
-	"image"
-	"image/color"
-	"image/draw"
 	"sort"
 )
 
@@ -37,21 +35,10 @@ func Run(device string, deltaThresh int) {
 func trackRects(deltaThresh int, trk *motion.Tracker, img imgseq.Img) imgseq.Img {
 	if rs := trk.GetRects(img, deltaThresh); len(rs) > 0 {
 		sort.Sort(motion.RectAreaSlice(rs))
-		rimg := getRectImage(img.GetImage(), rs)
+		rimg := imgdraw.IsolateRects(img.GetImage(), rs)
 		return &imgseq.RawImg{img.GetImgInfo(), imglib.GetPixelSequence(rimg)}
 	} else {
 		return nil
 	}
 
 }
-
-func getRectImage(img image.Image, rects []image.Rectangle) image.Image {
-    irect := img.Bounds()
-    out := image.NewRGBA(irect)
-    for _, r := range rects {
-            draw.Draw(out, r.Inset(-1), &image.Uniform{color.White}, image.ZP, draw.Src)
-            draw.Draw(out, r, img, r.Min, draw.Src)
-    }
-    return out
-}
-
@@ -4,8 +4,8 @@ import "fmt"
 import "image"
 import "code.google.com/p/ncabatoff/imglib"
 
-// LAVGN is how many frames get averaged.  Should be a power of two to ensure
-// that div() is quick.
+// LAVGN is how many frames get averaged by default.  It's a power of two to
+// ensure that div() is quick; other windows use windowSums.
 const LAVGN = 64
 
 // lnsum is used to do rolling averages cheaply; it's a running total
@@ -35,6 +35,9 @@ type sumslice interface {
 
 	// Add the values in b to the sums used to calculate averages.
 	add(b []byte)
+
+	// Return the sums of elements [i,j).
+	slice(i, j int) sumslice
 }
 
 type lnsumslc []lnsum
@@ -52,6 +55,40 @@ func (lns lnsumslc) add(b []byte) {
 	}
 }
 
+func (lns lnsumslc) slice(i, j int) sumslice {
+	return lns[i:j]
+}
+
+// windowSums is like lnsumslc but averages over the last n values rather than
+// LAVGN.
+type windowSums struct {
+	sums lnsumslc
+	n    lnsum
+}
+
+func (ws windowSums) rollSumDelta(b []byte, delta deltaslc, old []byte) {
+	for i, l := range ws.sums {
+		delta[i] = int(l/ws.n) - int(b[i])
+		ws.sums[i] = l.roll(old[i], b[i])
+	}
+}
+
+func (ws windowSums) add(b []byte) {
+	ws.sums.add(b)
+}
+
+func (ws windowSums) slice(i, j int) sumslice {
+	return windowSums{ws.sums[i:j], ws.n}
+}
+
+// addRows adds each row of ps to the packed sums.
+func addRows(sums sumslice, ps imglib.PixelSequence) {
+	rb := ps.RowBytes()
+	for y := 0; y < ps.Dy; y++ {
+		sums.slice(y*rb, (y+1)*rb).add(ps.Row(y))
+	}
+}
+
 // Given a deltaslc, which is just a slice of ints, a columnDeltaFinder
 // knows how to interpret that slice in a color-based way.
 
@@ -76,8 +113,8 @@ func getDeltasGray(dy int) int {
 }
 
 func (gcdf grayColumnDeltaFinder) find(d deltaslc) []int {
-	for i := 0; i < len(gcdf); i += 2 {
-		gcdf[i/2] = getDeltasGray(d[i])
+	for i := range gcdf {
+		gcdf[i] = getDeltasGray(d[i])
 	}
 	return []int(gcdf)
 }
@@ -124,11 +161,31 @@ func (rcdf rgbColumnDeltaFinder) find(d deltaslc) []int {
 	return []int(rcdf)
 }
 
-// deltaFinder is used to update sums and find 1D rects for a single pixel row
+type rgbaColumnDeltaFinder []int
+type rgbaColumnDeltaFinderBuilder int
+
+func (rcdfb rgbaColumnDeltaFinderBuilder) build() columnDeltaFinder {
+	return make(rgbaColumnDeltaFinder, int(rcdfb))
+}
+
+// Like rgbColumnDeltaFinder.find, but skipping over the alpha channel.
+func (rcdf rgbaColumnDeltaFinder) find(d deltaslc) []int {
+	p := 0
+	for i := 0; i < len(rcdf); i++ {
+		rcdf[i] = getDeltasRgb(d[p], d[p+1], d[p+2])
+		p += 4
+	}
+	return []int(rcdf)
+}
+
+// deltaFinder is used to update sums and find 1D rects for a single pixel row.
+// The sums are packed even if the pixel rows aren't, so sumoff advances by
+// RowBytes while the PixelRow offsets advance by the stride.
 type deltaFinder struct {
 	oldps     imglib.PixelRow
 	newps     imglib.PixelRow
-	sums      lnsumslc
+	sums      sumslice
+	sumoff    int
 	deltaT    int
 	y         int
 	maxy      int
@@ -147,18 +204,18 @@ func (df deltaFinder) String() string {
 	return fmt.Sprintf("y=%d/%d off=%2d", df.y, df.maxy, off)
 }
 
-func newDeltaFinderJob(lnsums lnsumslc, oldps, newps imglib.PixelRow, deltaT, y, maxy int, cdf columnDeltaFinder) *deltaFinderJob {
+func newDeltaFinderJob(lnsums sumslice, oldps, newps imglib.PixelRow, deltaT, y, maxy int, cdf columnDeltaFinder) *deltaFinderJob {
 	df := deltaFinder{oldps: oldps, newps: newps, sums: lnsums, deltaT: deltaT, y: y, maxy: maxy}
-	df.deltas = make(deltaslc, newps.GetStride())
+	df.sumoff = y * newps.RowBytes()
+	df.deltas = make(deltaslc, newps.RowBytes())
 	df.coldeltas = cdf
 	df.rects = make([]image.Rectangle, newps.Dx/2)
 	return &deltaFinderJob{deltaFinder: df, result: make([]RowRects, maxy-y)}
 }
 
 func (df *deltaFinder) findRects() {
-	off := df.newps.Offset
-	start, end := off, off+df.newps.GetStride()
-	df.sums[start:end].rollSumDelta(df.newps.GetBytes(), df.deltas, df.oldps.GetBytes())
+	start, end := df.sumoff, df.sumoff+df.newps.RowBytes()
+	df.sums.slice(start, end).rollSumDelta(df.newps.GetBytes(), df.deltas, df.oldps.GetBytes())
 
 	df.rects = df.rects[:0]
 	for x, cd := range df.coldeltas.find(df.deltas) {
@@ -179,22 +236,27 @@ func (df *deltaFinder) next() bool {
 	}
 	df.oldps.Offset += df.oldps.GetStride()
 	df.newps.Offset += df.newps.GetStride()
+	df.sumoff += df.newps.RowBytes()
 	df.y++
 	df.rects = df.rects[:0]
 	return true
 }
 
-func buildDeltaFinderJobs(dfjs []deltaFinderJob, oldps, newps imglib.PixelSequence, sums lnsumslc, deltaT int, cdfb columnDeltaFinderBuilder) []RowRects {
+func buildDeltaFinderJobs(dfjs []deltaFinderJob, oldps, newps imglib.PixelSequence, sums sumslice, deltaT int, cdfb columnDeltaFinderBuilder) []RowRects {
 	dfsize := oldps.Dy / len(dfjs)
-	y, p, stride := 0, 0, newps.GetStride()
+	y := 0
 	rrs := make([]RowRects, oldps.Dy)
 
 	for i := range dfjs {
-		o, n := imglib.PixelRow{oldps, p}, imglib.PixelRow{newps, p}
-		dfjs[i] = *newDeltaFinderJob(sums[p:p+stride], o, n, deltaT, y, y+dfsize, cdfb.build())
-		dfjs[i].result = rrs[y : y+dfsize]
-		p += stride
-		y += dfsize
+		maxy := y + dfsize
+		if i == len(dfjs)-1 {
+			maxy = oldps.Dy
+		}
+		o := imglib.PixelRow{PixelSequence: oldps, Offset: oldps.RowOffset(y)}
+		n := imglib.PixelRow{PixelSequence: newps, Offset: newps.RowOffset(y)}
+		dfjs[i] = *newDeltaFinderJob(sums, o, n, deltaT, y, maxy, cdfb.build())
+		dfjs[i].result = rrs[y:maxy]
+		y = maxy
 	}
 	return rrs
 }
@@ -211,7 +273,11 @@ func (dfj *deltaFinderJob) run() {
 	}
 }
 
-func buildHeightOneRects(opxq, npxq imglib.PixelSequence, sums lnsumslc, t int, cdfb columnDeltaFinderBuilder) []RowRects {
+// buildHeightOneRects compares each row of npxq with the averages in sums,
+// returning the runs of pixels that differ by more than t, and rolls the
+// averages forward, subtracting opxq, the frame leaving the window.  Models
+// which don't keep old frames ignore opxq, and are passed npxq.
+func buildHeightOneRects(opxq, npxq imglib.PixelSequence, sums sumslice, t int, cdfb columnDeltaFinderBuilder) []RowRects {
 	numtasks := 1 // npxq.Dy / 16
 	if numtasks < 1 {
 		numtasks = 1
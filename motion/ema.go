@@ -0,0 +1,29 @@
+package motion
+
+// emaFrac is the number of fractional bits of the averages in an emaslc.
+const emaFrac = 16
+
+// emaslc holds exponential moving averages, in fixed point with emaFrac
+// fractional bits.  Each new value is given weight 1/w, so unlike lnsumslc
+// no old values need to be kept to subtract.
+type emaslc struct {
+	avgs []int32
+	w    int32
+}
+
+func (es emaslc) rollSumDelta(b []byte, delta deltaslc, old []byte) {
+	for i, a := range es.avgs {
+		delta[i] = int((a+1<<(emaFrac-1))>>emaFrac) - int(b[i])
+		es.avgs[i] = a + (int32(b[i])<<emaFrac-a)/es.w
+	}
+}
+
+func (es emaslc) add(b []byte) {
+	for i, a := range es.avgs {
+		es.avgs[i] = a + (int32(b[i])<<emaFrac-a)/es.w
+	}
+}
+
+func (es emaslc) slice(i, j int) sumslice {
+	return emaslc{es.avgs[i:j], es.w}
+}
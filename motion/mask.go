@@ -0,0 +1,51 @@
+package motion
+
+import "code.google.com/p/ncabatoff/imgseq"
+import "image"
+
+// MaskOn is the value of pixels in a motion mask where activity was found.
+const MaskOn = 0xFF
+
+// GetMask is like GetRects but instead of joining the active pixels into
+// rectangles it returns them as a mask with the same bounds as img, in which
+// active pixels are MaskOn and the rest zero.  This allows the mask to be
+// cleaned up, e.g. with imgfilter's Open, before calling MaskRects.
+func (trk *Tracker) GetMask(img imgseq.Img, t int) *image.Gray {
+	ps := img.GetPixelSequence()
+	mask := image.NewGray(ps.Bounds())
+	for _, rr := range trk.getRects(img, t) {
+		for _, r := range rr {
+			i := r.Min.Y*mask.Stride + r.Min.X
+			for x := r.Min.X; x < r.Max.X; x++ {
+				mask.Pix[i] = MaskOn
+				i++
+			}
+		}
+	}
+	return mask
+}
+
+// MaskRects returns the rectangles formed by uniting adjacent nonzero pixels
+// of mask, in the coordinate space of mask.
+func MaskRects(mask *image.Gray) []image.Rectangle {
+	r := mask.Rect
+	rrs := make([]RowRects, r.Dy())
+	for y := range rrs {
+		row := mask.Pix[y*mask.Stride : y*mask.Stride+r.Dx()]
+		for x, v := range row {
+			if v == 0 {
+				continue
+			}
+			if n := len(rrs[y]); n > 0 && rrs[y][n-1].Max.X == x {
+				rrs[y][n-1].Max.X++
+			} else {
+				rrs[y] = append(rrs[y], image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	rects := FindConnectedRects(r.Dx(), rrs)
+	for i := range rects {
+		rects[i] = rects[i].Add(r.Min)
+	}
+	return rects
+}
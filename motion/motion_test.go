@@ -133,7 +133,8 @@ func (s *MySuite) TestYuvDeltas(c *C) {
 	z := color.YCbCr{}
 	newrow := []color.YCbCr{z, {Y: 1, Cb: 2}, z, {Cr: 3}}
 	yimg.SetRow(0, newrow)
-	c.Check(yimg.Pix, DeepEquals, []byte{0, 2, 1, 0, 0, 0, 0, 3})
+	// Pairs get the average of their pixels' chroma.
+	c.Check(yimg.Pix, DeepEquals, []byte{0, 1, 1, 0, 0, 0, 0, 2})
 
 	c.Check(imglib.GetImageBytes(yimg), DeepEquals, imglib.YuyvBytes(yimg.Pix))
 
@@ -142,7 +143,7 @@ func (s *MySuite) TestYuvDeltas(c *C) {
 	sum.rollSumDelta(yimg.Pix, deltas, oimg.Pix)
 
 	ycdf := yuvColumnDeltaFinderBuilder(yimg.Bounds().Dx()).build()
-	c.Check(ycdf.find(deltas), DeepEquals, []int{4, 5, 9, 9})
+	c.Check(ycdf.find(deltas), DeepEquals, []int{1, 2, 4, 4})
 }
 
 func heightOneRectsRgb(oimg, nimg *imglib.RGB, t int) ([]RowRects, lnsumslc) {
@@ -289,3 +290,191 @@ func (s *MySuite) TestTracker(c *C) {
 	emptyimg := &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(rimg)}
 	c.Check(trk.GetRects(emptyimg, 12), DeepEquals, rslc(image.Rect(1, 1, 3, 3)))
 }
+
+func (s *MySuite) TestWindowSums(c *C) {
+	b0 := []byte{0, 0, 0}
+	b1 := []byte{3, 6, 9}
+	ws := windowSums{make(lnsumslc, 3), 3}
+	delta := make(deltaslc, 3)
+	for i := 0; i < 3; i++ {
+		ws.rollSumDelta(b1, delta, b0)
+	}
+	c.Check(ws.sums, DeepEquals, lnsumslc{9, 18, 27})
+	c.Check(delta, DeepEquals, deltaslc{-1, -2, -3})
+	ws.slice(1, 3).rollSumDelta([]byte{0, 0}, delta, []byte{6, 9})
+	c.Check(ws.sums, DeepEquals, lnsumslc{9, 12, 18})
+	c.Check(delta[:2], DeepEquals, deltaslc{6, 9})
+}
+
+func (s *MySuite) TestEMA(c *C) {
+	es := emaslc{make([]int32, 2), 1}
+	es.add([]byte{100, 200})
+	delta := make(deltaslc, 2)
+	es.w = 4
+	es.rollSumDelta([]byte{60, 200}, delta, nil)
+	c.Check(delta, DeepEquals, deltaslc{40, 0})
+	es.rollSumDelta([]byte{60, 200}, delta, nil)
+	c.Check(delta, DeepEquals, deltaslc{30, 0})
+	// The average approaches a new value geometrically.
+	for i := 0; i < 30; i++ {
+		es.add([]byte{60, 200})
+	}
+	es.rollSumDelta([]byte{60, 200}, delta, nil)
+	c.Check(delta, DeepEquals, deltaslc{0, 0})
+}
+
+func (s *MySuite) TestVarianceSums(c *C) {
+	vs := varslc{make([]float32, 2), make([]float32, 2), 1, 4}
+	vs.add([]byte{100, 100})
+	vs.w = 2
+	vs.add([]byte{110, 100})
+	c.Check(vs.means, DeepEquals, []float32{105, 100})
+	c.Check(vs.vars, DeepEquals, []float32{25, 0})
+
+	// Deltas are in standard deviations, of at least 2.
+	delta := make(deltaslc, 2)
+	vs.w = 4
+	vs.rollSumDelta([]byte{95, 110}, delta, nil)
+	c.Check(delta, DeepEquals, deltaslc{2 * VarianceUnit, -5 * VarianceUnit})
+	// Outliers count for no more than varClip standard deviations.
+	c.Check(vs.means, DeepEquals, []float32{102.5, 101.25})
+	c.Check(vs.vars, DeepEquals, []float32{0.75 * (25 + 25), 0.75 * 25 / 4})
+}
+
+func (s *MySuite) TestBackgroundModel(c *C) {
+	for _, bm := range []BackgroundModel{WindowMean, EMA, Variance} {
+		got, err := ParseBackgroundModel(bm.String())
+		c.Check(err, IsNil)
+		c.Check(got, Equals, bm)
+	}
+	_, err := ParseBackgroundModel("median")
+	c.Check(err, NotNil)
+}
+
+// checkTrackerWarmup feeds trk its configured window of blank 4x4 frames,
+// then checks that a changed pixel is found.
+func checkTrackerWarmup(c *C, trk *Tracker) {
+	img := &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(imglib.NewRGB(image.Rect(0, 0, 4, 4)))}
+	for i := 0; i < trk.Config().Window; i++ {
+		c.Check(trk.GetRects(img, 12), DeepEquals, []image.Rectangle{})
+	}
+	rimg := imglib.NewRGB(image.Rect(0, 0, 4, 4))
+	rimg.SetRGBA(2, 1, color.RGBA{10, 20, 30, 0xFF})
+	fg := &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(rimg)}
+	c.Check(trk.GetRects(fg, 12), DeepEquals, rslc(image.Rect(2, 1, 3, 2)))
+}
+
+func (s *MySuite) TestTrackerWindow(c *C) {
+	trk := NewTrackerWithConfig(TrackerConfig{Window: 5})
+	checkTrackerWarmup(c, trk)
+	c.Check(trk.frameRing.Size(), Equals, 5)
+	c.Check(NewTrackerWithConfig(TrackerConfig{}).Config(), Equals, DefaultTrackerConfig)
+}
+
+func (s *MySuite) TestTrackerEMA(c *C) {
+	trk := NewTrackerWithConfig(TrackerConfig{Model: EMA, Window: 10})
+	checkTrackerWarmup(c, trk)
+	c.Check(trk.frameRing.data, IsNil)
+	c.Check(trk.longSums, IsNil)
+	c.Check(len(trk.avgs), Equals, 4*4*3)
+}
+
+func (s *MySuite) TestTrackerVariance(c *C) {
+	trk := NewTrackerWithConfig(TrackerConfig{Model: Variance, Window: 8})
+	checkTrackerWarmup(c, trk)
+	c.Check(trk.longSums, IsNil)
+	c.Check(len(trk.vars), Equals, 4*4*3)
+}
+
+func (s *MySuite) TestTrackerSubImage(c *C) {
+	// Track motion in a region of interest without copying it out of the frame.
+	roi := image.Rect(2, 1, 6, 5)
+	bg := imglib.NewRGB(image.Rect(0, 0, 8, 6))
+	img := &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(bg.SubImage(roi))}
+
+	trk := NewTracker()
+	for i := 0; i < LAVGN; i++ {
+		c.Check(trk.GetRects(img, 12), DeepEquals, []image.Rectangle{})
+	}
+	c.Check(len(trk.longSums), Equals, 4*4*3)
+
+	fg := imglib.NewRGB(image.Rect(0, 0, 8, 6))
+	fg.SetRGBA(3, 2, color.RGBA{10, 20, 30, 0xFF})
+	// Changes outside the region of interest must be ignored.
+	fg.SetRGBA(0, 0, color.RGBA{10, 20, 30, 0xFF})
+	fg.SetRGBA(7, 2, color.RGBA{10, 20, 30, 0xFF})
+	img = &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(fg.SubImage(roi))}
+	c.Check(trk.GetRects(img, 12), DeepEquals, rslc(image.Rect(3, 2, 4, 3)))
+}
+
+func (s *MySuite) TestTrackerSubImageYUYV(c *C) {
+	// A YUYV region of interest with odd edges splits pixel pairs, whose other
+	// halves must be left out of the rects.
+	roi := image.Rect(3, 1, 7, 5)
+	bg := imglib.NewYUYV(image.Rect(0, 0, 8, 6))
+	img := &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(bg.SubImage(roi))}
+	trk := NewTracker()
+	for i := 0; i < LAVGN; i++ {
+		c.Check(trk.GetRects(img, 12), DeepEquals, []image.Rectangle{})
+	}
+
+	fg := imglib.NewYUYV(image.Rect(0, 0, 8, 6))
+	for _, pt := range []image.Point{{2, 2}, {3, 2}, {7, 3}} {
+		fg.Pix[fg.PixOffset(pt.X, pt.Y)] = 100
+	}
+	img = &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(fg.SubImage(roi))}
+	c.Check(trk.GetRects(img, 12), DeepEquals, rslc(image.Rect(3, 2, 4, 3)))
+}
+
+func (s *MySuite) TestTrackerRGBA(c *C) {
+	bg := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	img := &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(bg)}
+	trk := NewTracker()
+	for i := 0; i < LAVGN; i++ {
+		c.Check(trk.GetRects(img, 12), DeepEquals, []image.Rectangle{})
+	}
+
+	fg := image.NewRGBA(image.Rect(0, 0, 6, 4))
+	fg.SetRGBA(4, 1, color.RGBA{10, 20, 30, 0xFF})
+	// Alpha changes alone aren't motion.
+	fg.SetRGBA(1, 3, color.RGBA{0, 0, 0, 0x80})
+	img = &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(fg)}
+	c.Check(trk.GetRects(img, 12), DeepEquals, rslc(image.Rect(4, 1, 5, 2)))
+}
+
+func (s *MySuite) TestTrackerGray(c *C) {
+	bg := imglib.NewYUYV(image.Rect(0, 0, 6, 4))
+	img := &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(bg.ToGray())}
+	trk := NewTracker()
+	for i := 0; i < LAVGN; i++ {
+		c.Check(trk.GetRects(img, 12), DeepEquals, []image.Rectangle{})
+	}
+	c.Check(len(trk.longSums), Equals, 6*4)
+
+	fg := imglib.NewYUYV(image.Rect(0, 0, 6, 4))
+	fg.Pix[fg.PixOffset(5, 2)] = 10
+	// Chroma changes are invisible to a luma-only tracker.
+	fg.Pix[fg.PixOffset(0, 0)+1] = 100
+	img = &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(fg.ToGray())}
+	c.Check(trk.GetRects(img, 12), DeepEquals, rslc(image.Rect(5, 2, 6, 3)))
+}
+
+func (s *MySuite) TestMask(c *C) {
+	roi := image.Rect(2, 1, 8, 5)
+	bg := imglib.NewRGB(image.Rect(0, 0, 8, 6))
+	img := &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(bg.SubImage(roi))}
+	trk := NewTracker()
+	for i := 0; i < LAVGN; i++ {
+		c.Check(trk.GetMask(img, 12).Rect, Equals, roi)
+	}
+
+	fg := imglib.NewRGB(image.Rect(0, 0, 8, 6))
+	fg.SetRGBA(3, 2, color.RGBA{10, 20, 30, 0xFF})
+	fg.SetRGBA(4, 2, color.RGBA{10, 20, 30, 0xFF})
+	fg.SetRGBA(6, 4, color.RGBA{10, 20, 30, 0xFF})
+	img = &imgseq.RawImg{PixelSequence: imglib.GetPixelSequence(fg.SubImage(roi))}
+	mask := trk.GetMask(img, 12)
+	c.Check(mask.GrayAt(3, 2).Y, Equals, uint8(MaskOn))
+	c.Check(mask.GrayAt(5, 2).Y, Equals, uint8(0))
+	c.Check(MaskRects(mask), DeepEquals, rslc(image.Rect(3, 2, 5, 3), image.Rect(6, 4, 7, 5)))
+}
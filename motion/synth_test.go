@@ -0,0 +1,175 @@
+package motion
+
+import . "gopkg.in/check.v1"
+
+import "code.google.com/p/ncabatoff/imglib"
+import "code.google.com/p/ncabatoff/synth"
+import "image"
+import "image/color"
+import "sort"
+
+// iou returns the area of the intersection of a and b over that of their
+// union.
+func iou(a, b image.Rectangle) float64 {
+	area := func(r image.Rectangle) int { return r.Dx() * r.Dy() }
+	in := area(a.Intersect(b))
+	return float64(in) / float64(area(a)+area(b)-in)
+}
+
+// score matches the rects found by a detector against the truth, returning
+// how many truth rects were found, the mean IoU of those found, and how many
+// found rects match nothing.
+func score(truth, found []image.Rectangle) (hits int, meanIoU float64, falsePos int) {
+	matched := make([]bool, len(found))
+	for _, t := range truth {
+		best, bi := 0.0, -1
+		for i, f := range found {
+			if v := iou(t, f); v > best {
+				best, bi = v, i
+			}
+		}
+		if bi >= 0 {
+			hits++
+			meanIoU += best
+			matched[bi] = true
+		}
+	}
+	if hits > 0 {
+		meanIoU /= float64(hits)
+	}
+	for _, m := range matched {
+		if !m {
+			falsePos++
+		}
+	}
+	return
+}
+
+// accuracyScene has two objects which enter once the tracker has seen LAVGN
+// frames of textured, noisy background.  They move fast enough that no pixel
+// is covered for more than a few frames: a slow object leaves a trail of
+// false motion as it fades out of the average.
+func accuracyScene(pf imglib.PixelFormat) synth.Scene {
+	return synth.Scene{Width: 160, Height: 120, Format: pf, Contrast: 0.3, Noise: 2, Seed: 1,
+		Objects: []synth.Object{
+			{Rect: image.Rect(8, 20, 32, 38), DX: 4, Color: color.RGBA{230, 200, 40, 0xFF}, First: LAVGN},
+			{Rect: image.Rect(120, 10, 136, 26), DY: 4, Color: color.RGBA{20, 40, 90, 0xFF}, First: LAVGN, Bounce: true},
+		}}
+}
+
+// checkTrackerAccuracy runs a Tracker configured by cfg over sc and checks
+// that every object is found in every frame, closely enough, with no false
+// positives.
+func checkTrackerAccuracy(c *C, sc synth.Scene, cfg TrackerConfig, t int) {
+	seq := sc.Sequence(LAVGN + 40)
+	trk := NewTrackerWithConfig(cfg)
+	var total float64
+	n := 0
+	for i := 0; i < seq.Len(); i++ {
+		img, err := seq.At(i)
+		c.Assert(err, IsNil)
+		rects := trk.GetRects(img, t)
+		truth := sc.Truth(i)
+		hits, miou, fp := score(truth, rects)
+		c.Check(hits, Equals, len(truth), Commentf("frame %d: truth %v found %v", i, truth, rects))
+		c.Check(fp, Equals, 0, Commentf("frame %d: truth %v found %v", i, truth, rects))
+		if hits > 0 {
+			c.Check(miou > 0.6, Equals, true, Commentf("frame %d: truth %v found %v", i, truth, rects))
+			total += miou
+			n++
+		}
+	}
+	c.Check(total/float64(n) > 0.8, Equals, true, Commentf("mean IoU %.2f", total/float64(n)))
+}
+
+func (s *MySuite) TestTrackerAccuracyYUYV(c *C) {
+	checkTrackerAccuracy(c, accuracyScene(imglib.PixelFormatYUYV), DefaultTrackerConfig, 800)
+}
+
+func (s *MySuite) TestTrackerAccuracyRGB(c *C) {
+	checkTrackerAccuracy(c, accuracyScene(imglib.PixelFormatRGB), DefaultTrackerConfig, 800)
+}
+
+func (s *MySuite) TestTrackerAccuracyGray(c *C) {
+	checkTrackerAccuracy(c, accuracyScene(imglib.PixelFormatGray), DefaultTrackerConfig, 400)
+}
+
+func (s *MySuite) TestTrackerAccuracyWindow(c *C) {
+	// Each frame weighs more in a shorter window, so trails are stronger.
+	checkTrackerAccuracy(c, accuracyScene(imglib.PixelFormatYUYV), TrackerConfig{Window: 40}, 1200)
+}
+
+func (s *MySuite) TestTrackerAccuracyEMA(c *C) {
+	checkTrackerAccuracy(c, accuracyScene(imglib.PixelFormatYUYV), TrackerConfig{Model: EMA, Window: LAVGN}, 800)
+}
+
+func (s *MySuite) TestTrackerAccuracyVariance(c *C) {
+	// 6 standard deviations, more or less, on the three channels.
+	checkTrackerAccuracy(c, accuracyScene(imglib.PixelFormatYUYV), TrackerConfig{Model: Variance}, 36*VarianceUnit*VarianceUnit)
+}
+
+func (s *MySuite) TestTrackerFlicker(c *C) {
+	// A flickering monitor is constant false motion to the plain mean, but
+	// not to the Variance model, which learns how much it varies.
+	sc := accuracyScene(imglib.PixelFormatYUYV)
+	sc.Objects = append(sc.Objects, synth.Object{Rect: image.Rect(60, 70, 90, 100), Color: color.RGBA{80, 90, 160, 0xFF}, Flicker: 20})
+	checkTrackerAccuracy(c, sc, TrackerConfig{Model: Variance}, 36*VarianceUnit*VarianceUnit)
+
+	seq := sc.Sequence(LAVGN + 40)
+	trk := NewTracker()
+	falsePos := 0
+	for i := 0; i < seq.Len(); i++ {
+		img, err := seq.At(i)
+		c.Assert(err, IsNil)
+		_, _, fp := score(sc.Truth(i), trk.GetRects(img, 800))
+		falsePos += fp
+	}
+	c.Check(falsePos > 40, Equals, true, Commentf("%d false positives", falsePos))
+}
+
+func (s *MySuite) TestTrackerQuietScene(c *C) {
+	// Noise and slow lighting changes alone shouldn't look like motion.
+	sc := synth.Scene{Width: 160, Height: 120, Format: imglib.PixelFormatYUYV, Contrast: 0.3, Noise: 3, Seed: 2,
+		Lighting: synth.SineLighting(0.05, 200)}
+	seq := sc.Sequence(LAVGN + 40)
+	trk := NewTracker()
+	for i := 0; i < seq.Len(); i++ {
+		img, err := seq.At(i)
+		c.Assert(err, IsNil)
+		c.Check(trk.GetRects(img, 800), DeepEquals, []image.Rectangle{}, Commentf("frame %d", i))
+	}
+}
+
+func (s *MySuite) TestFindConnectedRectsScale(c *C) {
+	// A grid of objects in a full-size frame, two pixels apart so they don't
+	// join, moving together.
+	sc := synth.Scene{Width: 640, Height: 480}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 10; x++ {
+			r := image.Rect(0, 0, 10+3*x, 8+4*y).Add(image.Pt(2+64*x, 2+60*y))
+			sc.Objects = append(sc.Objects, synth.Object{Rect: r, DX: 0.5, DY: 0.25})
+		}
+	}
+	sortRects := func(rs []image.Rectangle) []image.Rectangle {
+		sort.Slice(rs, func(i, j int) bool {
+			if rs[i].Min.Y != rs[j].Min.Y {
+				return rs[i].Min.Y < rs[j].Min.Y
+			}
+			return rs[i].Min.X < rs[j].Min.X
+		})
+		return rs
+	}
+	for _, n := range []int{0, 7, 20} {
+		truth := sc.Truth(n)
+		rrs := make([]RowRects, sc.Height)
+		for _, r := range truth {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				rrs[y] = append(rrs[y], image.Rect(r.Min.X, y, r.Max.X, y+1))
+			}
+		}
+		for y := range rrs {
+			sort.Slice(rrs[y], func(i, j int) bool { return rrs[y][i].Min.X < rrs[y][j].Min.X })
+		}
+		c.Check(sortRects(FindConnectedRects(sc.Width, rrs)), DeepEquals, sortRects(truth), Commentf("frame %d", n))
+	}
+}
@@ -2,30 +2,121 @@ package motion
 
 import "code.google.com/p/ncabatoff/imglib"
 import "code.google.com/p/ncabatoff/imgseq"
+import "fmt"
 import "image"
 
+// BackgroundModel is how a Tracker estimates what each pixel looks like when
+// nothing is moving.
+type BackgroundModel int
+
+const (
+	// WindowMean is the mean of the last Window frames.  The frames must be
+	// kept so that each can be subtracted from the sums as it leaves the
+	// window, which for LAVGN VGA frames is about 40MB.
+	WindowMean BackgroundModel = iota
+	// EMA is an exponential moving average giving each frame weight
+	// 1/Window, so it adapts to change about as fast as WindowMean but
+	// keeps no frames.
+	EMA
+	// Variance keeps an exponentially weighted variance as well as the
+	// mean of each sample, and measures deltas in standard deviations (see
+	// VarianceUnit), so that areas which are always changing, like foliage
+	// or a monitor, need a bigger change to count as motion.
+	Variance
+)
+
+func (bm BackgroundModel) String() string {
+	switch bm {
+	case WindowMean:
+		return "mean"
+	case EMA:
+		return "ema"
+	case Variance:
+		return "variance"
+	}
+	return fmt.Sprintf("BackgroundModel(%d)", int(bm))
+}
+
+// ParseBackgroundModel returns the BackgroundModel named s, as given by its
+// String method.
+func ParseBackgroundModel(s string) (BackgroundModel, error) {
+	for _, bm := range []BackgroundModel{WindowMean, EMA, Variance} {
+		if s == bm.String() {
+			return bm, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown background model '%s', expected mean, ema or variance", s)
+}
+
+// TrackerConfig controls a Tracker.
+type TrackerConfig struct {
+	Model BackgroundModel
+	// Window is the number of frames the background is averaged over, and
+	// how many frames the Tracker must be given before it finds any motion;
+	// zero means LAVGN.
+	Window int
+	// MinStdDev is the least standard deviation assumed by the Variance
+	// model; zero means DefaultMinStdDev.
+	MinStdDev float64
+}
+
+// DefaultTrackerConfig is what NewTracker uses.
+var DefaultTrackerConfig = TrackerConfig{Model: WindowMean, Window: LAVGN, MinStdDev: DefaultMinStdDev}
+
 // Tracker is fed frames and produces as output the rect slices in those frames
 // containing high activity, meaning they have a high color difference with respect
-// to the average preceding frames.
+// to the average preceding frames.  Frames may be YUYV, RGB, RGBA or luma-only
+// image.Gray (see imglib.GetGray); since a gray delta is just dy*dy, thresholds
+// for gray frames should be lower than for color ones.
 type Tracker struct {
+	cfg       TrackerConfig
 	frameNum  int
 	frameRing ringbuf
 	longSums  lnsumslc
+	avgs      []int32
+	means     []float32
+	vars      []float32
 	cdfb      columnDeltaFinderBuilder
 }
 
+// NewTracker returns a Tracker using DefaultTrackerConfig.
 func NewTracker() *Tracker {
-	trk := Tracker{}
-	trk.frameRing = ringbuf{data: make([]interface{}, LAVGN)}
+	return NewTrackerWithConfig(DefaultTrackerConfig)
+}
+
+// NewTrackerWithConfig returns a Tracker configured by cfg.
+func NewTrackerWithConfig(cfg TrackerConfig) *Tracker {
+	if cfg.Window <= 0 {
+		cfg.Window = LAVGN
+	}
+	if cfg.MinStdDev <= 0 {
+		cfg.MinStdDev = DefaultMinStdDev
+	}
+	trk := Tracker{cfg: cfg}
+	if cfg.Model == WindowMean {
+		trk.frameRing = ringbuf{data: make([]interface{}, cfg.Window)}
+	}
 	return &trk
 }
 
-// Add img to the tracker dataset and return rectangles found in it using 
-// image color delta threshold t.
+// Config returns the configuration of the tracker.
+func (trk *Tracker) Config() TrackerConfig {
+	return trk.cfg
+}
+
+// Add img to the tracker dataset and return rectangles found in it using
+// image color delta threshold t, or for the Variance model a threshold in
+// standard deviations as described for VarianceUnit.  The rectangles are in
+// the coordinate space of img, so if img is a region of interest within a
+// larger image (e.g. built from a SubImage) they can be drawn directly onto the
+// larger image.
 func (trk *Tracker) GetRects(img imgseq.Img, t int) []image.Rectangle {
 	if odrs := trk.getRects(img, t); len(odrs) > 0 {
-		dx := img.GetPixelSequence().Dx
-		if rects := FindConnectedRects(dx, odrs); len(rects) > 0 {
+		ps := img.GetPixelSequence()
+		if rects := FindConnectedRects(ps.Dx, odrs); len(rects) > 0 {
+			for i := range rects {
+				rects[i] = rects[i].Add(ps.Origin)
+			}
 			return rects
 		}
 	}
@@ -35,45 +126,117 @@ func (trk *Tracker) GetRects(img imgseq.Img, t int) []image.Rectangle {
 func (trk *Tracker) getRects(img imgseq.Img, t int) []RowRects {
 	nps := img.GetPixelSequence()
 
-	if len(trk.longSums) == 0 {
-		trk.longSums = make(lnsumslc, len(nps.GetBytes()))
-		switch nps.ImageBytes.(type) {
+	if trk.cdfb == nil {
+		switch n := nps.RowBytes() * nps.Dy; trk.cfg.Model {
+		case EMA:
+			trk.avgs = make([]int32, n)
+		case Variance:
+			trk.means, trk.vars = make([]float32, n), make([]float32, n)
+		default:
+			trk.longSums = make(lnsumslc, n)
+		}
+		switch w := nps.RowBounds().Dx(); nps.ImageBytes.(type) {
 		case imglib.YuyvBytes:
-			trk.cdfb = yuvColumnDeltaFinderBuilder(nps.Dx)
+			trk.cdfb = yuvColumnDeltaFinderBuilder(w)
 		case imglib.RgbBytes:
-			trk.cdfb = rgbColumnDeltaFinderBuilder(nps.Dx)
+			trk.cdfb = rgbColumnDeltaFinderBuilder(w)
+		case imglib.RgbaBytes:
+			trk.cdfb = rgbaColumnDeltaFinderBuilder(w)
+		case imglib.GrayBytes:
+			trk.cdfb = grayColumnDeltaFinderBuilder(w)
 		default:
 			panic("unknown format")
 		}
 		// TODO check for other possibilities
 	}
 
+	if trk.cfg.Model != WindowMean {
+		var sums sumslice
+		if w := trk.weight(); trk.cfg.Model == EMA {
+			sums = emaslc{trk.avgs, int32(w)}
+		} else {
+			minVar := trk.cfg.MinStdDev * trk.cfg.MinStdDev
+			sums = varslc{trk.means, trk.vars, float32(w), float32(minVar)}
+		}
+		trk.frameNum++
+		if trk.frameNum > trk.cfg.Window {
+			return buildHeightOneRects(nps, nps, sums, t, trk.cdfb)
+		}
+		addRows(sums, nps)
+		return []RowRects{}
+	}
+
+	var sums sumslice = trk.longSums
+	if trk.cfg.Window != LAVGN {
+		sums = windowSums{trk.longSums, lnsum(trk.cfg.Window)}
+	}
 	if old := trk.roll(img); old != nil {
 		ops := old.GetPixelSequence()
-		return buildHeightOneRects(ops, nps, trk.longSums, t, trk.cdfb)
+		return clipRows(nps, buildHeightOneRects(ops, nps, sums, t, trk.cdfb))
 	}
 
-	trk.longSums.add(nps.GetBytes())
+	addRows(sums, nps)
 	return []RowRects{}
 }
 
+// clipRows converts rrs from the columns of the rows of ps to those of its
+// bounds, i.e. relative to ps.Origin.  They differ for YUYV sequences whose
+// rows hold whole pixel pairs, but only the pixels within bounds are wanted.
+func clipRows(ps imglib.PixelSequence, rrs []RowRects) []RowRects {
+	off := ps.RowBounds().Min.X - ps.Origin.X
+	if off == 0 && ps.RowBounds().Dx() == ps.Dx {
+		return rrs
+	}
+	for y, rr := range rrs {
+		out := rr[:0]
+		for _, r := range rr {
+			r.Min.X, r.Max.X = r.Min.X+off, r.Max.X+off
+			if r.Min.X < 0 {
+				r.Min.X = 0
+			}
+			if r.Max.X > ps.Dx {
+				r.Max.X = ps.Dx
+			}
+			if !r.Empty() {
+				out = append(out, r)
+			}
+		}
+		rrs[y] = out
+	}
+	return rrs
+}
+
+// weight returns the reciprocal of the weight an EMA or Variance tracker gives
+// the next frame.  Until Window frames have been seen the weight of each is
+// 1/n for the nth frame, giving their plain mean, so the average doesn't start
+// out biased towards the first frame.
+func (trk *Tracker) weight() int {
+	w := trk.frameNum + 1
+	if w > trk.cfg.Window {
+		w = trk.cfg.Window
+	}
+	return w
+}
+
 // Return the buffer entry n frames in the past.
 func (trk *Tracker) getOldFrame(n int) imgseq.Img {
-	return trk.frameRing.data[(trk.frameRing.i+trk.frameRing.cnt+(LAVGN-n))%LAVGN].(imgseq.Img)
+	size := len(trk.frameRing.data)
+	return trk.frameRing.data[(trk.frameRing.i+trk.frameRing.cnt+(size-n))%size].(imgseq.Img)
 }
 
 // Add img to the RingBuffer, returning what falls out of the ring buffer, or nil
 // if the buffer wasn't already full.
 func (trk *Tracker) roll(img imgseq.Img) imgseq.Img {
+	size := len(trk.frameRing.data)
 	defer func() {
-		if trk.frameRing.Size() == LAVGN {
+		if trk.frameRing.Size() == size {
 			trk.frameRing.Dequeue()
 		}
 		trk.frameRing.Enqueue(img)
 		trk.frameNum++
 	}()
 
-	if trk.frameRing.Size() == LAVGN {
+	if trk.frameRing.Size() == size {
 		old := trk.frameRing.Peek().(*imgseq.RawImg)
 		return old
 	}
@@ -0,0 +1,72 @@
+package motion
+
+import "math"
+
+// VarianceUnit is the scale of the deltas found by the Variance model: a
+// sample's delta is its distance from the mean in standard deviations times
+// VarianceUnit.  Since thresholds apply to the sum of the squared deltas of a
+// pixel's channels, a threshold of k standard deviations on one channel is
+// (k*VarianceUnit)^2.
+const VarianceUnit = 16
+
+// DefaultMinStdDev is the least standard deviation the Variance model
+// assumes, so that in a noiseless or saturated area the slightest change
+// isn't taken for motion.
+const DefaultMinStdDev = 2
+
+// varClip is how many standard deviations from the mean a sample may count
+// for when updating the model once it's warmed up.  Without the limit an
+// object lingering over a pixel for a few frames would swell its variance and
+// soon hide itself; with it a stopped object is still absorbed into the
+// background, only more gradually.
+const varClip = 2.5
+
+// varslc holds exponentially weighted running means and variances.  Each new
+// value is given weight 1/w.
+type varslc struct {
+	means, vars []float32
+	w           float32
+	minVar      float32
+}
+
+// stddev returns the standard deviation of the ith sample.
+func (vs varslc) stddev(i int) float32 {
+	vr := vs.vars[i]
+	if vr < vs.minVar {
+		vr = vs.minVar
+	}
+	return float32(math.Sqrt(float64(vr)))
+}
+
+// update adds v to the ith mean and variance, limiting its distance from the
+// mean to clip if that's not zero.
+func (vs varslc) update(i int, v byte, clip float32) {
+	d := float32(v) - vs.means[i]
+	if clip != 0 {
+		if d > clip {
+			d = clip
+		} else if d < -clip {
+			d = -clip
+		}
+	}
+	vs.means[i] += d / vs.w
+	vs.vars[i] = (1 - 1/vs.w) * (vs.vars[i] + d*d/vs.w)
+}
+
+func (vs varslc) rollSumDelta(b []byte, delta deltaslc, old []byte) {
+	for i, m := range vs.means {
+		sd := vs.stddev(i)
+		delta[i] = int((m - float32(b[i])) * VarianceUnit / sd)
+		vs.update(i, b[i], varClip*sd)
+	}
+}
+
+func (vs varslc) add(b []byte) {
+	for i := range vs.means {
+		vs.update(i, b[i], 0)
+	}
+}
+
+func (vs varslc) slice(i, j int) sumslice {
+	return varslc{vs.means[i:j], vs.vars[i:j], vs.w, vs.minVar}
+}
@@ -0,0 +1,323 @@
+// synth generates synthetic image sequences of known content, for testing
+// motion detection and the tools that consume captures.  A Scene describes a
+// textured background, possibly drifting, with solid objects moving across it
+// on straight or bouncing paths, lighting changes and sensor noise; because
+// the objects' positions are known, each frame comes with the ground-truth
+// rects a motion detector ought to find.
+package synth
+
+import (
+	"code.google.com/p/ncabatoff/imglib"
+	"code.google.com/p/ncabatoff/imgseq"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Object is a solid rectangle moving in a straight line at constant speed.
+type Object struct {
+	// Rect is the position of the object in frame First.
+	Rect image.Rectangle
+	// DX and DY are the distance moved each frame, in pixels.
+	DX, DY float64
+	// Color is the color of the object, which is lit like the background.
+	Color color.RGBA
+	// First is the first frame the object is in, and Last the frame after
+	// the last one; zero means it never leaves.
+	First, Last int
+	// Bounce makes the object reverse direction when it reaches an edge of
+	// the frame, rather than moving out of it.
+	Bounce bool
+	// Flicker is the standard deviation of random changes to the object's
+	// pixels from frame to frame, like a monitor or foliage, which is
+	// constant activity but not motion if the object doesn't move.
+	Flicker float64
+}
+
+// At returns the position of the object in frame n of a scene of the given
+// size, and whether it's present in it at all.
+func (o Object) At(n int, size image.Point) (image.Rectangle, bool) {
+	if n < o.First || o.Last != 0 && n >= o.Last {
+		return image.ZR, false
+	}
+	t := float64(n - o.First)
+	x := float64(o.Rect.Min.X) + t*o.DX
+	y := float64(o.Rect.Min.Y) + t*o.DY
+	sz := o.Rect.Size()
+	if o.Bounce {
+		x = reflect(x, float64(size.X-sz.X))
+		y = reflect(y, float64(size.Y-sz.Y))
+	}
+	min := image.Pt(int(math.Floor(x+0.5)), int(math.Floor(y+0.5)))
+	r := image.Rectangle{min, min.Add(sz)}.Intersect(image.Rect(0, 0, size.X, size.Y))
+	return r, !r.Empty()
+}
+
+// reflect folds v into [0,max] as if it bounced off both ends.
+func reflect(v, max float64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	v = math.Mod(math.Abs(v), 2*max)
+	if v > max {
+		v = 2*max - v
+	}
+	return v
+}
+
+// Lighting returns the factor the brightness of the scene is multiplied by in
+// frame n.
+type Lighting func(n int) float64
+
+// SineLighting returns a Lighting that varies sinusoidally by amplitude, e.g.
+// 0.1 for plus or minus 10%, over period frames, like a cloud passing or a
+// flickering lamp.
+func SineLighting(amplitude float64, period int) Lighting {
+	return func(n int) float64 {
+		return 1 + amplitude*math.Sin(2*math.Pi*float64(n)/float64(period))
+	}
+}
+
+// StepLighting returns a Lighting that changes from 1 to factor at frame at,
+// like a light being switched on.
+func StepLighting(at int, factor float64) Lighting {
+	return func(n int) float64 {
+		if n < at {
+			return 1
+		}
+		return factor
+	}
+}
+
+// Scene describes a synthetic sequence of frames.
+type Scene struct {
+	// Width and Height give the size of the frames, and Format their pixel
+	// layout.  Width must be even for YUYV.
+	Width, Height int
+	Format        imglib.PixelFormat
+	// DriftX and DriftY move the background this many pixels each frame,
+	// like a camera slowly panning or swaying.
+	DriftX, DriftY float64
+	// Contrast scales the background texture: zero gives a flat gray
+	// background, one a strongly textured one.
+	Contrast float64
+	Objects  []Object
+	// Lighting, if set, varies the brightness of the whole scene.
+	Lighting Lighting
+	// Noise is the standard deviation of the Gaussian noise added to each
+	// sample of the frames.
+	Noise float64
+	// Seed determines the background texture and the noise.  Frames are a
+	// function of the Scene and their index, so they can be read in any order.
+	Seed int64
+	// Start is the CreationTs of the first frame, and Period the interval
+	// between frames; zero means 40ms.
+	Start  time.Time
+	Period time.Duration
+}
+
+// Size returns the size of the frames.
+func (sc *Scene) Size() image.Point {
+	return image.Pt(sc.Width, sc.Height)
+}
+
+// Truth returns the rects occupied by the moving objects in frame n, i.e.
+// those a motion detector ought to find, in the order of sc.Objects.  Objects
+// not present, or with neither DX nor DY set, are left out: a static object,
+// even a flickering one, is part of the background.  Objects which overlap or
+// touch are reported separately even though a motion detector would see them
+// as one.
+func (sc *Scene) Truth(n int) []image.Rectangle {
+	rects := []image.Rectangle{}
+	for _, o := range sc.Objects {
+		if o.DX == 0 && o.DY == 0 {
+			continue
+		}
+		if r, ok := o.At(n, sc.Size()); ok {
+			rects = append(rects, r)
+		}
+	}
+	return rects
+}
+
+// wave is one sinusoidal component of the background texture.
+type wave struct {
+	fx, fy, phase, amp float64
+}
+
+// texture returns the waves making up the background texture of each of the
+// R, G and B channels.
+func (sc *Scene) texture() [3][]wave {
+	var waves [3][]wave
+	rnd := rand.New(rand.NewSource(sc.Seed))
+	for ch := range waves {
+		for i := 0; i < 4; i++ {
+			// Wavelengths of roughly 6 to 60 pixels.
+			angle := rnd.Float64() * 2 * math.Pi
+			f := 2 * math.Pi / (6 + 54*rnd.Float64())
+			waves[ch] = append(waves[ch], wave{f * math.Cos(angle), f * math.Sin(angle), rnd.Float64() * 2 * math.Pi, 24})
+		}
+	}
+	return waves
+}
+
+// Render returns frame n of the scene, in sc.Format.
+func (sc *Scene) Render(n int) (image.Image, error) {
+	if sc.Width <= 0 || sc.Height <= 0 {
+		return nil, fmt.Errorf("invalid scene size %dx%d", sc.Width, sc.Height)
+	}
+	gain := 1.0
+	if sc.Lighting != nil {
+		gain = sc.Lighting(n)
+	}
+	rgb := imglib.NewRGB(image.Rect(0, 0, sc.Width, sc.Height))
+	waves := sc.texture()
+	ox, oy := float64(n)*sc.DriftX, float64(n)*sc.DriftY
+	for y := 0; y < sc.Height; y++ {
+		p := rgb.PixOffset(0, y)
+		for x := 0; x < sc.Width; x++ {
+			bx, by := float64(x)-ox, float64(y)-oy
+			for ch, ws := range waves {
+				v := 0.0
+				for _, w := range ws {
+					v += w.amp * math.Sin(w.fx*bx+w.fy*by+w.phase)
+				}
+				rgb.Pix[p+ch] = clamp(gain * (128 + sc.Contrast*v))
+			}
+			p += 3
+		}
+	}
+	for i, o := range sc.Objects {
+		r, ok := o.At(n, sc.Size())
+		if !ok {
+			continue
+		}
+		c := color.RGBA{clamp(gain * float64(o.Color.R)), clamp(gain * float64(o.Color.G)), clamp(gain * float64(o.Color.B)), 0xFF}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				rgb.SetRGBA(x, y, c)
+			}
+		}
+		if o.Flicker > 0 {
+			rnd := rand.New(rand.NewSource(sc.Seed ^ int64(n+1)*0x5DEECE66D ^ int64(i+1)<<40))
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				p := rgb.PixOffset(r.Min.X, y)
+				for x := 3 * r.Dx(); x > 0; x-- {
+					rgb.Pix[p] = clamp(float64(rgb.Pix[p]) + rnd.NormFloat64()*o.Flicker)
+					p++
+				}
+			}
+		}
+	}
+
+	var img image.Image
+	var pix []byte
+	skip := 0
+	switch sc.Format {
+	case imglib.PixelFormatRGB:
+		img, pix = rgb, rgb.Pix
+	case imglib.PixelFormatRGBA:
+		rgba := imglib.StdImage{Image: rgb}.GetRGBA()
+		img, pix, skip = rgba, rgba.Pix, 4
+	case imglib.PixelFormatYUYV:
+		if sc.Width%2 != 0 {
+			return nil, fmt.Errorf("YUYV scene width %d isn't even", sc.Width)
+		}
+		yuyv := imglib.NewYUYVFromYCbCr(imglib.NewYCbCr(rgb, image.YCbCrSubsampleRatio422))
+		img, pix = yuyv, yuyv.Pix
+	case imglib.PixelFormatGray:
+		gray := rgb.ToGray()
+		img, pix = gray, gray.Pix
+	default:
+		return nil, fmt.Errorf("can't render scene in format %v", sc.Format)
+	}
+	sc.addNoise(n, pix, skip)
+	return img, nil
+}
+
+// addNoise adds the scene's noise for frame n to pix, leaving every skip'th
+// byte (i.e. alpha) alone if skip isn't zero.
+func (sc *Scene) addNoise(n int, pix []byte, skip int) {
+	if sc.Noise <= 0 {
+		return
+	}
+	rnd := rand.New(rand.NewSource(sc.Seed ^ int64(n+1)*0x5DEECE66D))
+	for i, v := range pix {
+		if skip != 0 && i%skip == skip-1 {
+			continue
+		}
+		pix[i] = clamp(float64(v) + rnd.NormFloat64()*sc.Noise)
+	}
+}
+
+func clamp(v float64) byte {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return byte(v + 0.5)
+}
+
+func (sc *Scene) period() time.Duration {
+	if sc.Period <= 0 {
+		return 40 * time.Millisecond
+	}
+	return sc.Period
+}
+
+// Info returns the ImgInfo of frame n, whose Meta holds its Truth as Rects so
+// that e.g. bv and export can outline it.
+func (sc *Scene) Info(n int) imgseq.ImgInfo {
+	ii := imgseq.ImgInfo{SeqNum: n, Path: fmt.Sprintf("synth:%d", n)}
+	if !sc.Start.IsZero() {
+		ii.CreationTs = sc.Start.Add(time.Duration(n) * sc.period())
+	}
+	ii.Meta = &imgseq.Metadata{Camera: "synth", Format: sc.Format.String(), Width: sc.Width, Height: sc.Height, Rects: sc.Truth(n)}
+	return ii
+}
+
+// Sequence returns the first n frames of sc as a Sequence, rendered as they're
+// asked for.
+func (sc *Scene) Sequence(n int) imgseq.Sequence {
+	return &sequence{sc, n}
+}
+
+type sequence struct {
+	sc *Scene
+	n  int
+}
+
+func (s *sequence) Len() int {
+	return s.n
+}
+
+func (s *sequence) Info(i int) imgseq.ImgInfo {
+	return s.sc.Info(i)
+}
+
+func (s *sequence) At(i int) (imgseq.Img, error) {
+	if i < 0 || i >= s.n {
+		return nil, fmt.Errorf("frame %d out of range [0,%d)", i, s.n)
+	}
+	img, err := s.sc.Render(i)
+	if err != nil {
+		return nil, err
+	}
+	return &imgseq.RawImg{ImgInfo: s.sc.Info(i), PixelSequence: imglib.GetPixelSequence(img)}, nil
+}
+
+func (s *sequence) IndexAt(t time.Time) int {
+	return sort.Search(s.n, func(i int) bool {
+		return !s.sc.Info(i).CreationTs.Before(t)
+	})
+}
+
+func (s *sequence) Close() error {
+	return nil
+}
@@ -0,0 +1,169 @@
+package synth
+
+import . "gopkg.in/check.v1"
+import "testing"
+
+import "code.google.com/p/ncabatoff/imglib"
+import "image"
+import "image/color"
+import "math"
+import "time"
+
+// Hook up gocheck into the gotest runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+func (s *MySuite) TestObjectAt(c *C) {
+	size := image.Pt(20, 10)
+	o := Object{Rect: image.Rect(2, 3, 6, 5), DX: 1.5, First: 2, Last: 20}
+	_, ok := o.At(1, size)
+	c.Check(ok, Equals, false)
+	r, ok := o.At(2, size)
+	c.Check(ok, Equals, true)
+	c.Check(r, Equals, o.Rect)
+	r, _ = o.At(4, size)
+	c.Check(r, Equals, image.Rect(5, 3, 9, 5))
+	// Leaving the frame, it's clipped, then gone.
+	r, _ = o.At(13, size)
+	c.Check(r, Equals, image.Rect(19, 3, 20, 5))
+	_, ok = o.At(14, size)
+	c.Check(ok, Equals, false)
+	_, ok = o.At(20, size)
+	c.Check(ok, Equals, false)
+
+	// Bouncing, it turns back at x=16, and is back where it started after
+	// moving 32 pixels.
+	o.Bounce, o.Last = true, 0
+	r, _ = o.At(13, size)
+	c.Check(r, Equals, image.Rect(14, 3, 18, 5))
+	r, _ = o.At(2+64, size)
+	c.Check(r, Equals, o.Rect)
+}
+
+func (s *MySuite) TestTruth(c *C) {
+	sc := Scene{Width: 16, Height: 8, Objects: []Object{
+		{Rect: image.Rect(0, 0, 2, 2), DX: 1, DY: 1},
+		{Rect: image.Rect(10, 4, 12, 8), DY: -1, First: 3, Bounce: true},
+		// Static objects aren't motion, flickering or not.
+		{Rect: image.Rect(12, 0, 14, 2)},
+		{Rect: image.Rect(6, 0, 8, 2), Flicker: 10},
+	}}
+	c.Check(sc.Truth(0), DeepEquals, []image.Rectangle{image.Rect(0, 0, 2, 2)})
+	c.Check(sc.Truth(3), DeepEquals, []image.Rectangle{image.Rect(3, 3, 5, 5), image.Rect(10, 4, 12, 8)})
+	c.Check(sc.Truth(8), DeepEquals, []image.Rectangle{image.Rect(10, 1, 12, 5)})
+}
+
+func (s *MySuite) TestRender(c *C) {
+	sc := Scene{Width: 32, Height: 16, Format: imglib.PixelFormatRGB, Contrast: 1,
+		Objects: []Object{{Rect: image.Rect(4, 4, 8, 8), DX: 2, Color: color.RGBA{200, 10, 10, 0xFF}}}}
+	img, err := sc.Render(1)
+	c.Assert(err, IsNil)
+	rgb := img.(*imglib.RGB)
+	c.Check(rgb.At(6, 4), Equals, color.Color(color.RGBA{200, 10, 10, 0xFF}))
+	c.Check(rgb.At(5, 4), Not(Equals), color.Color(color.RGBA{200, 10, 10, 0xFF}))
+
+	// Frames are reproducible, and the texture is the same from frame to
+	// frame away from the object.
+	again, _ := sc.Render(1)
+	c.Check(again, DeepEquals, img)
+	other, _ := sc.Render(2)
+	c.Check(other.At(20, 12), Equals, img.At(20, 12))
+
+	// A drifting background moves by DriftX each frame.
+	sc.DriftX = 2
+	other, _ = sc.Render(3)
+	c.Check(other.At(26, 12), Equals, img.At(20, 12))
+
+	sc.Lighting = StepLighting(3, 0.5)
+	other, _ = sc.Render(3)
+	c.Check(other.At(10, 4), Equals, color.Color(color.RGBA{100, 5, 5, 0xFF}))
+}
+
+func (s *MySuite) TestRenderFormats(c *C) {
+	sc := Scene{Width: 6, Height: 4, Objects: []Object{{Rect: image.Rect(0, 0, 6, 4), Color: color.RGBA{0x80, 0x80, 0x80, 0xFF}}}}
+	for _, pf := range []imglib.PixelFormat{imglib.PixelFormatYUYV, imglib.PixelFormatRGB, imglib.PixelFormatRGBA, imglib.PixelFormatGray} {
+		sc.Format = pf
+		img, err := sc.Render(0)
+		c.Assert(err, IsNil)
+		ps := imglib.GetPixelSequence(img)
+		c.Check(imglib.GetPixelFormat(ps.ImageBytes), Equals, pf)
+		c.Check(img.Bounds(), Equals, image.Rect(0, 0, 6, 4))
+		c.Check(img.At(3, 2), Equals, img.At(0, 0))
+	}
+	sc.Format = imglib.PixelFormatUnknown
+	_, err := sc.Render(0)
+	c.Check(err, NotNil)
+	sc.Format, sc.Width = imglib.PixelFormatYUYV, 5
+	_, err = sc.Render(0)
+	c.Check(err, NotNil)
+}
+
+func (s *MySuite) TestFlicker(c *C) {
+	sc := Scene{Width: 16, Height: 8, Format: imglib.PixelFormatGray,
+		Objects: []Object{{Rect: image.Rect(4, 2, 8, 6), Color: color.RGBA{100, 100, 100, 0xFF}, Flicker: 10}}}
+	f0, _ := sc.Render(0)
+	f1, _ := sc.Render(1)
+	again, _ := sc.Render(1)
+	c.Check(again, DeepEquals, f1)
+	changed := 0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			if f0.At(x, y) != f1.At(x, y) {
+				c.Check(image.Pt(x, y).In(sc.Objects[0].Rect), Equals, true)
+				changed++
+			}
+		}
+	}
+	c.Check(changed > 8, Equals, true)
+}
+
+func (s *MySuite) TestNoise(c *C) {
+	sc := Scene{Width: 64, Height: 64, Format: imglib.PixelFormatRGBA, Noise: 4, Seed: 7}
+	img, err := sc.Render(0)
+	c.Assert(err, IsNil)
+	rgba := img.(*image.RGBA)
+	var sum, sumsq float64
+	n := 0
+	for i, v := range rgba.Pix {
+		if i%4 == 3 {
+			c.Assert(v, Equals, uint8(0xFF))
+			continue
+		}
+		d := float64(v) - 128
+		sum += d
+		sumsq += d * d
+		n++
+	}
+	mean := sum / float64(n)
+	c.Check(math.Abs(mean) < 0.5, Equals, true, Commentf("mean %f", mean))
+	sd := math.Sqrt(sumsq/float64(n) - mean*mean)
+	c.Check(math.Abs(sd-4) < 0.5, Equals, true, Commentf("sd %f", sd))
+
+	// Each frame has its own noise.
+	other, _ := sc.Render(1)
+	c.Check(other, Not(DeepEquals), img)
+}
+
+func (s *MySuite) TestSequence(c *C) {
+	t0 := time.Unix(1400000000, 0)
+	sc := Scene{Width: 8, Height: 4, Format: imglib.PixelFormatYUYV, Start: t0, Period: time.Second,
+		Objects: []Object{{Rect: image.Rect(0, 0, 2, 2), DX: 1}}}
+	seq := sc.Sequence(5)
+	c.Check(seq.Len(), Equals, 5)
+	ii := seq.Info(2)
+	c.Check(ii.SeqNum, Equals, 2)
+	c.Check(ii.CreationTs.Equal(t0.Add(2*time.Second)), Equals, true)
+	c.Check(ii.Meta.Rects, DeepEquals, []image.Rectangle{image.Rect(2, 0, 4, 2)})
+	img, err := seq.At(2)
+	c.Assert(err, IsNil)
+	c.Check(img.GetImgInfo(), DeepEquals, ii)
+	c.Check(img.GetImage(), DeepEquals, func() image.Image { i, _ := sc.Render(2); return i }())
+	_, err = seq.At(5)
+	c.Check(err, NotNil)
+	c.Check(seq.IndexAt(t0.Add(1500*time.Millisecond)), Equals, 2)
+	c.Check(seq.IndexAt(t0.Add(time.Hour)), Equals, 5)
+	c.Check(seq.Close(), IsNil)
+}
@@ -1,7 +1,9 @@
 package v4l
 
 import "fmt"
+import "sync/atomic"
 import "time"
+import "code.google.com/p/ncabatoff/imglib"
 import "code.google.com/p/ncabatoff/imgseq"
 import "github.com/golang/glog"
 
@@ -11,16 +13,24 @@ type CaptureStream struct {
 	dev *Device
 	output chan imgseq.Img
 	done chan struct{}
+	orient int32
 }
 
 // NewStream opens and initializes the device and starts streaming captured
 // images.  Any errors result in a call to glog.Fatalf.  This also applies
 // to errors experienced subsequent to opening the device, i.e. while streaming.
 func NewStream(device string, fps int, pxlfmt string, width int, height int, output chan imgseq.Img) *CaptureStream {
+	return NewOrientedStream(device, fps, pxlfmt, width, height, imglib.OrientNone, output)
+}
+
+// NewOrientedStream is like NewStream except that each captured image is
+// transformed by o before being sent to output, e.g. to correct for a camera
+// mounted upside down.  Note that the output images will be o.Size(image.Point{width, height}).
+func NewOrientedStream(device string, fps int, pxlfmt string, width int, height int, o imglib.Orientation, output chan imgseq.Img) *CaptureStream {
 	if output == nil {
 		output = make(chan imgseq.Img)
 	}
-	cs := &CaptureStream{output: output, done: make(chan struct{})}
+	cs := &CaptureStream{output: output, done: make(chan struct{}), orient: int32(o)}
 
 	dev, err := OpenDevice(device, false)
 	if err != nil {
@@ -53,6 +63,24 @@ func (cs *CaptureStream) GetOutput() <-chan imgseq.Img {
 	return cs.output
 }
 
+// SetOrientation sets the transform applied to each captured image before
+// it's sent to the output channel.  It may be called while streaming.
+func (cs *CaptureStream) SetOrientation(o imglib.Orientation) {
+	atomic.StoreInt32(&cs.orient, int32(o))
+}
+
+// GetOrientation returns the transform set by SetOrientation.
+func (cs *CaptureStream) GetOrientation() imglib.Orientation {
+	return imglib.Orientation(atomic.LoadInt32(&cs.orient))
+}
+
+// GetFps returns the frame rate the device is configured for, as the
+// fraction num/den frames per second.
+func (cs *CaptureStream) GetFps() (num, den int) {
+	nom, denom := cs.dev.GetFps()
+	return denom, nom
+}
+
 // Shutdown stops capturing.
 func (cs *CaptureStream) Shutdown() {
 	cs.done <- struct{}{}
@@ -156,6 +184,9 @@ func (cs *CaptureStream) fetchImages() {
 		if ps, err := safeframe.GetPixelSequence(); err != nil {
 			glog.Fatalf("error getting pixel seq: %v", err)
 		} else {
+			if o := cs.GetOrientation(); o != imglib.OrientNone {
+				*ps = imglib.GetPixelSequence(imglib.Orient(ps.GetImage(), o))
+			}
 			iinfo := imgseq.ImgInfo{SeqNum: i, CreationTs: safeframe.ReqTime}
 			select {
 			case <-cs.done:
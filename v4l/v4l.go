@@ -518,18 +518,17 @@ func (f Frame) GetImage() (image.Image, error) {
 	return nil, fmt.Errorf("can't get image from frame of format %d", f.Format.FormatId)
 }
 
+// GetPixelSequence builds a PixelSequence from the provided Frame.  Only the
+// YUYV and RGB24 formats are supported.
 func (f Frame) GetPixelSequence() (*imglib.PixelSequence, error) {
-	ps := imglib.PixelSequence{Dx: f.Width, Dy: f.Height}
 	switch f.Format.FormatId {
-	case FormatYuyv:
-		img := imglib.NewYUYV(image.Rect(0, 0, f.Format.Width, f.Format.Height))
-		copy(img.Pix, f.Pix)
-		ps.ImageBytes = imglib.YuyvBytes(img.Pix)
-	case FormatRgb:
-		img := imglib.NewRGB(image.Rect(0, 0, f.Format.Width, f.Format.Height))
-		copy(img.Pix, f.Pix)
-		ps.ImageBytes = imglib.RgbBytes(img.Pix)
-	return nil, fmt.Errorf("can't get pixel seq from frame of format %d", f.Format.FormatId)
+	case FormatYuyv, FormatRgb:
+		if img, err := f.GetImage(); err != nil {
+			return nil, err
+		} else {
+			ps := imglib.GetPixelSequence(img)
+			return &ps, nil
+		}
 	}
-	return &ps, nil
+	return nil, fmt.Errorf("can't get pixel seq from frame of format %d", f.Format.FormatId)
 }
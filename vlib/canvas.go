@@ -42,6 +42,37 @@ type chans struct {
 
 type ImageFetcher func(i int) (int, []imgseq.Img)
 
+// SequenceFetcher returns an ImageFetcher showing the images of seq one at a
+// time, wrapping around at either end.  Images which can't be loaded are
+// logged and skipped over in the direction of travel, so a corrupt file
+// doesn't stop playback.
+func SequenceFetcher(seq imgseq.Sequence) ImageFetcher {
+	last := 0
+	return func(i int) (int, []imgseq.Img) {
+		n := seq.Len()
+		step := 1
+		if i < last {
+			step = -1
+		}
+		for tries := 0; tries < n; tries++ {
+			if i >= n {
+				i = 0
+			}
+			if i < 0 {
+				i = n - 1
+			}
+			img, err := seq.At(i)
+			if err == nil {
+				last = i
+				return i, []imgseq.Img{img}
+			}
+			glog.Errorf("skipping image %d: %v", i, err)
+			i += step
+		}
+		return i, nil
+	}
+}
+
 type canv struct {
 	chans
 	imgs []*xgraphics.Image